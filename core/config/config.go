@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -12,13 +13,152 @@ type ModelConfig struct {
 	Type   string `yaml:"type"` // e.g., "blip", "clip", "gguf"
 	Path   string `yaml:"path"`
 	Device string `yaml:"device"` // e.g., "cpu", "cuda"
+
+	// Lifecycle is when the engine loads and unloads the model: "eager" (default),
+	// "lazy", "idle_timeout" or "pinned".
+	Lifecycle   string        `yaml:"lifecycle"`
+	IdleTimeout time.Duration `yaml:"idle_timeout"` // For "idle_timeout", e.g. "10m"
+
+	// Restart is what the engine does when the model's process dies.
+	Restart RestartConfig `yaml:"restart"`
+	// Process sets the environment and resource limits of the model's process.
+	Process ProcessConfig `yaml:"process"`
+	// Workers is how many requests the scheduler runs on the model at once; defaults to
+	// scheduler.workers.
+	Workers int `yaml:"workers"`
+
+	// GGUF models only.
+	Backend   string `yaml:"backend"`    // "cli" (default) or "server"
+	ServerURL string `yaml:"server_url"` // Attach to a running llama-server instead of launching one
+
+	// Settings holds model-type specific options. It is kept as raw YAML and decoded by the
+	// plugin for the model's type, e.g. into llm.Settings for GGUF models.
+	Settings yaml.Node `yaml:"settings"`
+}
+
+// RestartConfig controls how a model is restarted after its process dies.
+type RestartConfig struct {
+	Policy      string        `yaml:"policy"`       // "on_failure" (default), "always" or "never"
+	MaxRestarts int           `yaml:"max_restarts"` // Consecutive restarts before giving up; 0 means 5, negative means no limit
+	Backoff     time.Duration `yaml:"backoff"`      // Delay before the first restart, doubled after each; defaults to 1s
+	MaxBackoff  time.Duration `yaml:"max_backoff"`  // Upper bound on the delay; defaults to 1m
+}
+
+// ProcessConfig controls how a model's process is started, confined and stopped.
+// Limits, priority and affinity are only supported on Linux.
+type ProcessConfig struct {
+	Dir         string            `yaml:"dir"`          // Working directory
+	Env         map[string]string `yaml:"env"`          // Added to the server's environment
+	StopTimeout time.Duration     `yaml:"stop_timeout"` // Grace period before SIGTERM, then SIGKILL; defaults to 10s
+
+	MaxMemoryMB   int `yaml:"max_memory_mb"`   // Address space limit (RLIMIT_AS)
+	MaxCPUSeconds int `yaml:"max_cpu_seconds"` // CPU time limit (RLIMIT_CPU)
+	MaxOpenFiles  int `yaml:"max_open_files"`  // Open file limit (RLIMIT_NOFILE)
+
+	CgroupMemoryMB int     `yaml:"cgroup_memory_mb"` // cgroup v2 memory.max
+	CgroupCPUs     float64 `yaml:"cgroup_cpus"`      // cgroup v2 cpu.max, in cores
+	CgroupParent   string  `yaml:"cgroup_parent"`    // Defaults to /sys/fs/cgroup/llm-cortex
+
+	Nice int   `yaml:"nice"` // Scheduling priority, -20 to 19
+	CPUs []int `yaml:"cpus"` // CPU affinity
+
+	OutputBufferKB  int    `yaml:"output_buffer_kb"`  // Output kept in memory per stream; defaults to 1024
+	TranscriptDir   string `yaml:"transcript_dir"`    // Copy all output to rotated files in this directory
+	TranscriptMaxMB int    `yaml:"transcript_max_mb"` // Size at which a transcript is rotated; defaults to 10
+	TranscriptFiles int    `yaml:"transcript_files"`  // Rotated transcripts kept; defaults to 3
+
+	RecordingDir string `yaml:"recording_dir"` // Record stdin and stdout in asciicast format here
+}
+
+// MemoryConfig bounds the memory used by loaded models.
+type MemoryConfig struct {
+	LimitMB      int           `yaml:"limit_mb"`      // 0 derives the limit from RAM and swap
+	HeadroomMB   int           `yaml:"headroom_mb"`   // Kept free for the OS; defaults to 1024
+	SwapFraction float64       `yaml:"swap_fraction"` // Share of swap models may use, 0 to 1
+	QueueTimeout time.Duration `yaml:"queue_timeout"` // How long a load waits for memory, e.g. "30s"
+}
+
+// AuthConfig lists the API keys the server accepts.
+type AuthConfig struct {
+	Keys []APIKeyConfig `yaml:"keys"`
+}
+
+// APIKeyConfig is a single API key and what it may do.
+type APIKeyConfig struct {
+	Name        string   `yaml:"name"`        // Identifies the caller, e.g. as the owner of its shells
+	Key         string   `yaml:"key"`         // The secret itself...
+	KeyEnv      string   `yaml:"key_env"`     // ...or the environment variable holding it
+	Permissions []string `yaml:"permissions"` // "inference" and/or "shell"
+}
+
+// ShellConfig restricts the interactive shell API.
+type ShellConfig struct {
+	// AllowUnauthenticated serves shells without an API key when no keys are configured.
+	// Anyone who can reach the port then gets a shell as the server's user.
+	AllowUnauthenticated bool `yaml:"allow_unauthenticated"`
+
+	Allow []string `yaml:"allow"` // Regular expressions; if set, commands must match one
+	Deny  []string `yaml:"deny"`  // Regular expressions; matching commands are refused
+
+	Sandbox SandboxConfig `yaml:"sandbox"`
+
+	// RecordingDir records every shell in asciicast format here, for the recording API.
+	RecordingDir string `yaml:"recording_dir"`
+
+	MaxSessions          int           `yaml:"max_sessions"`            // Shells running at once; 0 is unlimited
+	MaxSessionsPerClient int           `yaml:"max_sessions_per_client"` // Shells running at once per API key; 0 is unlimited
+	IdleTimeout          time.Duration `yaml:"idle_timeout"`            // Close shells without input or output; defaults to 30m, negative never does
+	MaxLifetime          time.Duration `yaml:"max_lifetime"`            // Close shells open for this long; 0 never does
+}
+
+// SandboxConfig confines shells started through the API. Linux only, and the server
+// must run as root.
+type SandboxConfig struct {
+	User       string   `yaml:"user"`       // Run shells as this user
+	Namespaces bool     `yaml:"namespaces"` // Private mount, PID, IPC and UTS namespaces
+	NoNetwork  bool     `yaml:"no_network"` // No network access but loopback
+	Chroot     string   `yaml:"chroot"`     // Directory shells see as /
+	ReadOnly   []string `yaml:"read_only"`  // Paths shells may only read, e.g. the models directory; needs namespaces
+}
+
+// SchedulerConfig sizes the queues requests wait in for their model.
+type SchedulerConfig struct {
+	Workers  int `yaml:"workers"`   // Requests run at once per model; defaults to 1
+	MaxQueue int `yaml:"max_queue"` // Requests waiting per model; defaults to 64, negative is unlimited
+}
+
+// PipelineConfig chains model calls into a named graph of steps, run with
+// POST /api/v1/pipelines/{name}/run.
+type PipelineConfig struct {
+	Name    string               `yaml:"name"`
+	Output  string               `yaml:"output"`  // The step whose output is the pipeline's; defaults to the last one
+	Timeout time.Duration        `yaml:"timeout"` // Limit on the whole run, e.g. "2m"
+	Steps   []PipelineStepConfig `yaml:"steps"`
+}
+
+// PipelineStepConfig is one model call in a pipeline. Strings in its inputs may refer to
+// the pipeline's input as ${input.key} and to earlier outputs as ${step.path}.
+type PipelineStepConfig struct {
+	Name       string                 `yaml:"name"`
+	Model      string                 `yaml:"model"`
+	Capability string                 `yaml:"capability"` // e.g. "caption", "vqa", "classify", "completion" or "chat"
+	Inputs     map[string]interface{} `yaml:"inputs"`     // Parameters, as in the body of the capability's endpoint
+	After      []string               `yaml:"after"`      // Steps to wait for besides those the inputs refer to
+	Optional   bool                   `yaml:"optional"`   // The pipeline still succeeds, as partial, if the step fails
+	Timeout    time.Duration          `yaml:"timeout"`    // Limit on the step, including time queued
 }
 
 // AppConfig holds all configuration for the application.
 type AppConfig struct {
-	PythonVenvPath string        `yaml:"python_venv_path"`
-	ServerPort     string        `yaml:"server_port"`
-	Models         []ModelConfig `yaml:"models"`
+	PythonVenvPath string           `yaml:"python_venv_path"`
+	ServerPort     string           `yaml:"server_port"`
+	ImageDir       string           `yaml:"image_dir"` // Where clients may point image_path; empty accepts only uploads
+	Auth           AuthConfig       `yaml:"auth"`
+	Shell          ShellConfig      `yaml:"shell"`
+	Memory         MemoryConfig     `yaml:"memory"`
+	Scheduler      SchedulerConfig  `yaml:"scheduler"`
+	Models         []ModelConfig    `yaml:"models"`
+	Pipelines      []PipelineConfig `yaml:"pipelines"`
 }
 
 // Load returns a new configuration for the application, loading values
@@ -0,0 +1,98 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/owen-6936/llm-cortex/core/config"
+	"github.com/owen-6936/llm-cortex/handlers"
+	"github.com/owen-6936/llm-cortex/spawn"
+)
+
+// newAuth builds the API key checks from the auth and shell sections of the config.
+// Without keys the inference API stays open, as it always has been, but shells are only
+// served if the config explicitly allows it.
+func newAuth(cfg *config.AppConfig) (*handlers.Auth, error) {
+	opts := handlers.AuthOptions{Unauthenticated: []handlers.Permission{handlers.PermissionInference}}
+	if cfg.Shell.AllowUnauthenticated {
+		opts.Unauthenticated = append(opts.Unauthenticated, handlers.PermissionShell)
+	}
+	names := make(map[string]bool)
+	secrets := make(map[string]bool)
+	for _, k := range cfg.Auth.Keys {
+		if k.Name == "" {
+			return nil, fmt.Errorf("every API key needs a name")
+		}
+		if names[k.Name] {
+			return nil, fmt.Errorf("duplicate API key name '%s'", k.Name)
+		}
+		names[k.Name] = true
+		secret := k.Key
+		if k.KeyEnv != "" {
+			if secret != "" {
+				return nil, fmt.Errorf("API key '%s' sets both key and key_env", k.Name)
+			}
+			secret = os.Getenv(k.KeyEnv)
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("API key '%s' has no secret; set key or key_env", k.Name)
+		}
+		if secrets[secret] {
+			return nil, fmt.Errorf("API key '%s' reuses the secret of another key", k.Name)
+		}
+		secrets[secret] = true
+		key := handlers.APIKey{Name: k.Name, Key: secret}
+		for _, p := range k.Permissions {
+			switch permission := handlers.Permission(p); permission {
+			case handlers.PermissionInference, handlers.PermissionShell:
+				key.Permissions = append(key.Permissions, permission)
+			default:
+				return nil, fmt.Errorf("unknown permission '%s' for API key '%s'", p, k.Name)
+			}
+		}
+		opts.Keys = append(opts.Keys, key)
+	}
+	return handlers.NewAuth(opts), nil
+}
+
+// DefaultShellIdleTimeout closes shells that set no shell.idle_timeout, such as those
+// left behind in a closed browser tab, after this long without input or output.
+const DefaultShellIdleTimeout = 30 * time.Minute
+
+// newShellOptions converts the shell section of the config into handler options.
+func newShellOptions(cfg config.ShellConfig) (handlers.ShellOptions, error) {
+	policy, err := handlers.NewCommandPolicy(cfg.Allow, cfg.Deny)
+	if err != nil {
+		return handlers.ShellOptions{}, fmt.Errorf("invalid shell command policy: %w", err)
+	}
+	sb := cfg.Sandbox
+	if len(sb.ReadOnly) > 0 && !sb.Namespaces {
+		return handlers.ShellOptions{}, fmt.Errorf("shell.sandbox.read_only needs shell.sandbox.namespaces")
+	}
+	if cfg.MaxSessions < 0 || cfg.MaxSessionsPerClient < 0 || cfg.MaxLifetime < 0 {
+		return handlers.ShellOptions{}, fmt.Errorf("shell session limits must not be negative")
+	}
+	idleTimeout := cfg.IdleTimeout
+	switch {
+	case idleTimeout == 0:
+		idleTimeout = DefaultShellIdleTimeout
+	case idleTimeout < 0:
+		idleTimeout = 0
+	}
+	return handlers.ShellOptions{
+		Policy:               policy,
+		RecordingDir:         cfg.RecordingDir,
+		MaxSessions:          cfg.MaxSessions,
+		MaxSessionsPerClient: cfg.MaxSessionsPerClient,
+		IdleTimeout:          idleTimeout,
+		MaxLifetime:          cfg.MaxLifetime,
+		Sandbox: spawn.Sandbox{
+			User:       sb.User,
+			Namespaces: sb.Namespaces,
+			NoNetwork:  sb.NoNetwork,
+			Chroot:     sb.Chroot,
+			ReadOnly:   sb.ReadOnly,
+		},
+	}, nil
+}
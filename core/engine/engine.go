@@ -1,37 +1,90 @@
 package engine
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/owen-6936/llm-cortex/core/config"
+	"github.com/owen-6936/llm-cortex/core/memory"
+	_ "github.com/owen-6936/llm-cortex/core/models/llm" // Registers the "gguf" plugin
 	"github.com/owen-6936/llm-cortex/core/models/vision"
+	"github.com/owen-6936/llm-cortex/core/plugin"
 	"github.com/owen-6936/llm-cortex/handlers"
+	"github.com/owen-6936/llm-cortex/scheduler"
+	"github.com/owen-6936/llm-cortex/spawn"
 )
 
 // Engine is the central orchestrator for the application.
 // It manages the lifecycle of models and other core services.
 type Engine struct {
-	config       *config.AppConfig
-	modelPlugins map[string]interface{} // A simple map to hold loaded model instances
+	config        *config.AppConfig
+	memory        *memory.Budget                 // Admits model loads and evicts idle models
+	modelPlugins  map[string]*managedModel       // Configured model plugins, keyed by model name
+	modelOrder    []string                       // Model names in config file order
+	shells        *spawn.Manager                 // Sessions opened through the shell API
+	shellOpts     handlers.ShellOptions          // Command policy and sandbox for the shell API
+	auth          *handlers.Auth                 // API key checks for the HTTP endpoints
+	scheduler     *scheduler.Scheduler           // Queues model requests for each model's workers
+	pipelines     map[string]*scheduler.Pipeline // Configured pipelines, keyed by name
+	pipelineOrder []string                       // Pipeline names in config file order
 }
 
 // New creates a new application engine.
 func New(cfg *config.AppConfig) (*Engine, error) {
+	if cfg.Memory.SwapFraction < 0 || cfg.Memory.SwapFraction > 1 {
+		return nil, fmt.Errorf("memory.swap_fraction must be between 0 and 1, got %g", cfg.Memory.SwapFraction)
+	}
+	if cfg.Scheduler.Workers < 0 {
+		return nil, fmt.Errorf("scheduler.workers must not be negative")
+	}
+	auth, err := newAuth(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth config: %w", err)
+	}
+	shellOpts, err := newShellOptions(cfg.Shell)
+	if err != nil {
+		return nil, err
+	}
 	return &Engine{
-		config:       cfg,
-		modelPlugins: make(map[string]interface{}),
+		config: cfg,
+		memory: memory.NewBudget(memory.Options{
+			Limit:        uint64(cfg.Memory.LimitMB) << 20,
+			Headroom:     uint64(cfg.Memory.HeadroomMB) << 20,
+			SwapFraction: cfg.Memory.SwapFraction,
+			QueueTimeout: cfg.Memory.QueueTimeout,
+		}),
+		modelPlugins: make(map[string]*managedModel),
+		shells:       spawn.NewManager(),
+		shellOpts:    shellOpts,
+		auth:         auth,
+		scheduler: scheduler.New(scheduler.Options{
+			Workers:  cfg.Scheduler.Workers,
+			MaxQueue: cfg.Scheduler.MaxQueue,
+		}),
+		pipelines: make(map[string]*scheduler.Pipeline),
 	}, nil
 }
 
-// Start initializes and starts all the core services.
+// Start initializes and starts all the core services. It returns once the server has
+// stopped, after unloading every model and closing every session, whether it stopped
+// because of a signal or failed to start.
 func (e *Engine) Start() error {
+	defer e.Shutdown()
+
 	// 1. Initialize models based on configuration
 	if err := e.initializeModels(); err != nil {
 		return fmt.Errorf("failed to initialize models: %w", err)
 	}
+	if err := e.initializePipelines(); err != nil {
+		return fmt.Errorf("failed to initialize pipelines: %w", err)
+	}
 
 	// 2. Set up HTTP server and handlers
 	mux := http.NewServeMux()
@@ -40,45 +93,166 @@ func (e *Engine) Start() error {
 	os := http.FileServer(http.Dir("ui"))
 	mux.Handle("/", os)
 
-	// Shell handlers
-	mux.HandleFunc("/shell/start", handlers.StartShellHandler)
-	mux.HandleFunc("/shell/", func(w http.ResponseWriter, r *http.Request) {
+	// Shell handlers, for API keys with the shell permission
+	shells := handlers.NewShells(e.shells, e.shellOpts)
+	mux.Handle("/shell", e.auth.Require(handlers.PermissionShell, http.HandlerFunc(shells.ListShellsHandler)))
+	mux.Handle("/shell/start", e.auth.Require(handlers.PermissionShell, http.HandlerFunc(shells.StartShellHandler)))
+	mux.Handle("/shell/recordings", e.auth.Require(handlers.PermissionShell, http.HandlerFunc(shells.ListRecordingsHandler)))
+	mux.Handle("/shell/recordings/", e.auth.Require(handlers.PermissionShell, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/replay") {
+			shells.ReplayRecordingHandler(w, r)
+		} else {
+			shells.DownloadRecordingHandler(w, r)
+		}
+	})))
+	mux.Handle("/shell/", e.auth.Require(handlers.PermissionShell, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		switch {
 		case strings.HasSuffix(r.URL.Path, "/send"):
-			handlers.SendCommandHandler(w, r)
+			shells.SendCommandHandler(w, r)
 		case strings.HasSuffix(r.URL.Path, "/stream"):
-			handlers.StreamOutputHandler(w, r)
+			shells.StreamOutputHandler(w, r)
 		case strings.HasSuffix(r.URL.Path, "/close"):
-			handlers.CloseShellHandler(w, r)
+			shells.CloseShellHandler(w, r)
+		case strings.HasSuffix(r.URL.Path, "/events"):
+			shells.ShellEventsHandler(w, r)
+		case strings.HasSuffix(r.URL.Path, "/ws"):
+			shells.ShellSocketHandler(w, r)
 		default:
 			http.NotFound(w, r)
 		}
-	})
+	})))
 
-	// TODO: Add API handlers that use the loaded modelPlugins
+	// Inference API backed by the loaded modelPlugins
+	inference := http.NewServeMux()
+	handlers.NewAPI(e, handlers.APIOptions{ImageDir: e.config.ImageDir}).Register(inference)
+	// OpenAI-compatible facade over the GGUF models
+	handlers.NewOpenAI(e).Register(inference)
+	mux.Handle("/api/", e.auth.Require(handlers.PermissionInference, inference))
+	mux.Handle("/v1/", e.auth.Require(handlers.PermissionInference, inference))
 
-	// 3. Start the server
+	// 3. Start the server, and stop every process we started when asked to shut down
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	go shells.Reap(ctx)
+	server := &http.Server{Addr: ":" + e.config.ServerPort, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
 	log.Printf("Starting server at port %s", e.config.ServerPort)
-	return http.ListenAndServe(":"+e.config.ServerPort, mux)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
 }
 
-// initializeModels loads all models specified in the config file.
+// Shutdown unloads every model and closes every shell session.
+func (e *Engine) Shutdown() {
+	log.Println("--- Shutting down ---")
+	// Let requests that are running finish; those still queued fail at once.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.scheduler.Close(ctx); err != nil {
+		log.Printf("Warning: model requests still running at shutdown: %v", err)
+	}
+	for _, name := range e.modelOrder {
+		if err := e.modelPlugins[name].Unload(); err != nil {
+			log.Printf("Warning: failed to unload model '%s': %v", name, err)
+		}
+	}
+	if err := e.shells.CloseAll(); err != nil {
+		log.Printf("Warning: shell sessions did not exit cleanly: %v", err)
+	}
+	// Catch any model process a plugin started but did not stop, such as after a failed load.
+	if err := spawn.Default().CloseAll(); err != nil {
+		log.Printf("Warning: model processes did not exit cleanly: %v", err)
+	}
+}
+
+// initializeModels creates a plugin for every model in the config file and loads the
+// eager and pinned ones; lazy models are loaded by their first request. A model that fails
+// to load is kept with an unloaded status instead of aborting startup, so one missing
+// model directory doesn't take the whole server down. Loads go through the memory budget,
+// so models that don't fit evict idle ones or stay unloaded until a request needs them.
 func (e *Engine) initializeModels() error {
 	log.Println("--- Initializing Models from Config ---")
 	vision.PythonVenvPath = e.config.PythonVenvPath // Set the python path for vision models
 
 	for _, modelCfg := range e.config.Models {
-		log.Printf("Loading model: %s (type: %s, path: %s)", modelCfg.Name, modelCfg.Type, modelCfg.Path)
-		switch modelCfg.Type {
-		case "blip", "clip", "cliption":
-			// For now, we are just logging. The next step is to create a plugin interface.
-			// For example: plugin, err := vision.New(modelCfg)
-		case "gguf":
-			// For example: plugin, err := llm.NewGGUFModel(...)
-		default:
-			log.Printf("Warning: Unknown model type '%s' for model '%s'", modelCfg.Type, modelCfg.Name)
+		if _, exists := e.modelPlugins[modelCfg.Name]; exists {
+			return fmt.Errorf("duplicate model name '%s' in config", modelCfg.Name)
+		}
+		inner, err := plugin.New(modelCfg)
+		if err != nil {
+			log.Printf("Warning: %v (known types: %v)", err, plugin.Types())
+			continue
+		}
+		if modelCfg.Workers < 0 {
+			return fmt.Errorf("workers for model '%s' must not be negative", modelCfg.Name)
 		}
+		p, err := newManagedModel(inner, modelCfg, e.memory, e.scheduler)
+		if err != nil {
+			return err
+		}
+		e.scheduler.SetWorkers(modelCfg.Name, modelCfg.Workers)
+		e.modelPlugins[modelCfg.Name] = p
+		e.modelOrder = append(e.modelOrder, modelCfg.Name)
+
+		if !p.loadsAtStartup() {
+			log.Printf("Model %s will load on first request (lifecycle: %s)", modelCfg.Name, p.policy)
+			continue
+		}
+		log.Printf("Loading model: %s (type: %s, path: %s, estimated memory: %s)", modelCfg.Name, modelCfg.Type, modelCfg.Path, memory.FormatBytes(p.footprint))
+		if err := p.Load(context.Background()); err != nil {
+			log.Printf("Warning: failed to load model '%s': %v", modelCfg.Name, err)
+			continue
+		}
+		log.Printf("Model ready: %s (capabilities: %v)", modelCfg.Name, p.Capabilities())
 	}
 	log.Println("--- Model Initialization Complete ---")
+	go e.reapIdleModels()
 	return nil
-}
\ No newline at end of file
+}
+
+// reapIdleModels periodically unloads idle_timeout models that have not been used for
+// their timeout. It checks at a quarter of the shortest timeout, but at least every 30s.
+func (e *Engine) reapIdleModels() {
+	interval := 30 * time.Second
+	for _, p := range e.modelPlugins {
+		if p.policy == LifecycleIdleTimeout && p.idleTimeout/4 < interval {
+			interval = max(p.idleTimeout/4, time.Second)
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		for _, p := range e.modelPlugins {
+			p.reapIfIdle(now)
+		}
+	}
+}
+
+// Model returns the plugin configured under name.
+func (e *Engine) Model(name string) (plugin.ModelPlugin, bool) {
+	p, ok := e.modelPlugins[name]
+	if !ok {
+		return nil, false
+	}
+	return p, true
+}
+
+// SchedulerStats reports the queue and workers of every model that has had requests.
+func (e *Engine) SchedulerStats() []scheduler.Stats {
+	return e.scheduler.Stats()
+}
+
+// Models returns all configured plugins in config file order.
+func (e *Engine) Models() []plugin.ModelPlugin {
+	models := make([]plugin.ModelPlugin, 0, len(e.modelOrder))
+	for _, name := range e.modelOrder {
+		models = append(models, e.modelPlugins[name])
+	}
+	return models
+}
@@ -0,0 +1,407 @@
+package engine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/owen-6936/llm-cortex/core/config"
+	"github.com/owen-6936/llm-cortex/core/memory"
+	"github.com/owen-6936/llm-cortex/core/plugin"
+	"github.com/owen-6936/llm-cortex/scheduler"
+	"github.com/owen-6936/llm-cortex/spawn"
+)
+
+// Lifecycle policies, set per model with `lifecycle:` in config.yaml.
+const (
+	LifecycleEager       = "eager"        // Loaded at startup; may be evicted for memory
+	LifecycleLazy        = "lazy"         // Loaded by the first request that needs it
+	LifecycleIdleTimeout = "idle_timeout" // Lazy, and unloaded after idle_timeout without requests
+	LifecyclePinned      = "pinned"       // Loaded at startup and never unloaded by the engine
+)
+
+// DefaultIdleTimeout applies to idle_timeout models that don't set idle_timeout.
+const DefaultIdleTimeout = 10 * time.Minute
+
+// Restart policies, set per model with `restart.policy:` in config.yaml.
+const (
+	RestartOnFailure = "on_failure" // Restart after a crash or a non-zero exit
+	RestartAlways    = "always"     // Restart whenever the process exits on its own
+	RestartNever     = "never"      // Leave the model failed until the server restarts
+)
+
+// Restart defaults for models that don't configure them.
+const (
+	DefaultMaxRestarts = 5
+	DefaultBackoff     = time.Second
+	DefaultMaxBackoff  = time.Minute
+)
+
+// stableUptime is how long a restarted model must stay up before its restart count is reset.
+const stableUptime = 10 * time.Minute
+
+// errModelInUse is returned when eviction is attempted on a model serving a request.
+var errModelInUse = errors.New("model is serving a request")
+
+// managedModel wraps a plugin so that its loads go through the engine's memory budget
+// and follow the model's lifecycle policy. When it is evicted or reaped for being idle,
+// it is unloaded and loaded again by the next request. When its process dies, it is
+// restarted according to its restart policy.
+type managedModel struct {
+	plugin.ModelPlugin
+	budget      *memory.Budget
+	scheduler   *scheduler.Scheduler // Queues requests for the model's workers
+	footprint   uint64
+	policy      string
+	idleTimeout time.Duration
+	restart     config.RestartConfig
+
+	mu           sync.Mutex // Serializes Load, Unload, eviction, reaping and restarts
+	generation   int        // Incremented by every successful load
+	loadedAt     time.Time
+	restartTimer *time.Timer // Pending restart, if any
+
+	stateMu  sync.Mutex // Guards the fields below, which are read without waiting for a load
+	state    plugin.LoadState
+	lastUsed time.Time
+	inFlight int
+	restarts int // Consecutive restarts since the model was last stable; written under mu too
+	lastExit *plugin.ProcessExit
+}
+
+func newManagedModel(p plugin.ModelPlugin, cfg config.ModelConfig, budget *memory.Budget, sched *scheduler.Scheduler) (*managedModel, error) {
+	m := &managedModel{
+		ModelPlugin: p,
+		budget:      budget,
+		scheduler:   sched,
+		policy:      cfg.Lifecycle,
+		idleTimeout: cfg.IdleTimeout,
+		restart:     cfg.Restart,
+		state:       plugin.LoadStateUnloaded,
+	}
+	switch m.policy {
+	case "":
+		m.policy = LifecycleEager
+	case LifecycleEager, LifecycleLazy, LifecyclePinned:
+	case LifecycleIdleTimeout:
+		if m.idleTimeout <= 0 {
+			m.idleTimeout = DefaultIdleTimeout
+		}
+	default:
+		return nil, fmt.Errorf("unknown lifecycle '%s' for model '%s'", cfg.Lifecycle, cfg.Name)
+	}
+	switch m.restart.Policy {
+	case "":
+		m.restart.Policy = RestartOnFailure
+	case RestartOnFailure, RestartAlways, RestartNever:
+	default:
+		return nil, fmt.Errorf("unknown restart policy '%s' for model '%s'", cfg.Restart.Policy, cfg.Name)
+	}
+	if p := cfg.Process; p.MaxMemoryMB < 0 || p.MaxCPUSeconds < 0 || p.MaxOpenFiles < 0 || p.CgroupMemoryMB < 0 || p.CgroupCPUs < 0 {
+		return nil, fmt.Errorf("process limits for model '%s' must not be negative", cfg.Name)
+	}
+	if nice := cfg.Process.Nice; nice < -20 || nice > 19 {
+		return nil, fmt.Errorf("process nice for model '%s' must be between -20 and 19, got %d", cfg.Name, nice)
+	}
+	if p := cfg.Process; p.OutputBufferKB < 0 || p.TranscriptMaxMB < 0 || p.TranscriptFiles < 0 {
+		return nil, fmt.Errorf("output buffer and transcript sizes for model '%s' must not be negative", cfg.Name)
+	}
+	if m.restart.MaxRestarts == 0 {
+		m.restart.MaxRestarts = DefaultMaxRestarts
+	}
+	if m.restart.Backoff <= 0 {
+		m.restart.Backoff = DefaultBackoff
+	}
+	if m.restart.MaxBackoff <= 0 {
+		m.restart.MaxBackoff = DefaultMaxBackoff
+	}
+
+	if estimator, ok := p.(plugin.MemoryEstimator); ok {
+		footprint, err := estimator.EstimateMemory()
+		if err != nil {
+			log.Printf("Warning: could not estimate memory for model '%s': %v", p.Name(), err)
+		}
+		m.footprint = footprint
+	}
+	return m, nil
+}
+
+// loadsAtStartup reports whether the engine loads the model before serving requests.
+func (m *managedModel) loadsAtStartup() bool {
+	return m.policy == LifecycleEager || m.policy == LifecyclePinned
+}
+
+// Load reserves the model's footprint, evicting idle models if needed, and loads it.
+// While a crashed model waits to be restarted, or after it has been given up on, Load
+// fails with plugin.ErrNotLoaded.
+func (m *managedModel) Load(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch m.getState() {
+	case plugin.LoadStateLoaded:
+		return nil
+	case plugin.LoadStateRestarting:
+		return fmt.Errorf("%w: model '%s' crashed and is restarting", plugin.ErrNotLoaded, m.Name())
+	case plugin.LoadStateFailed:
+		return fmt.Errorf("%w: model '%s' crashed and was not restarted", plugin.ErrNotLoaded, m.Name())
+	}
+	return m.load(ctx)
+}
+
+func (m *managedModel) load(ctx context.Context) error {
+	// Pinned models are never offered up for eviction.
+	var evict func() error
+	if m.policy != LifecyclePinned {
+		evict = m.evict
+	}
+	done, err := m.budget.Reserve(ctx, m.Name(), m.footprint, evict)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	m.setState(plugin.LoadStateLoading)
+	start := time.Now()
+	if err := m.ModelPlugin.Load(ctx); err != nil {
+		m.budget.Release(m.Name())
+		m.setState(plugin.LoadStateUnloaded)
+		return err
+	}
+	m.setState(plugin.LoadStateLoaded)
+	m.generation++
+	m.loadedAt = time.Now()
+	log.Printf("Model '%s' loaded in %s", m.Name(), time.Since(start).Round(time.Millisecond))
+	m.supervise(m.generation)
+	return nil
+}
+
+// supervise watches the process started by a load and handles its death.
+func (m *managedModel) supervise(generation int) {
+	s, ok := m.ModelPlugin.(plugin.Supervised)
+	if !ok {
+		return
+	}
+	exited := s.Exited()
+	if exited == nil {
+		return
+	}
+	go func() {
+		m.handleExit(generation, <-exited)
+	}()
+}
+
+// handleExit cleans up after a process that died on its own and schedules its restart.
+// Exits of processes from an earlier load, or caused by unloading, are ignored.
+func (m *managedModel) handleExit(generation int, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if generation != m.generation || m.getState() != plugin.LoadStateLoaded {
+		return
+	}
+
+	exit := &plugin.ProcessExit{Code: -1, At: time.Now()}
+	var exitErr *spawn.ExitError
+	if errors.As(err, &exitErr) {
+		exit = &plugin.ProcessExit{Code: exitErr.Code, Signal: exitErr.Signal, Stderr: exitErr.Stderr, At: exitErr.ExitedAt}
+	}
+	log.Printf("Model '%s' process exited unexpectedly: %v", m.Name(), err)
+	// Closing the dead session reports the same exit again; only other failures matter.
+	if err := m.unload(); err != nil && !errors.Is(err, spawn.ErrSessionExited) {
+		log.Printf("Warning: cleanup after model '%s' exited failed: %v", m.Name(), err)
+	}
+	m.stateMu.Lock()
+	m.lastExit = exit
+	if time.Since(m.loadedAt) >= stableUptime {
+		m.restarts = 0
+	}
+	m.stateMu.Unlock()
+
+	crashed := exitErr == nil || !exitErr.Success()
+	switch {
+	case m.restart.Policy == RestartNever:
+		m.setState(plugin.LoadStateFailed)
+	case m.restart.Policy == RestartOnFailure && !crashed:
+		// A clean exit is left alone; the next request loads the model again.
+	default:
+		m.scheduleRestart()
+	}
+}
+
+// scheduleRestart restarts the model after an exponential backoff, or marks it failed once
+// it has used up its restarts.
+func (m *managedModel) scheduleRestart() {
+	if m.restart.MaxRestarts >= 0 && m.restarts >= m.restart.MaxRestarts {
+		log.Printf("Model '%s' crashed %d times in a row; giving up", m.Name(), m.restarts+1)
+		m.setState(plugin.LoadStateFailed)
+		return
+	}
+	delay := m.restart.Backoff << m.restarts
+	if delay > m.restart.MaxBackoff || delay <= 0 {
+		delay = m.restart.MaxBackoff
+	}
+	m.stateMu.Lock()
+	m.restarts++
+	m.stateMu.Unlock()
+	m.setState(plugin.LoadStateRestarting)
+	log.Printf("Restarting model '%s' in %s (attempt %d)", m.Name(), delay, m.restarts)
+	m.restartTimer = time.AfterFunc(delay, m.restartNow)
+}
+
+func (m *managedModel) restartNow() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.getState() != plugin.LoadStateRestarting {
+		// Unloaded while the restart was pending.
+		return
+	}
+	m.restartTimer = nil
+	m.setState(plugin.LoadStateUnloaded)
+	if err := m.load(context.Background()); err != nil {
+		log.Printf("Warning: failed to restart model '%s': %v", m.Name(), err)
+		m.scheduleRestart()
+	}
+}
+
+// Invoke queues the request on the scheduler, at the priority carried by ctx, and runs
+// it once one of the model's workers is free. A queued request already keeps the model
+// from being evicted or reaped.
+func (m *managedModel) Invoke(ctx context.Context, req plugin.Request) (*plugin.Result, error) {
+	defer m.use()()
+	future, err := scheduler.Submit(ctx, m.scheduler, scheduler.JobOptions{
+		Model:    m.Name(),
+		Priority: scheduler.PriorityFrom(ctx),
+	}, func(ctx context.Context) (*plugin.Result, error) {
+		return m.invoke(ctx, req)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return future.Wait()
+}
+
+// use marks the model as serving a request, which keeps it from being evicted or reaped,
+// until the returned function is called.
+func (m *managedModel) use() func() {
+	m.stateMu.Lock()
+	m.inFlight++
+	m.lastUsed = time.Now()
+	m.stateMu.Unlock()
+	return func() {
+		m.stateMu.Lock()
+		m.inFlight--
+		m.lastUsed = time.Now()
+		m.stateMu.Unlock()
+	}
+}
+
+// invoke loads the model if it isn't loaded and runs the request.
+func (m *managedModel) invoke(ctx context.Context, req plugin.Request) (*plugin.Result, error) {
+	if err := m.Load(ctx); err != nil {
+		return nil, err
+	}
+	done := m.budget.Use(m.Name())
+	defer done()
+	return m.ModelPlugin.Invoke(ctx, req)
+}
+
+// Unload stops the model and returns its memory to the budget. A pending restart is
+// cancelled, and a failed model is reset so the next request may load it again.
+func (m *managedModel) Unload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.restartTimer != nil {
+		m.restartTimer.Stop()
+		m.restartTimer = nil
+	}
+	switch m.getState() {
+	case plugin.LoadStateRestarting, plugin.LoadStateFailed:
+		m.setState(plugin.LoadStateUnloaded)
+	}
+	return m.unload()
+}
+
+// evict is called by the budget to unload the model to make room for another one.
+func (m *managedModel) evict() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.busy() {
+		return errModelInUse
+	}
+	return m.unload()
+}
+
+// reapIfIdle unloads an idle_timeout model that hasn't served a request for its timeout.
+func (m *managedModel) reapIfIdle(now time.Time) {
+	if m.policy != LifecycleIdleTimeout {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stateMu.Lock()
+	idle := m.state == plugin.LoadStateLoaded && m.inFlight == 0 && now.Sub(m.lastUsed) >= m.idleTimeout
+	m.stateMu.Unlock()
+	if !idle {
+		return
+	}
+	log.Printf("Unloading model '%s' after %s idle", m.Name(), m.idleTimeout)
+	if err := m.unload(); err != nil {
+		log.Printf("Warning: failed to unload idle model '%s': %v", m.Name(), err)
+	}
+}
+
+func (m *managedModel) unload() error {
+	if m.getState() != plugin.LoadStateLoaded {
+		return nil
+	}
+	m.budget.Release(m.Name())
+	m.setState(plugin.LoadStateUnloaded)
+	return m.ModelPlugin.Unload()
+}
+
+func (m *managedModel) busy() bool {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	return m.inFlight > 0
+}
+
+func (m *managedModel) getState() plugin.LoadState {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	return m.state
+}
+
+func (m *managedModel) setState(state plugin.LoadState) {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	m.state = state
+	if state == plugin.LoadStateLoaded {
+		// The idle clock starts when the model is ready, not when it was first asked for.
+		m.lastUsed = time.Now()
+	}
+}
+
+// Lifecycle reports the model's policy and load state.
+func (m *managedModel) Lifecycle() plugin.LifecycleStatus {
+	m.stateMu.Lock()
+	defer m.stateMu.Unlock()
+	status := plugin.LifecycleStatus{Policy: m.policy, State: m.state, Restarts: m.restarts, LastExit: m.lastExit}
+	if m.policy == LifecycleIdleTimeout {
+		status.IdleTimeout = m.idleTimeout.String()
+	}
+	if !m.lastUsed.IsZero() {
+		lastUsed := m.lastUsed
+		status.LastUsed = &lastUsed
+	}
+	return status
+}
+
+// Describe forwards to the wrapped plugin, if it can describe its model.
+func (m *managedModel) Describe() interface{} {
+	if d, ok := m.ModelPlugin.(plugin.Describer); ok {
+		return d.Describe()
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/owen-6936/llm-cortex/core/config"
+	"github.com/owen-6936/llm-cortex/core/plugin"
+	"github.com/owen-6936/llm-cortex/scheduler"
+)
+
+// initializePipelines builds the pipelines in the config file. A pipeline that runs a
+// step on a model that failed to initialize is left out with a warning, like the model
+// itself; mistakes in a pipeline's own definition stop startup.
+func (e *Engine) initializePipelines() error {
+	configured := make(map[string]bool, len(e.config.Models))
+	for _, modelCfg := range e.config.Models {
+		configured[modelCfg.Name] = true
+	}
+	for _, pipelineCfg := range e.config.Pipelines {
+		if pipelineCfg.Name == "" {
+			return fmt.Errorf("a pipeline in config has no name")
+		}
+		if _, exists := e.pipelines[pipelineCfg.Name]; exists {
+			return fmt.Errorf("duplicate pipeline name '%s' in config", pipelineCfg.Name)
+		}
+		p := newPipeline(pipelineCfg)
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		if p.Timeout < 0 {
+			return fmt.Errorf("timeout of pipeline '%s' must not be negative", p.Name)
+		}
+		usable := true
+		for _, step := range p.Steps {
+			if step.Capability == "" {
+				return fmt.Errorf("step '%s' of pipeline '%s' has no capability", step.Name, p.Name)
+			}
+			if step.Timeout < 0 {
+				return fmt.Errorf("timeout of step '%s' of pipeline '%s' must not be negative", step.Name, p.Name)
+			}
+			m, ok := e.modelPlugins[step.Model]
+			if !ok {
+				if !configured[step.Model] {
+					return fmt.Errorf("step '%s' of pipeline '%s' runs on model '%s', which is not configured", step.Name, p.Name, step.Model)
+				}
+				log.Printf("Warning: pipeline '%s' is disabled because model '%s' failed to initialize", p.Name, step.Model)
+				usable = false
+				break
+			}
+			if !plugin.Supports(m, plugin.Capability(step.Capability)) {
+				return fmt.Errorf("step '%s' of pipeline '%s': model '%s' does not support %s", step.Name, p.Name, step.Model, step.Capability)
+			}
+		}
+		if !usable {
+			continue
+		}
+		e.pipelines[p.Name] = p
+		e.pipelineOrder = append(e.pipelineOrder, p.Name)
+		log.Printf("Pipeline ready: %s (%d steps)", p.Name, len(p.Steps))
+	}
+	return nil
+}
+
+// newPipeline turns a pipeline from the config file into one the scheduler can run.
+func newPipeline(cfg config.PipelineConfig) *scheduler.Pipeline {
+	p := &scheduler.Pipeline{Name: cfg.Name, Output: cfg.Output, Timeout: cfg.Timeout}
+	for _, stepCfg := range cfg.Steps {
+		p.Steps = append(p.Steps, scheduler.Step{
+			Name:       stepCfg.Name,
+			Model:      stepCfg.Model,
+			Capability: stepCfg.Capability,
+			Inputs:     stepCfg.Inputs,
+			After:      stepCfg.After,
+			Optional:   stepCfg.Optional,
+			Timeout:    stepCfg.Timeout,
+		})
+	}
+	return p
+}
+
+// Pipelines returns the configured pipelines in config file order.
+func (e *Engine) Pipelines() []*scheduler.Pipeline {
+	pipelines := make([]*scheduler.Pipeline, 0, len(e.pipelineOrder))
+	for _, name := range e.pipelineOrder {
+		pipelines = append(pipelines, e.pipelines[name])
+	}
+	return pipelines
+}
+
+// RunPipeline runs the named pipeline on input, queueing each step on its model's workers
+// at the priority carried by ctx.
+func (e *Engine) RunPipeline(ctx context.Context, name string, input map[string]interface{}) (*scheduler.PipelineResult, error) {
+	p, ok := e.pipelines[name]
+	if !ok {
+		return nil, fmt.Errorf("pipeline '%s' is not configured", name)
+	}
+	return e.scheduler.RunPipeline(ctx, p, input, e.runStep)
+}
+
+// runStep runs a pipeline step once the scheduler has given it one of its model's
+// workers, so it calls the model directly instead of queueing again through Invoke.
+func (e *Engine) runStep(ctx context.Context, step scheduler.Step, params map[string]interface{}) (interface{}, error) {
+	m := e.modelPlugins[step.Model]
+	defer m.use()()
+	result, err := m.invoke(ctx, plugin.Request{Capability: plugin.Capability(step.Capability), Params: params})
+	if err != nil {
+		return nil, err
+	}
+	return result.Output, nil
+}
@@ -0,0 +1,267 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrInsufficientMemory is returned when a model can't be admitted even after evicting
+// every idle model.
+var ErrInsufficientMemory = errors.New("not enough memory to load model")
+
+// DefaultHeadroom is the memory kept free for the operating system and the engine.
+const DefaultHeadroom = 1 << 30
+
+// Options configures a Budget.
+type Options struct {
+	// Limit caps the total footprint of loaded models. 0 derives it from the machine's
+	// RAM and swap.
+	Limit uint64
+	// Headroom is kept free for everything else. Defaults to DefaultHeadroom.
+	Headroom uint64
+	// SwapFraction is the share of swap models may spill into, between 0 and 1.
+	SwapFraction float64
+	// QueueTimeout is how long a load waits for memory to be released before failing.
+	// 0 fails immediately.
+	QueueTimeout time.Duration
+}
+
+// Reservation is the memory held by one loaded model.
+type Reservation struct {
+	Name     string    `json:"name"`
+	Bytes    uint64    `json:"bytes"`
+	InUse    int       `json:"in_use"` // Loads and requests currently running
+	LastUsed time.Time `json:"last_used"`
+}
+
+type entry struct {
+	Reservation
+	evict    func() error
+	evicting bool
+}
+
+// Budget admits models into memory, evicting the least recently used idle ones to make
+// room for new loads. It only knows about the models reserved through it.
+type Budget struct {
+	opts        Options
+	readMemInfo func() (MemInfo, error)
+
+	mu      sync.Mutex
+	entries map[string]*entry
+	changed chan struct{} // Closed and replaced whenever memory is released
+}
+
+// NewBudget creates a budget that checks /proc/meminfo on every admission.
+func NewBudget(opts Options) *Budget {
+	if opts.Headroom == 0 {
+		opts.Headroom = DefaultHeadroom
+	}
+	return &Budget{
+		opts:        opts,
+		readMemInfo: ReadMemInfo,
+		entries:     make(map[string]*entry),
+		changed:     make(chan struct{}),
+	}
+}
+
+// Reserve admits a model of the given size before it is loaded. If it doesn't fit, idle
+// models are evicted in least recently used order by calling their evict function, which
+// must unload the model. If that isn't enough, Reserve waits up to Options.QueueTimeout
+// for memory to be released and then fails with ErrInsufficientMemory.
+//
+// The model counts as in use, and so can't be evicted, until the returned function is
+// called once loading has finished. Call Release when the model is unloaded or failed to
+// load. Reserving a name that is already reserved only marks it in use.
+func (b *Budget) Reserve(ctx context.Context, name string, size uint64, evict func() error) (func(), error) {
+	if b.opts.QueueTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.opts.QueueTimeout)
+		defer cancel()
+	}
+	failed := make(map[string]bool) // Models whose eviction failed during this call
+
+	b.mu.Lock()
+	for {
+		if _, ok := b.entries[name]; ok {
+			b.mu.Unlock()
+			return b.Use(name), nil
+		}
+		free, capacity := b.free()
+		if size <= free {
+			e := &entry{Reservation: Reservation{Name: name, Bytes: size, InUse: 1, LastUsed: time.Now()}, evict: evict}
+			b.entries[name] = e
+			b.mu.Unlock()
+			return b.doneFunc(e), nil
+		}
+		if size > capacity {
+			b.mu.Unlock()
+			return nil, fmt.Errorf("%w: '%s' needs %s but at most %s can ever be used", ErrInsufficientMemory, name, FormatBytes(size), FormatBytes(capacity))
+		}
+		// Only evict if that can make enough room; otherwise wait for models in use.
+		if victim := b.lruIdle(failed); victim != nil && size <= free+b.idleBytes(failed) {
+			victim.evicting = true
+			b.mu.Unlock()
+			log.Printf("Evicting idle model '%s' (%s) to make room for '%s' (%s)", victim.Name, FormatBytes(victim.Bytes), name, FormatBytes(size))
+			err := victim.evict()
+			b.mu.Lock()
+			victim.evicting = false
+			if err != nil {
+				log.Printf("Warning: failed to evict model '%s': %v", victim.Name, err)
+				failed[victim.Name] = true
+			} else if b.entries[victim.Name] == victim {
+				b.remove(victim.Name)
+			}
+			continue
+		}
+		if b.opts.QueueTimeout == 0 {
+			b.mu.Unlock()
+			return nil, fmt.Errorf("%w: '%s' needs %s but only %s is free", ErrInsufficientMemory, name, FormatBytes(size), FormatBytes(free))
+		}
+
+		changed := b.changed
+		b.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: '%s' needs %s: %v", ErrInsufficientMemory, name, FormatBytes(size), ctx.Err())
+		case <-changed:
+		}
+		b.mu.Lock()
+	}
+}
+
+// Use marks a reserved model as in use, so it isn't evicted while serving a request, and
+// returns the function that ends the use. It is a no-op for names that aren't reserved.
+func (b *Budget) Use(name string) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	e, ok := b.entries[name]
+	if !ok {
+		return func() {}
+	}
+	e.InUse++
+	e.LastUsed = time.Now()
+	return b.doneFunc(e)
+}
+
+// Release frees the reservation of an unloaded model.
+func (b *Budget) Release(name string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remove(name)
+}
+
+// Usage returns the current reservations, most recently used first.
+func (b *Budget) Usage() []Reservation {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	usage := make([]Reservation, 0, len(b.entries))
+	for _, e := range b.entries {
+		usage = append(usage, e.Reservation)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].LastUsed.After(usage[j].LastUsed) })
+	return usage
+}
+
+func (b *Budget) doneFunc(e *entry) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			e.InUse--
+			e.LastUsed = time.Now()
+			b.notify()
+		})
+	}
+}
+
+func (b *Budget) remove(name string) {
+	if _, ok := b.entries[name]; ok {
+		delete(b.entries, name)
+		b.notify()
+	}
+}
+
+// notify wakes every load waiting for memory. It must be called with mu held.
+func (b *Budget) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// lruIdle returns the least recently used model that can be evicted. It must be called
+// with mu held.
+func (b *Budget) lruIdle(skip map[string]bool) *entry {
+	var victim *entry
+	for _, e := range b.entries {
+		if e.InUse > 0 || e.evicting || e.evict == nil || skip[e.Name] {
+			continue
+		}
+		if victim == nil || e.LastUsed.Before(victim.LastUsed) {
+			victim = e
+		}
+	}
+	return victim
+}
+
+// idleBytes returns the total footprint of the models lruIdle may pick.
+func (b *Budget) idleBytes(skip map[string]bool) uint64 {
+	var total uint64
+	for _, e := range b.entries {
+		if e.InUse == 0 && !e.evicting && e.evict != nil && !skip[e.Name] {
+			total += e.Bytes
+		}
+	}
+	return total
+}
+
+// free returns how much more memory models may take, the lower of what is left of the
+// budget and what the system has available right now, and the budget's total capacity.
+// It must be called with mu held.
+func (b *Budget) free() (uint64, uint64) {
+	var reserved uint64
+	for _, e := range b.entries {
+		reserved += e.Bytes
+	}
+
+	capacity := b.opts.Limit
+	var live uint64 = ^uint64(0)
+	if mem, err := b.readMemInfo(); err == nil {
+		swap := func(n uint64) uint64 { return uint64(float64(n) * b.opts.SwapFraction) }
+		system := sub(mem.Total+swap(mem.SwapTotal), b.opts.Headroom)
+		if capacity == 0 || system < capacity {
+			capacity = system
+		}
+		// Pages of memory-mapped models show up as reclaimable cache, so the live figure
+		// alone would let llama.cpp models be overcommitted; the budget covers that.
+		live = sub(mem.Available+swap(mem.SwapFree), b.opts.Headroom)
+	} else if capacity == 0 {
+		return ^uint64(0), ^uint64(0) // Nothing to go by, so admit everything.
+	}
+	return min(sub(capacity, reserved), live), capacity
+}
+
+func sub(a, b uint64) uint64 {
+	if a < b {
+		return 0
+	}
+	return a - b
+}
+
+// FormatBytes formats a byte count for log messages, e.g. "6.3 GiB".
+func FormatBytes(n uint64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := uint64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,73 @@
+package memory
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/owen-6936/llm-cortex/core/models/gguf"
+)
+
+// Fixed overheads added to model footprints, on top of the weights.
+const (
+	// GGUFOverhead covers llama.cpp's compute buffers and the process itself.
+	GGUFOverhead = 256 << 20
+	// PythonOverhead covers the interpreter, PyTorch and the activations of a forward pass.
+	PythonOverhead = 768 << 20
+)
+
+// EstimateGGUF estimates the resident size of a GGUF model run by llama.cpp with a
+// context of ctxSize tokens (0 uses the model's trained context): the file, which is
+// mapped into memory, plus an f16 KV cache sized from the model's metadata.
+func EstimateGGUF(path string, ctxSize int) (uint64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	meta, err := gguf.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	if ctxSize <= 0 {
+		ctxSize = meta.ContextLength()
+	}
+	// Grouped-query attention stores fewer key/value heads than query heads.
+	kvWidth := uint64(meta.EmbeddingLength())
+	if heads, kvHeads := meta.HeadCount(), meta.HeadCountKV(); heads > 0 && kvHeads > 0 {
+		kvWidth = kvWidth * uint64(kvHeads) / uint64(heads)
+	}
+	kvCache := 2 * uint64(meta.BlockCount()) * uint64(ctxSize) * kvWidth * 2
+	return uint64(info.Size()) + kvCache + GGUFOverhead, nil
+}
+
+// weightExtensions lists the files a Hugging Face model directory stores weights in,
+// most preferred first. Directories often hold the same weights in several formats and
+// the loaders only read one of them.
+var weightExtensions = []string{".safetensors", ".bin", ".pt", ".pth"}
+
+// EstimateDir estimates the resident size of a PyTorch model stored in a directory:
+// the weights in the preferred format found, plus PythonOverhead.
+func EstimateDir(dir string) (uint64, error) {
+	sizes := make(map[string]uint64)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		sizes[strings.ToLower(filepath.Ext(path))] += uint64(info.Size())
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	for _, ext := range weightExtensions {
+		if size, ok := sizes[ext]; ok {
+			return size + PythonOverhead, nil
+		}
+	}
+	return PythonOverhead, nil
+}
@@ -0,0 +1,68 @@
+// Package memory keeps the models the engine runs within the memory the machine has.
+package memory
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MemInfoPath is where system memory statistics are read from.
+var MemInfoPath = "/proc/meminfo"
+
+// MemInfo is a snapshot of system memory, in bytes.
+type MemInfo struct {
+	Total     uint64
+	Available uint64 // Memory that can be allocated without swapping, per the kernel
+	SwapTotal uint64
+	SwapFree  uint64
+}
+
+// ReadMemInfo reads the current memory statistics from MemInfoPath.
+func ReadMemInfo() (MemInfo, error) {
+	f, err := os.Open(MemInfoPath)
+	if err != nil {
+		return MemInfo{}, err
+	}
+	defer f.Close()
+
+	fields := map[string]*uint64{}
+	var info MemInfo
+	fields["MemTotal"] = &info.Total
+	fields["MemAvailable"] = &info.Available
+	fields["SwapTotal"] = &info.SwapTotal
+	fields["SwapFree"] = &info.SwapFree
+
+	scanner := bufio.NewScanner(f)
+	found := 0
+	for scanner.Scan() {
+		// Lines look like "MemAvailable:   12345678 kB".
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		target, wanted := fields[name]
+		if !ok || !wanted {
+			continue
+		}
+		parts := strings.Fields(rest)
+		if len(parts) == 0 {
+			continue
+		}
+		n, err := strconv.ParseUint(parts[0], 10, 64)
+		if err != nil {
+			return MemInfo{}, fmt.Errorf("bad %s value in %s: %w", name, MemInfoPath, err)
+		}
+		if len(parts) > 1 && parts[1] == "kB" {
+			n *= 1024
+		}
+		*target = n
+		found++
+	}
+	if err := scanner.Err(); err != nil {
+		return MemInfo{}, err
+	}
+	if found < len(fields) {
+		return MemInfo{}, fmt.Errorf("%s is missing memory fields", MemInfoPath)
+	}
+	return info, nil
+}
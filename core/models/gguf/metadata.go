@@ -0,0 +1,167 @@
+package gguf
+
+import "fmt"
+
+// Well-known metadata keys.
+const (
+	KeyArchitecture = "general.architecture"
+	KeyName         = "general.name"
+	KeyFileType     = "general.file_type"
+	KeyChatTemplate = "tokenizer.chat_template"
+	KeyTokens       = "tokenizer.ggml.tokens"
+)
+
+// String returns a string metadata value, or "" if the key is missing or not a string.
+func (f *File) String(key string) string {
+	s, _ := f.Metadata[key].(string)
+	return s
+}
+
+// Uint returns an unsigned integer metadata value of any width. Negative signed values
+// and non-integers report false.
+func (f *File) Uint(key string) (uint64, bool) {
+	switch v := f.Metadata[key].(type) {
+	case uint8:
+		return uint64(v), true
+	case uint16:
+		return uint64(v), true
+	case uint32:
+		return uint64(v), true
+	case uint64:
+		return v, true
+	case int8:
+		return uint64(v), v >= 0
+	case int16:
+		return uint64(v), v >= 0
+	case int32:
+		return uint64(v), v >= 0
+	case int64:
+		return uint64(v), v >= 0
+	}
+	return 0, false
+}
+
+// Architecture returns the model architecture, e.g. "qwen2" or "starcoder2".
+func (f *File) Architecture() string {
+	return f.String(KeyArchitecture)
+}
+
+// Name returns the model's display name, if the file has one.
+func (f *File) Name() string {
+	return f.String(KeyName)
+}
+
+// archUint reads an architecture-specific key such as "qwen2.context_length".
+func (f *File) archUint(suffix string) int {
+	v, _ := f.Uint(f.Architecture() + "." + suffix)
+	return int(v)
+}
+
+// ContextLength returns the context size the model was trained with, or 0 if unknown.
+func (f *File) ContextLength() int {
+	return f.archUint("context_length")
+}
+
+// EmbeddingLength returns the width of the model's hidden state, or 0 if unknown.
+func (f *File) EmbeddingLength() int {
+	return f.archUint("embedding_length")
+}
+
+// BlockCount returns the number of transformer layers, or 0 if unknown.
+func (f *File) BlockCount() int {
+	return f.archUint("block_count")
+}
+
+// HeadCount returns the number of attention heads, or 0 if unknown.
+func (f *File) HeadCount() int {
+	return f.archUint("attention.head_count")
+}
+
+// HeadCountKV returns the number of key/value heads, which is smaller than HeadCount for
+// models using grouped-query attention. It is 0 if unknown.
+func (f *File) HeadCountKV() int {
+	return f.archUint("attention.head_count_kv")
+}
+
+// VocabSize returns the number of tokens in the vocabulary, or 0 if unknown.
+func (f *File) VocabSize() int {
+	if tokens, ok := f.Metadata[KeyTokens].(Array); ok {
+		return int(tokens.Len)
+	}
+	return f.archUint("vocab_size")
+}
+
+// ChatTemplate returns the Jinja chat template embedded in the file, if any.
+func (f *File) ChatTemplate() string {
+	return f.String(KeyChatTemplate)
+}
+
+// ParameterCount returns the total number of values across all tensors.
+func (f *File) ParameterCount() uint64 {
+	var n uint64
+	for _, t := range f.Tensors {
+		n += t.Elements()
+	}
+	return n
+}
+
+// FileType is the predominant quantization of a model, as stored in general.file_type.
+type FileType uint32
+
+// fileTypeNames maps llama.cpp's llama_ftype values to their usual names.
+var fileTypeNames = map[FileType]string{
+	0: "F32", 1: "F16", 2: "Q4_0", 3: "Q4_1", 7: "Q8_0", 8: "Q5_0", 9: "Q5_1",
+	10: "Q2_K", 11: "Q3_K_S", 12: "Q3_K_M", 13: "Q3_K_L", 14: "Q4_K_S", 15: "Q4_K_M",
+	16: "Q5_K_S", 17: "Q5_K_M", 18: "Q6_K", 19: "IQ2_XXS", 20: "IQ2_XS", 21: "Q2_K_S",
+	22: "IQ3_XS", 23: "IQ3_XXS", 24: "IQ1_S", 25: "IQ4_NL", 26: "IQ3_S", 27: "IQ3_M",
+	28: "IQ2_S", 29: "IQ2_M", 30: "IQ4_XS", 31: "IQ1_M", 32: "BF16", 36: "TQ1_0", 37: "TQ2_0",
+}
+
+func (t FileType) String() string {
+	if name, ok := fileTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint32(t))
+}
+
+// FileType returns the model's quantization type. The boolean is false if the file
+// doesn't record it.
+func (f *File) FileType() (FileType, bool) {
+	v, ok := f.Uint(KeyFileType)
+	return FileType(v), ok
+}
+
+// Quantization returns the name of the model's quantization type, e.g. "Q4_K_M", or ""
+// if the file doesn't record it.
+func (f *File) Quantization() string {
+	if t, ok := f.FileType(); ok {
+		return t.String()
+	}
+	return ""
+}
+
+// Summary is a compact description of a GGUF file, suitable for JSON responses.
+type Summary struct {
+	Architecture    string `json:"architecture"`
+	Name            string `json:"name,omitempty"`
+	Quantization    string `json:"quantization,omitempty"`
+	ContextLength   int    `json:"context_length"`
+	VocabSize       int    `json:"vocab_size"`
+	TensorCount     int    `json:"tensor_count"`
+	ParameterCount  uint64 `json:"parameter_count"`
+	HasChatTemplate bool   `json:"has_chat_template"`
+}
+
+// Summary returns the most commonly needed facts about the file.
+func (f *File) Summary() Summary {
+	return Summary{
+		Architecture:    f.Architecture(),
+		Name:            f.Name(),
+		Quantization:    f.Quantization(),
+		ContextLength:   f.ContextLength(),
+		VocabSize:       f.VocabSize(),
+		TensorCount:     len(f.Tensors),
+		ParameterCount:  f.ParameterCount(),
+		HasChatTemplate: f.ChatTemplate() != "",
+	}
+}
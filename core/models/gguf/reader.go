@@ -0,0 +1,276 @@
+// Package gguf reads the header, metadata and tensor descriptions of GGUF model files,
+// the format used by llama.cpp, without loading any tensor data.
+package gguf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Magic is the first four bytes of every GGUF file.
+const Magic = "GGUF"
+
+// MaxArrayValues is the longest metadata array whose values are decoded. Longer arrays,
+// such as a tokenizer's vocabulary, are skipped and only their length is kept.
+const MaxArrayValues = 4096
+
+// Limits that keep a corrupt file from triggering huge allocations.
+const (
+	maxStringLength = 64 << 20
+	maxTensorDims   = 8
+)
+
+// ErrNotGGUF is returned for files that don't start with the GGUF magic.
+var ErrNotGGUF = errors.New("not a GGUF file")
+
+// ValueType is the type tag of a metadata value.
+type ValueType uint32
+
+const (
+	TypeUint8 ValueType = iota
+	TypeInt8
+	TypeUint16
+	TypeInt16
+	TypeUint32
+	TypeInt32
+	TypeFloat32
+	TypeBool
+	TypeString
+	TypeArray
+	TypeUint64
+	TypeInt64
+	TypeFloat64
+)
+
+// Array is a metadata array. Values holds Go values of the element type (e.g. string or
+// int32) and is nil when Len exceeds MaxArrayValues.
+type Array struct {
+	Type   ValueType
+	Len    uint64
+	Values []interface{}
+}
+
+// TensorInfo describes a tensor stored in the file.
+type TensorInfo struct {
+	Name       string
+	Dimensions []uint64
+	Type       uint32 // ggml_type of the tensor data
+	Offset     uint64 // Relative to the start of the tensor data section
+}
+
+// Elements returns the number of values in the tensor.
+func (t TensorInfo) Elements() uint64 {
+	n := uint64(1)
+	for _, d := range t.Dimensions {
+		n *= d
+	}
+	return n
+}
+
+// File is the parsed header of a GGUF file.
+type File struct {
+	Version  uint32
+	Metadata map[string]interface{} // Values are Go scalars, strings or Array
+	Keys     []string               // Metadata keys in file order
+	Tensors  []TensorInfo
+}
+
+// Open parses the header of the GGUF file at path.
+func Open(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	file, err := Read(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GGUF header of %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// Read parses a GGUF header from r. It stops after the tensor descriptions and never
+// reads tensor data.
+func Read(r io.Reader) (*File, error) {
+	d := &decoder{r: bufio.NewReaderSize(r, 1<<16)}
+
+	var magic [4]byte
+	if _, err := io.ReadFull(d.r, magic[:]); err != nil {
+		return nil, err
+	}
+	if string(magic[:]) != Magic {
+		return nil, ErrNotGGUF
+	}
+	version := d.uint32()
+	if d.err == nil && (version < 1 || version > 3) {
+		return nil, fmt.Errorf("unsupported GGUF version %d", version)
+	}
+	// Version 1 used 32-bit counts and string lengths.
+	d.wide = version >= 2
+
+	tensorCount := d.count()
+	kvCount := d.count()
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	file := &File{Version: version, Metadata: make(map[string]interface{})}
+	for i := uint64(0); i < kvCount && d.err == nil; i++ {
+		key := d.string()
+		value := d.value(ValueType(d.uint32()))
+		if d.err == nil {
+			file.Metadata[key] = value
+			file.Keys = append(file.Keys, key)
+		}
+	}
+	for i := uint64(0); i < tensorCount && d.err == nil; i++ {
+		t := TensorInfo{Name: d.string()}
+		dims := d.uint32()
+		if dims > maxTensorDims {
+			return nil, fmt.Errorf("tensor %s has %d dimensions", t.Name, dims)
+		}
+		for j := uint32(0); j < dims; j++ {
+			t.Dimensions = append(t.Dimensions, d.uint64())
+		}
+		t.Type = d.uint32()
+		t.Offset = d.uint64()
+		file.Tensors = append(file.Tensors, t)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return file, nil
+}
+
+// decoder reads little-endian GGUF primitives and remembers the first error.
+type decoder struct {
+	r    *bufio.Reader
+	wide bool // 64-bit counts (version 2 and later)
+	buf  [8]byte
+	err  error
+}
+
+func (d *decoder) read(n int) []byte {
+	if d.err != nil {
+		return d.buf[:n]
+	}
+	if _, err := io.ReadFull(d.r, d.buf[:n]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = err
+	}
+	return d.buf[:n]
+}
+
+func (d *decoder) uint8() uint8   { return d.read(1)[0] }
+func (d *decoder) uint16() uint16 { return binary.LittleEndian.Uint16(d.read(2)) }
+func (d *decoder) uint32() uint32 { return binary.LittleEndian.Uint32(d.read(4)) }
+func (d *decoder) uint64() uint64 { return binary.LittleEndian.Uint64(d.read(8)) }
+
+func (d *decoder) count() uint64 {
+	if d.wide {
+		return d.uint64()
+	}
+	return uint64(d.uint32())
+}
+
+func (d *decoder) string() string {
+	n := d.count()
+	if d.err != nil {
+		return ""
+	}
+	if n > maxStringLength {
+		d.err = fmt.Errorf("string of %d bytes exceeds the %d byte limit", n, maxStringLength)
+		return ""
+	}
+	// Grow with the data rather than trusting the length, so a truncated file fails
+	// before much is allocated.
+	s, err := io.ReadAll(io.LimitReader(d.r, int64(n)))
+	if err != nil || uint64(len(s)) != n {
+		d.err = io.ErrUnexpectedEOF
+		return ""
+	}
+	return string(s)
+}
+
+func (d *decoder) skipString() {
+	n := d.count()
+	if d.err != nil {
+		return
+	}
+	if _, err := d.r.Discard(int(min(n, maxStringLength+1))); err != nil || n > maxStringLength {
+		d.err = fmt.Errorf("bad string of %d bytes", n)
+	}
+}
+
+func (d *decoder) value(t ValueType) interface{} {
+	switch t {
+	case TypeUint8:
+		return d.uint8()
+	case TypeInt8:
+		return int8(d.uint8())
+	case TypeUint16:
+		return d.uint16()
+	case TypeInt16:
+		return int16(d.uint16())
+	case TypeUint32:
+		return d.uint32()
+	case TypeInt32:
+		return int32(d.uint32())
+	case TypeFloat32:
+		return math.Float32frombits(d.uint32())
+	case TypeBool:
+		return d.uint8() != 0
+	case TypeString:
+		return d.string()
+	case TypeUint64:
+		return d.uint64()
+	case TypeInt64:
+		return int64(d.uint64())
+	case TypeFloat64:
+		return math.Float64frombits(d.uint64())
+	case TypeArray:
+		return d.array()
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown metadata value type %d", t)
+		}
+		return nil
+	}
+}
+
+func (d *decoder) array() Array {
+	a := Array{Type: ValueType(d.uint32()), Len: d.count()}
+	if d.err != nil {
+		return a
+	}
+	if a.Len <= MaxArrayValues {
+		a.Values = make([]interface{}, 0, a.Len)
+		for i := uint64(0); i < a.Len && d.err == nil; i++ {
+			a.Values = append(a.Values, d.value(a.Type))
+		}
+		return a
+	}
+	for i := uint64(0); i < a.Len && d.err == nil; i++ {
+		d.skip(a.Type)
+	}
+	return a
+}
+
+// skip reads past a value without keeping it.
+func (d *decoder) skip(t ValueType) {
+	switch t {
+	case TypeString:
+		d.skipString()
+	case TypeArray:
+		d.array()
+	default:
+		d.value(t)
+	}
+}
@@ -0,0 +1,222 @@
+package gguf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"runtime"
+	"testing"
+)
+
+// builder writes a GGUF header by hand. Version 1 files use 32-bit counts and lengths.
+type builder struct {
+	bytes.Buffer
+	version uint32
+}
+
+func newBuilder(version uint32, tensors, kvs uint64) *builder {
+	b := &builder{version: version}
+	b.WriteString(Magic)
+	b.u32(version)
+	b.count(tensors)
+	b.count(kvs)
+	return b
+}
+
+func (b *builder) u32(v uint32) { binary.Write(b, binary.LittleEndian, v) }
+func (b *builder) u64(v uint64) { binary.Write(b, binary.LittleEndian, v) }
+
+func (b *builder) count(n uint64) {
+	if b.version == 1 {
+		b.u32(uint32(n))
+	} else {
+		b.u64(n)
+	}
+}
+
+func (b *builder) str(s string) {
+	b.count(uint64(len(s)))
+	b.WriteString(s)
+}
+
+func (b *builder) kv(key string, t ValueType, write func()) {
+	b.str(key)
+	b.u32(uint32(t))
+	write()
+}
+
+func (b *builder) tensor(name string, typ uint32, offset uint64, dims ...uint64) {
+	b.str(name)
+	b.u32(uint32(len(dims)))
+	for _, d := range dims {
+		b.u64(d)
+	}
+	b.u32(typ)
+	b.u64(offset)
+}
+
+// fixture is a small version 3 header with one value of most types, a short and a long
+// array, and two tensors.
+func fixture() []byte {
+	b := newBuilder(3, 2, 9)
+	b.kv(KeyArchitecture, TypeString, func() { b.str("llama") })
+	b.kv(KeyName, TypeString, func() { b.str("tiny") })
+	b.kv(KeyFileType, TypeUint32, func() { b.u32(15) })
+	b.kv("llama.context_length", TypeUint64, func() { b.u64(4096) })
+	b.kv("llama.block_count", TypeInt32, func() { b.u32(2) })
+	b.kv("llama.rope.freq_base", TypeFloat32, func() { b.u32(math.Float32bits(10000)) })
+	b.kv("general.quantized", TypeBool, func() { b.WriteByte(1) })
+	b.kv("tokenizer.ggml.scores", TypeArray, func() {
+		b.u32(uint32(TypeString))
+		b.count(2)
+		b.str("a")
+		b.str("b")
+	})
+	b.kv(KeyTokens, TypeArray, func() {
+		b.u32(uint32(TypeString))
+		b.count(MaxArrayValues + 1)
+		for i := 0; i < MaxArrayValues+1; i++ {
+			b.str("t")
+		}
+	})
+	b.tensor("token_embd.weight", 12, 0, 64, 32)
+	b.tensor("output_norm.weight", 0, 4096, 64)
+	return b.Bytes()
+}
+
+func TestReadFixture(t *testing.T) {
+	f, err := Read(bytes.NewReader(fixture()))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if f.Version != 3 || len(f.Keys) != 9 || f.Keys[0] != KeyArchitecture {
+		t.Errorf("version %d with keys %q", f.Version, f.Keys)
+	}
+	summary := f.Summary()
+	want := Summary{
+		Architecture:   "llama",
+		Name:           "tiny",
+		Quantization:   "Q4_K_M",
+		ContextLength:  4096,
+		VocabSize:      MaxArrayValues + 1,
+		TensorCount:    2,
+		ParameterCount: 64*32 + 64,
+	}
+	if summary != want {
+		t.Errorf("Summary = %+v, want %+v", summary, want)
+	}
+	if f.BlockCount() != 2 {
+		t.Errorf("BlockCount = %d", f.BlockCount())
+	}
+	if v := f.Metadata["llama.rope.freq_base"]; v != float32(10000) {
+		t.Errorf("freq_base = %v", v)
+	}
+	if v := f.Metadata["general.quantized"]; v != true {
+		t.Errorf("quantized = %v", v)
+	}
+	if scores := f.Metadata["tokenizer.ggml.scores"].(Array); len(scores.Values) != 2 || scores.Values[1] != "b" {
+		t.Errorf("short array = %+v", scores)
+	}
+	if tokens := f.Metadata[KeyTokens].(Array); tokens.Values != nil {
+		t.Errorf("an array over MaxArrayValues kept %d values", len(tokens.Values))
+	}
+	tensor := f.Tensors[1]
+	if tensor.Name != "output_norm.weight" || tensor.Offset != 4096 || len(tensor.Dimensions) != 1 {
+		t.Errorf("second tensor = %+v", tensor)
+	}
+}
+
+func TestReadVersion1(t *testing.T) {
+	b := newBuilder(1, 0, 1)
+	b.kv(KeyArchitecture, TypeString, func() { b.str("gpt2") })
+	f, err := Read(bytes.NewReader(b.Bytes()))
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if f.Architecture() != "gpt2" {
+		t.Errorf("Architecture = %q", f.Architecture())
+	}
+}
+
+func TestReadRejectsBadHeaders(t *testing.T) {
+	tooManyDims := newBuilder(3, 1, 0)
+	tooManyDims.tensor("x", 0, 0, 1, 1, 1, 1, 1, 1, 1, 1, 1)
+	unknownType := newBuilder(3, 0, 1)
+	unknownType.kv("k", ValueType(99), func() {})
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"empty", nil},
+		{"wrong magic", []byte("GGML\x03\x00\x00\x00")},
+		{"unsupported version", newBuilder(4, 0, 0).Bytes()},
+		{"too many dimensions", tooManyDims.Bytes()},
+		{"unknown value type", unknownType.Bytes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Read(bytes.NewReader(tt.data)); err == nil {
+				t.Error("Read succeeded")
+			}
+		})
+	}
+	if _, err := Read(bytes.NewReader([]byte("GGML"))); !errors.Is(err, ErrNotGGUF) {
+		t.Errorf("wrong magic returned %v, want ErrNotGGUF", err)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	data := fixture()
+	// Every cut in the first few hundred bytes, then sparser through the long array.
+	for n := 0; n < len(data); n += 1 + n/256 {
+		if _, err := Read(bytes.NewReader(data[:n])); err == nil {
+			t.Fatalf("Read of the first %d of %d bytes succeeded", n, len(data))
+		}
+	}
+}
+
+// TestReadOversizedLengths checks that lengths and counts far beyond the data are
+// rejected without allocating what they claim.
+func TestReadOversizedLengths(t *testing.T) {
+	hugeString := newBuilder(3, 0, 1)
+	hugeString.count(1 << 40) // Key length
+	truncatedString := newBuilder(3, 0, 1)
+	truncatedString.count(maxStringLength) // Within the limit, but the file ends
+	truncatedString.WriteString("key")
+	hugeArray := newBuilder(3, 0, 1)
+	hugeArray.kv("k", TypeArray, func() {
+		hugeArray.u32(uint32(TypeUint64))
+		hugeArray.count(1 << 60)
+	})
+	hugeCounts := newBuilder(3, 1<<62, 1<<62)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"string length", hugeString.Bytes()},
+		{"truncated string", truncatedString.Bytes()},
+		{"array length", hugeArray.Bytes()},
+		{"tensor and key counts", hugeCounts.Bytes()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			_, err := Read(bytes.NewReader(tt.data))
+			runtime.ReadMemStats(&after)
+			if err == nil {
+				t.Fatal("Read succeeded")
+			}
+			if errors.Is(err, io.EOF) {
+				t.Errorf("Read returned a bare EOF for a truncated header")
+			}
+			if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+				t.Errorf("Read allocated %d bytes", allocated)
+			}
+		})
+	}
+}
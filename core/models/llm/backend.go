@@ -0,0 +1,103 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/owen-6936/llm-cortex/core/plugin"
+	"github.com/owen-6936/llm-cortex/spawn"
+)
+
+// Backends a GGUFModel can run on, selected per model with `backend:` in config.yaml.
+const (
+	BackendCLI    = "cli"    // A persistent interactive llama-cli process driven over stdin/stdout
+	BackendServer = "server" // A llama-server process reached over its local HTTP API
+)
+
+// ErrServerRequired is returned for requests only the server backend can run, such as
+// the fresh-context generations conversations use.
+var ErrServerRequired = errors.New("needs backend: server")
+
+// backend is the transport a GGUFModel uses to reach llama.cpp.
+type backend interface {
+	// complete runs a generation. A non-nil onToken receives the output as it is produced.
+	complete(ctx context.Context, req CompletionRequest, onToken TokenCallback) (*Completion, error)
+	// generate is like complete but starts from an empty context, so nothing from earlier
+	// requests leaks into the result.
+	generate(ctx context.Context, req CompletionRequest, onToken TokenCallback) (*Completion, error)
+	health(ctx context.Context) error
+	// session returns the spawn session running llama.cpp, or "" if it runs elsewhere.
+	session() string
+}
+
+// cliBackend drives an interactive llama-cli session.
+type cliBackend struct {
+	sessionID string
+}
+
+// complete writes the prompt to llama-cli and waits for its prompt marker to reappear.
+//
+// The interactive process fixes its sampling parameters and token limit at launch, so
+// the request's overrides are ignored here and only stop sequences are honoured, by
+// truncating the output.
+func (b *cliBackend) complete(ctx context.Context, req CompletionRequest, onToken TokenCallback) (*Completion, error) {
+	prompt, err := cliPrompt(req.Prompt)
+	if err != nil {
+		return nil, err
+	}
+	// The delimiter `\n>` indicates it's ready for the next prompt. The session's
+	// request queue keeps concurrent callers from interleaving their prompts.
+	var output string
+	if onToken == nil {
+		output, err = spawn.SendCommandAndWaitContext(ctx, b.sessionID, prompt, "\n> ")
+	} else {
+		streamer := &textStreamer{stop: req.Stop, onToken: onToken}
+		output, err = spawn.SendCommandAndStreamContext(ctx, b.sessionID, prompt, "\n> ", streamer.Write)
+		if err == nil {
+			streamer.Flush()
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute GGUF prompt: %w", err)
+	}
+	text, _ := applyStop(output, req.Stop)
+	return &Completion{
+		Text:             text,
+		FinishReason:     FinishStop,
+		PromptTokens:     EstimateTokens(req.Prompt),
+		CompletionTokens: EstimateTokens(text),
+	}, nil
+}
+
+// cliPrompt escapes a prompt for llama-cli's console, which ends a turn at every newline
+// unless the line ends in a backslash; the backslash itself is dropped. A prompt whose
+// last line ends in a backslash or slash can't be sent, since the console would strip
+// that character or keep reading input.
+func cliPrompt(prompt string) (string, error) {
+	lines := strings.Split(strings.ReplaceAll(prompt, "\r\n", "\n"), "\n")
+	if last := lines[len(lines)-1]; strings.HasSuffix(last, "\\") || strings.HasSuffix(last, "/") {
+		return "", fmt.Errorf("%w: on the cli backend a prompt can't end in '\\' or '/'", plugin.ErrInvalidRequest)
+	}
+	return strings.Join(lines, "\\\n"), nil
+}
+
+// generate fails: the interactive session keeps everything it has seen in its context,
+// and a one-shot llama-cli per request would reload the model every time. Stateless
+// generations such as chat need the server backend, whose cache_prompt reuses the
+// evaluated prefix instead.
+func (b *cliBackend) generate(ctx context.Context, req CompletionRequest, onToken TokenCallback) (*Completion, error) {
+	return nil, ErrServerRequired
+}
+
+func (b *cliBackend) session() string {
+	return b.sessionID
+}
+
+func (b *cliBackend) health(ctx context.Context) error {
+	if !spawn.IsRunning(b.sessionID) {
+		return fmt.Errorf("llama-cli process is not running")
+	}
+	return nil
+}
@@ -0,0 +1,277 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/owen-6936/llm-cortex/spawn"
+)
+
+// ServerOptions configures the llama-server backend.
+type ServerOptions struct {
+	// URL attaches to an already running server (or a local HTTP stand-in in tests)
+	// instead of launching one. When set, the fields below are ignored.
+	URL string
+	// Binary is the llama-server executable. Defaults to "bin/llama-server".
+	Binary string
+	// Host is the interface the server binds to. Defaults to 127.0.0.1.
+	Host string
+	// Port is the port the server listens on. 0 picks a free port.
+	Port int
+}
+
+// serverBackend talks to llama-server's native HTTP completion API.
+type serverBackend struct {
+	baseURL   string
+	sessionID string // Empty when attached to an external server
+	client    *http.Client
+}
+
+// startServer launches llama-server for a model and waits until its /health endpoint
+// reports the model is loaded.
+func startServer(ctx context.Context, config Settings, opts ServerOptions) (*serverBackend, error) {
+	if opts.URL != "" {
+		b := &serverBackend{baseURL: strings.TrimRight(opts.URL, "/"), client: &http.Client{}}
+		if err := b.waitHealthy(ctx, nil); err != nil {
+			return nil, fmt.Errorf("llama-server at %s is not healthy: %w", opts.URL, err)
+		}
+		return b, nil
+	}
+
+	if opts.Binary == "" {
+		opts.Binary = "bin/llama-server"
+	}
+	if opts.Host == "" {
+		opts.Host = "127.0.0.1"
+	}
+	if opts.Port == 0 {
+		port, err := freePort(opts.Host)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pick a port for llama-server: %w", err)
+		}
+		opts.Port = port
+	}
+
+	args := append(config.ToServerArgs(), "--host", opts.Host, "--port", strconv.Itoa(opts.Port))
+	sessionID, err := spawn.NewShellWithOptions(config.Process, append([]string{opts.Binary}, args...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start llama-server session: %w", err)
+	}
+	// Watch stdout so the health loop notices if the server dies while loading.
+	exited, err := spawn.Subscribe(sessionID, spawn.StreamStdout)
+	if err != nil {
+		spawn.CloseSession(sessionID)
+		return nil, err
+	}
+	defer exited.Close()
+	spawn.StartReading(sessionID, spawn.OutputHandler, spawn.InfoOutputHandler)
+	// llama-server keeps running when stdin closes; SIGINT makes it shut down cleanly.
+	spawn.SetInterruptSignal(sessionID, os.Interrupt)
+
+	b := &serverBackend{
+		baseURL:   fmt.Sprintf("http://%s", net.JoinHostPort(opts.Host, strconv.Itoa(opts.Port))),
+		sessionID: sessionID,
+		client:    &http.Client{},
+	}
+	if err := b.waitHealthy(ctx, exited); err != nil {
+		b.stop()
+		return nil, fmt.Errorf("error waiting for llama-server for '%s' to load: %w", config.ModelPath, err)
+	}
+	return b, nil
+}
+
+// waitHealthy polls /health until it returns 200. llama-server answers 503 while the
+// model is still loading. A nil exited subscription means there is no process to watch.
+func (b *serverBackend) waitHealthy(ctx context.Context, exited *spawn.Subscription) error {
+	var output <-chan struct{}
+	if exited != nil {
+		output = exited.Ready()
+	}
+	tick := time.NewTicker(250 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		if err := b.health(ctx); err == nil {
+			return nil
+		}
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-output:
+				// Log output wakes us too; only give up once the stream has ended.
+				if _, open := exited.Next(); !open {
+					return fmt.Errorf("llama-server exited during startup")
+				}
+			case <-tick.C:
+				break wait
+			}
+		}
+	}
+}
+
+func (b *serverBackend) session() string {
+	return b.sessionID
+}
+
+func (b *serverBackend) health(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.baseURL+"/health", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("llama-server health check returned %s", resp.Status)
+	}
+	return nil
+}
+
+// serverCompletionRequest is the body of llama-server's POST /completion.
+type serverCompletionRequest struct {
+	Prompt        string   `json:"prompt"`
+	NPredict      int      `json:"n_predict,omitempty"`
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopK          *int     `json:"top_k,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	MinP          *float64 `json:"min_p,omitempty"`
+	RepeatPenalty *float64 `json:"repeat_penalty,omitempty"`
+	Seed          *int     `json:"seed,omitempty"`
+	Stop          []string `json:"stop,omitempty"`
+	Stream        bool     `json:"stream"`
+	CachePrompt   bool     `json:"cache_prompt"`
+}
+
+// serverCompletionResponse is the subset of llama-server's /completion response we use.
+type serverCompletionResponse struct {
+	Content         string `json:"content"`
+	Stop            bool   `json:"stop"`
+	StoppedLimit    bool   `json:"stopped_limit"`
+	TokensPredicted int    `json:"tokens_predicted"`
+	TokensEvaluated int    `json:"tokens_evaluated"`
+}
+
+// complete posts a completion request. Cancelling ctx closes the connection, which makes
+// llama-server abort the generation. With onToken set, the response is streamed.
+func (b *serverBackend) complete(ctx context.Context, req CompletionRequest, onToken TokenCallback) (*Completion, error) {
+	body, err := json.Marshal(serverCompletionRequest{
+		Prompt:        req.Prompt,
+		NPredict:      req.MaxTokens,
+		Temperature:   req.Temperature,
+		TopK:          req.TopK,
+		TopP:          req.TopP,
+		MinP:          req.MinP,
+		RepeatPenalty: req.RepeatPenalty,
+		Seed:          req.Seed,
+		Stop:          req.Stop,
+		Stream:        onToken != nil,
+		CachePrompt:   true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, b.baseURL+"/completion", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("llama-server request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("llama-server returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	var out serverCompletionResponse
+	if onToken != nil {
+		if out, err = readServerStream(resp.Body, onToken); err != nil {
+			return nil, err
+		}
+	} else if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return nil, fmt.Errorf("failed to parse llama-server response: %w", err)
+	}
+	finish := FinishStop
+	if out.StoppedLimit {
+		finish = FinishLength
+	}
+	return &Completion{
+		Text:             out.Content,
+		FinishReason:     finish,
+		PromptTokens:     out.TokensEvaluated,
+		CompletionTokens: out.TokensPredicted,
+	}, nil
+}
+
+// generate is the same as complete: every llama-server request starts from an empty
+// context, and cache_prompt only reuses the evaluated prefix.
+func (b *serverBackend) generate(ctx context.Context, req CompletionRequest, onToken TokenCallback) (*Completion, error) {
+	return b.complete(ctx, req, onToken)
+}
+
+// readServerStream reads llama-server's streamed /completion response, a series of
+// "data: {...}" lines whose last object has "stop" set and carries the token counts.
+// The returned response holds the full text.
+func readServerStream(body io.Reader, onToken TokenCallback) (serverCompletionResponse, error) {
+	var text strings.Builder
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		var chunk serverCompletionResponse
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return chunk, fmt.Errorf("failed to parse llama-server stream: %w", err)
+		}
+		if chunk.Content != "" {
+			text.WriteString(chunk.Content)
+			onToken(chunk.Content)
+		}
+		if chunk.Stop {
+			chunk.Content = text.String()
+			return chunk, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return serverCompletionResponse{}, fmt.Errorf("llama-server stream failed: %w", err)
+	}
+	return serverCompletionResponse{}, fmt.Errorf("llama-server stream ended without a final message")
+}
+
+// stop shuts down a launched server. It is a no-op for external servers.
+func (b *serverBackend) stop() error {
+	if b.sessionID == "" {
+		return nil
+	}
+	spawn.Interrupt(b.sessionID)
+	return spawn.CloseSession(b.sessionID)
+}
+
+// freePort asks the kernel for an unused TCP port on host.
+func freePort(host string) (int, error) {
+	l, err := net.Listen("tcp", net.JoinHostPort(host, "0"))
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,212 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeServer stands in for llama-server's /health and /completion endpoints. It reports
+// the model as loading for the first few health checks and answers every completion
+// with reply, split into one SSE event per word when the request streams.
+type fakeServer struct {
+	*httptest.Server
+	reply string
+
+	mu       sync.Mutex
+	loading  int // Health checks left that answer 503
+	requests []serverCompletionRequest
+}
+
+func newFakeServer(t *testing.T, reply string) *fakeServer {
+	t.Helper()
+	f := &fakeServer{reply: reply, loading: 2}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.loading > 0 {
+			f.loading--
+			http.Error(w, `{"error":{"message":"Loading model"}}`, http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"status":"ok"}`)
+	})
+	mux.HandleFunc("POST /completion", func(w http.ResponseWriter, r *http.Request) {
+		var req serverCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.requests = append(f.requests, req)
+		f.mu.Unlock()
+
+		final := serverCompletionResponse{
+			Stop:            true,
+			StoppedLimit:    req.NPredict == 1,
+			TokensPredicted: len(strings.Fields(f.reply)),
+			TokensEvaluated: len(strings.Fields(req.Prompt)),
+		}
+		if !req.Stream {
+			final.Content = f.reply
+			json.NewEncoder(w).Encode(final)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, word := range strings.SplitAfter(f.reply, " ") {
+			data, _ := json.Marshal(serverCompletionResponse{Content: word})
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			w.(http.Flusher).Flush()
+		}
+		data, _ := json.Marshal(final)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+	})
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeServer) lastRequest(t *testing.T) serverCompletionRequest {
+	t.Helper()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.requests) == 0 {
+		t.Fatal("the server received no completion request")
+	}
+	return f.requests[len(f.requests)-1]
+}
+
+// loadFakeModel attaches a GGUFModel to a fake server under a model path of the test's
+// own, since the package's manager shares servers by path.
+func loadFakeModel(t *testing.T, f *fakeServer) *GGUFModel {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	model, err := NewGGUFServerModelContext(ctx, Settings{ModelPath: t.Name() + ".gguf"}, ServerOptions{URL: f.URL + "/"})
+	if err != nil {
+		t.Fatalf("NewGGUFServerModelContext: %v", err)
+	}
+	t.Cleanup(func() { model.Unload() })
+	return model
+}
+
+func TestServerModelWaitsForHealth(t *testing.T) {
+	f := newFakeServer(t, "ok")
+	model := loadFakeModel(t, f)
+
+	if model.Backend != BackendServer || model.SessionID != "" {
+		t.Errorf("attached model has backend %q and session %q, want %q and none", model.Backend, model.SessionID, BackendServer)
+	}
+	f.mu.Lock()
+	loading := f.loading
+	f.mu.Unlock()
+	if loading != 0 {
+		t.Errorf("model loaded with %d health checks still answering 503", loading)
+	}
+	if err := model.Health(context.Background()); err != nil {
+		t.Errorf("Health: %v", err)
+	}
+	if model.Exited() != nil {
+		t.Error("Exited is not nil for an external server")
+	}
+
+	f.Close()
+	if err := model.Health(context.Background()); err == nil {
+		t.Error("Health succeeded after the server went away")
+	}
+}
+
+func TestServerModelComplete(t *testing.T) {
+	f := newFakeServer(t, "func fib(n int) int")
+	model := loadFakeModel(t, f)
+
+	temperature := 0.3
+	seed := 7
+	completion, err := model.Complete(context.Background(), CompletionRequest{
+		Prompt:      "write fib",
+		MaxTokens:   64,
+		Temperature: &temperature,
+		Seed:        &seed,
+		Stop:        []string{"\n\n"},
+	})
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	want := Completion{Text: "func fib(n int) int", FinishReason: FinishStop, PromptTokens: 2, CompletionTokens: 4}
+	if *completion != want {
+		t.Errorf("Complete returned %+v, want %+v", *completion, want)
+	}
+
+	req := f.lastRequest(t)
+	if req.Prompt != "write fib" || req.NPredict != 64 || req.Stream || !req.CachePrompt {
+		t.Errorf("server got prompt %q, n_predict %d, stream %v, cache_prompt %v", req.Prompt, req.NPredict, req.Stream, req.CachePrompt)
+	}
+	if req.Temperature == nil || *req.Temperature != temperature || req.Seed == nil || *req.Seed != seed {
+		t.Errorf("server got temperature %v and seed %v, want %g and %d", req.Temperature, req.Seed, temperature, seed)
+	}
+	if len(req.Stop) != 1 || req.Stop[0] != "\n\n" {
+		t.Errorf("server got stop %q", req.Stop)
+	}
+
+	completion, err = model.Complete(context.Background(), CompletionRequest{Prompt: "x", MaxTokens: 1})
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if completion.FinishReason != FinishLength {
+		t.Errorf("finish reason at the token limit is %q, want %q", completion.FinishReason, FinishLength)
+	}
+}
+
+func TestServerModelCompleteStream(t *testing.T) {
+	f := newFakeServer(t, "one two three")
+	model := loadFakeModel(t, f)
+
+	var chunks []string
+	completion, err := model.CompleteStream(context.Background(), CompletionRequest{Prompt: "count"}, func(chunk string) {
+		chunks = append(chunks, chunk)
+	})
+	if err != nil {
+		t.Fatalf("CompleteStream: %v", err)
+	}
+	if want := []string{"one ", "two ", "three"}; strings.Join(chunks, "|") != strings.Join(want, "|") {
+		t.Errorf("streamed chunks %q, want %q", chunks, want)
+	}
+	if completion.Text != "one two three" || completion.CompletionTokens != 3 {
+		t.Errorf("CompleteStream returned %+v", *completion)
+	}
+	if !f.lastRequest(t).Stream {
+		t.Error("the request did not ask the server to stream")
+	}
+}
+
+func TestServerModelConversation(t *testing.T) {
+	f := newFakeServer(t, "Hello!")
+	model := loadFakeModel(t, f)
+
+	conversation := NewConversation(model, ConversationOptions{Template: ChatML, SystemPrompt: "Be brief."})
+	completion, err := conversation.Send(context.Background(), "Hi")
+	if err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if completion.Text != "Hello!" {
+		t.Errorf("reply is %q", completion.Text)
+	}
+	req := f.lastRequest(t)
+	want := "<|im_start|>system\nBe brief.<|im_end|>\n<|im_start|>user\nHi<|im_end|>\n<|im_start|>assistant\n"
+	if req.Prompt != want {
+		t.Errorf("server got prompt %q, want %q", req.Prompt, want)
+	}
+	if len(req.Stop) != 2 || req.Stop[0] != "<|im_end|>" {
+		t.Errorf("server got stop %q, want the template's", req.Stop)
+	}
+	if history := conversation.History(); len(history) != 2 || history[1].Content != "Hello!" {
+		t.Errorf("history is %+v", history)
+	}
+}
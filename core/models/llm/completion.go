@@ -0,0 +1,80 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Finish reasons reported for a completion, matching the OpenAI vocabulary.
+const (
+	FinishStop   = "stop"   // The model ended its turn or hit a stop sequence
+	FinishLength = "length" // The token limit was reached
+)
+
+// CompletionRequest describes a single text generation with optional sampling overrides.
+// Fields left at their zero value fall back to the model's Settings. Only the llama-server
+// backend applies the overrides; llama-cli fixes them at launch and only honours Stop.
+type CompletionRequest struct {
+	Prompt        string
+	MaxTokens     int      // Upper bound on generated tokens; 0 uses Settings.NPredict
+	Temperature   *float64 // nil uses the model default
+	TopK          *int     // nil uses the model default
+	TopP          *float64 // nil uses the model default
+	MinP          *float64 // nil uses the model default
+	RepeatPenalty *float64 // nil uses the model default
+	Seed          *int     // nil uses the model default
+	Stop          []string // Generation is cut at the first occurrence of any of these
+}
+
+// Validate checks the overrides against the same ranges as Settings.
+func (r CompletionRequest) Validate() error {
+	if r.MaxTokens < 0 {
+		return fmt.Errorf("max_tokens must not be negative, got %d", r.MaxTokens)
+	}
+	overrides := Settings{
+		ModelPath:     "-",
+		Temperature:   r.Temperature,
+		TopK:          r.TopK,
+		TopP:          r.TopP,
+		MinP:          r.MinP,
+		RepeatPenalty: r.RepeatPenalty,
+	}
+	return overrides.Validate()
+}
+
+// Completion is the result of a CompletionRequest.
+type Completion struct {
+	Text             string `json:"text"`
+	FinishReason     string `json:"finish_reason"`
+	PromptTokens     int    `json:"prompt_tokens"`     // Estimated when the backend doesn't report it
+	CompletionTokens int    `json:"completion_tokens"` // Estimated when the backend doesn't report it
+}
+
+// Complete runs a completion request against the model's backend.
+func (m *GGUFModel) Complete(ctx context.Context, req CompletionRequest) (*Completion, error) {
+	return m.backend.complete(ctx, req, nil)
+}
+
+// applyStop cuts text at the earliest stop sequence and reports whether one was found.
+func applyStop(text string, stop []string) (string, bool) {
+	cut := -1
+	for _, s := range stop {
+		if s == "" {
+			continue
+		}
+		if i := strings.Index(text, s); i >= 0 && (cut < 0 || i < cut) {
+			cut = i
+		}
+	}
+	if cut < 0 {
+		return text, false
+	}
+	return text[:cut], true
+}
+
+// EstimateTokens approximates the token count of a string at roughly four bytes per
+// token, which is close enough for usage reporting on English text and code.
+func EstimateTokens(s string) int {
+	return (len(s) + 3) / 4
+}
@@ -1,20 +1,94 @@
 package llm
 
 import (
+	"fmt"
 	"runtime"
 	"strconv"
+
+	"github.com/owen-6936/llm-cortex/core/models/gguf"
+	"github.com/owen-6936/llm-cortex/spawn"
 )
 
-// Settings holds the parameters for running a GGUF model with llama-cli.
+// Settings holds the parameters for running a GGUF model with llama-cli or llama-server.
+// Pointer fields are optional: nil leaves llama.cpp's own default in place.
+// The YAML tags match the `settings:` block of a GGUF model in config.yaml.
 type Settings struct {
-	ModelPath     string
-	Prompt        string
-	Threads       int
-	NPredict      int
-	NoMMap        bool
-	BatchSize     int
-	Jinja         bool
-	// Add other general parameters here as needed.
+	ModelPath string `yaml:"-"` // Taken from the model's `path`
+	Prompt    string `yaml:"prompt"`
+	Threads   int    `yaml:"threads"`
+	NPredict  int    `yaml:"n_predict"` // -1 generates until the model stops
+	NoMMap    bool   `yaml:"no_mmap"`
+	BatchSize int    `yaml:"batch_size"`
+	Jinja     bool   `yaml:"jinja"`
+
+	// Context
+	CtxSize       int     `yaml:"ctx_size"`        // 0 uses the size the model was trained with
+	GPULayers     int     `yaml:"gpu_layers"`      // Layers to offload to the GPU
+	MLock         bool    `yaml:"mlock"`           // Keep the model in RAM instead of letting it swap
+	RopeScaling   string  `yaml:"rope_scaling"`    // "none", "linear" or "yarn"
+	RopeFreqBase  float64 `yaml:"rope_freq_base"`  // 0 uses the value from the model
+	RopeFreqScale float64 `yaml:"rope_freq_scale"` // 0 uses the value from the model
+
+	// Sampling
+	Temperature   *float64 `yaml:"temperature"`
+	TopK          *int     `yaml:"top_k"`
+	TopP          *float64 `yaml:"top_p"`
+	MinP          *float64 `yaml:"min_p"`
+	RepeatPenalty *float64 `yaml:"repeat_penalty"`
+	RepeatLastN   *int     `yaml:"repeat_last_n"`
+	Seed          *int     `yaml:"seed"` // -1 picks a random seed
+
+	// Chat (llama-cli only, except ChatTemplate and ChatFormat)
+	ChatTemplate   string   `yaml:"chat_template"`   // llama.cpp's --chat-template, e.g. "chatml"
+	ChatFormat     string   `yaml:"chat_format"`     // Go template conversations render with, e.g. "starcoder2"
+	SystemPrompt   string   `yaml:"system_prompt"`   // System message for the interactive session
+	ReversePrompts []string `yaml:"reverse_prompts"` // Strings that hand control back to the user
+
+	// Process controls how llama.cpp processes are started, from the model's `process` block.
+	Process spawn.Options `yaml:"-"`
+}
+
+// Validate checks that the settings are within the ranges llama.cpp accepts.
+func (s *Settings) Validate() error {
+	switch {
+	case s.ModelPath == "":
+		return fmt.Errorf("model path is required")
+	case s.Threads < 0:
+		return fmt.Errorf("threads must not be negative, got %d", s.Threads)
+	case s.NPredict < -1:
+		return fmt.Errorf("n_predict must be -1 or more, got %d", s.NPredict)
+	case s.BatchSize < 0:
+		return fmt.Errorf("batch_size must not be negative, got %d", s.BatchSize)
+	case s.CtxSize < 0:
+		return fmt.Errorf("ctx_size must not be negative, got %d", s.CtxSize)
+	case s.GPULayers < 0:
+		return fmt.Errorf("gpu_layers must not be negative, got %d", s.GPULayers)
+	case s.RopeFreqBase < 0 || s.RopeFreqScale < 0:
+		return fmt.Errorf("rope_freq_base and rope_freq_scale must not be negative")
+	case s.Temperature != nil && *s.Temperature < 0:
+		return fmt.Errorf("temperature must not be negative, got %g", *s.Temperature)
+	case s.TopK != nil && *s.TopK < 0:
+		return fmt.Errorf("top_k must not be negative, got %d", *s.TopK)
+	case s.TopP != nil && (*s.TopP < 0 || *s.TopP > 1):
+		return fmt.Errorf("top_p must be between 0 and 1, got %g", *s.TopP)
+	case s.MinP != nil && (*s.MinP < 0 || *s.MinP > 1):
+		return fmt.Errorf("min_p must be between 0 and 1, got %g", *s.MinP)
+	case s.RepeatPenalty != nil && *s.RepeatPenalty <= 0:
+		return fmt.Errorf("repeat_penalty must be positive, got %g", *s.RepeatPenalty)
+	case s.RepeatLastN != nil && *s.RepeatLastN < -1:
+		return fmt.Errorf("repeat_last_n must be -1 or more, got %d", *s.RepeatLastN)
+	}
+	switch s.RopeScaling {
+	case "", "none", "linear", "yarn":
+	default:
+		return fmt.Errorf("rope_scaling must be none, linear or yarn, got '%s'", s.RopeScaling)
+	}
+	if s.ChatFormat != "" {
+		if _, err := TemplateByName(s.ChatFormat); err != nil {
+			return fmt.Errorf("chat_format: %w", err)
+		}
+	}
+	return nil
 }
 
 // ToArgs converts the settings to a slice of command-line arguments for llama-cli.
@@ -27,7 +101,26 @@ func (s *Settings) ToArgs(interactive bool) []string {
 	} else if s.Prompt != "" {
 		args = append(args, "-p", s.Prompt)
 	}
-	if s.NPredict > 0 {
+	if s.SystemPrompt != "" {
+		args = append(args, "--system-prompt", s.SystemPrompt)
+	}
+	for _, r := range s.ReversePrompts {
+		args = append(args, "--reverse-prompt", r)
+	}
+
+	return append(args, s.commonArgs()...)
+}
+
+// ToServerArgs converts the settings to a slice of command-line arguments for llama-server.
+// Prompts are sent per request, so Prompt, SystemPrompt and ReversePrompts are not used.
+func (s *Settings) ToServerArgs() []string {
+	return append([]string{"-m", s.ModelPath}, s.commonArgs()...)
+}
+
+// commonArgs returns the flags llama-cli and llama-server share.
+func (s *Settings) commonArgs() []string {
+	var args []string
+	if s.NPredict != 0 {
 		args = append(args, "--n-predict", strconv.Itoa(s.NPredict))
 	}
 	if s.Threads > 0 {
@@ -42,10 +135,55 @@ func (s *Settings) ToArgs(interactive bool) []string {
 	if s.Jinja {
 		args = append(args, "--jinja")
 	}
-
+	if s.CtxSize > 0 {
+		args = append(args, "--ctx-size", strconv.Itoa(s.CtxSize))
+	}
+	if s.GPULayers > 0 {
+		args = append(args, "--n-gpu-layers", strconv.Itoa(s.GPULayers))
+	}
+	if s.MLock {
+		args = append(args, "--mlock")
+	}
+	if s.RopeScaling != "" {
+		args = append(args, "--rope-scaling", s.RopeScaling)
+	}
+	if s.RopeFreqBase > 0 {
+		args = append(args, "--rope-freq-base", formatFloat(s.RopeFreqBase))
+	}
+	if s.RopeFreqScale > 0 {
+		args = append(args, "--rope-freq-scale", formatFloat(s.RopeFreqScale))
+	}
+	if s.Temperature != nil {
+		args = append(args, "--temp", formatFloat(*s.Temperature))
+	}
+	if s.TopK != nil {
+		args = append(args, "--top-k", strconv.Itoa(*s.TopK))
+	}
+	if s.TopP != nil {
+		args = append(args, "--top-p", formatFloat(*s.TopP))
+	}
+	if s.MinP != nil {
+		args = append(args, "--min-p", formatFloat(*s.MinP))
+	}
+	if s.RepeatPenalty != nil {
+		args = append(args, "--repeat-penalty", formatFloat(*s.RepeatPenalty))
+	}
+	if s.RepeatLastN != nil {
+		args = append(args, "--repeat-last-n", strconv.Itoa(*s.RepeatLastN))
+	}
+	if s.Seed != nil {
+		args = append(args, "--seed", strconv.Itoa(*s.Seed))
+	}
+	if s.ChatTemplate != "" {
+		args = append(args, "--chat-template", s.ChatTemplate)
+	}
 	return args
 }
 
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
 // Performance returns a config optimized for performance.
 // It uses all available CPU threads and a large batch size.
 func Performance(modelPath, prompt string, nPredict int) Settings {
@@ -56,10 +194,28 @@ func Performance(modelPath, prompt string, nPredict int) Settings {
 		NPredict:  nPredict,
 		NoMMap:    true,
 		BatchSize: 4096,
-		Jinja: true,
+		Jinja:     true,
 	}
 }
 
+// MaxRecommendedContext caps the context size Recommended picks. Models trained on long
+// contexts would otherwise reserve gigabytes for a KV cache that is rarely filled.
+const MaxRecommendedContext = 8192
+
+// Recommended returns Balanced settings adjusted to what the model file declares: the
+// context size it was trained with (capped at MaxRecommendedContext) and Jinja chat
+// templating only when the file embeds a template. It fails if the file can't be read.
+func Recommended(modelPath string, nPredict int) (Settings, error) {
+	settings := Balanced(modelPath, "", nPredict)
+	meta, err := gguf.Open(modelPath)
+	if err != nil {
+		return settings, err
+	}
+	settings.CtxSize = min(meta.ContextLength(), MaxRecommendedContext)
+	settings.Jinja = meta.ChatTemplate() != ""
+	return settings, nil
+}
+
 // Balanced returns a config with sensible, general-purpose defaults.
 func Balanced(modelPath, prompt string, nPredict int) Settings {
 	return Settings{
@@ -69,6 +225,6 @@ func Balanced(modelPath, prompt string, nPredict int) Settings {
 		NPredict:  nPredict,
 		NoMMap:    false,
 		BatchSize: 512, // Default llama.cpp batch size
-		Jinja: true,
+		Jinja:     true,
 	}
-}
\ No newline at end of file
+}
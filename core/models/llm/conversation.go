@@ -0,0 +1,204 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	uuid "github.com/google/uuid"
+)
+
+// ErrContextOverflow is returned when a conversation can't be made to fit the model's
+// context window.
+var ErrContextOverflow = errors.New("conversation does not fit the context window")
+
+// DefaultContextSize is the context window assumed for models whose Settings.CtxSize is 0.
+const DefaultContextSize = 4096
+
+// TrimStrategy shortens a conversation history until fits reports true. The system
+// message, if any, is passed separately and always kept. The newest message must be kept
+// too; a strategy that can't make the history fit returns ErrContextOverflow.
+type TrimStrategy func(history []Message, fits func([]Message) bool) ([]Message, error)
+
+// DropOldest removes the oldest turns first. It is the default strategy.
+func DropOldest(history []Message, fits func([]Message) bool) ([]Message, error) {
+	for len(history) > 1 && !fits(history) {
+		history = history[1:]
+	}
+	if !fits(history) {
+		return nil, ErrContextOverflow
+	}
+	return history, nil
+}
+
+// KeepFirstTurn keeps the opening user message, which often holds the task description,
+// and removes turns from the middle of the conversation.
+func KeepFirstTurn(history []Message, fits func([]Message) bool) ([]Message, error) {
+	if len(history) < 3 {
+		return DropOldest(history, fits)
+	}
+	first, rest := history[0], history[1:]
+	for len(rest) > 1 && !fits(append([]Message{first}, rest...)) {
+		rest = rest[1:]
+	}
+	trimmed := append([]Message{first}, rest...)
+	if !fits(trimmed) {
+		return DropOldest(rest, fits)
+	}
+	return trimmed, nil
+}
+
+// NoTrim never drops messages and fails once the conversation is too long.
+func NoTrim(history []Message, fits func([]Message) bool) ([]Message, error) {
+	if !fits(history) {
+		return nil, ErrContextOverflow
+	}
+	return history, nil
+}
+
+// ConversationOptions configures a new Conversation. Zero values pick the defaults.
+type ConversationOptions struct {
+	SystemPrompt string       // Defaults to Settings.SystemPrompt
+	Template     ChatTemplate // Defaults to TemplateFor(model.Settings, model.Metadata)
+	Trim         TrimStrategy // Defaults to DropOldest
+	ContextSize  int          // In tokens; defaults to Settings.CtxSize or DefaultContextSize
+	MaxTokens    int          // Tokens reserved for each reply; defaults to Settings.NPredict or 512
+}
+
+// Conversation is a multi-turn chat with a GGUF model whose history is kept in Go.
+// Every turn renders the whole (trimmed) history with the chat template and runs it as
+// a fresh generation, so any number of conversations can share one loaded model without
+// seeing each other. The model must run on the server backend; on llama-cli every turn
+// fails with ErrServerRequired. A Conversation is safe for concurrent use; turns are
+// serialized.
+type Conversation struct {
+	ID string
+
+	model       *GGUFModel
+	template    ChatTemplate
+	trim        TrimStrategy
+	contextSize int
+	maxTokens   int
+
+	mu       sync.Mutex
+	system   string
+	messages []Message
+}
+
+// NewConversation starts an empty conversation with a loaded model.
+func NewConversation(model *GGUFModel, opts ConversationOptions) *Conversation {
+	c := &Conversation{
+		ID:          uuid.New().String(),
+		model:       model,
+		template:    opts.Template,
+		trim:        opts.Trim,
+		contextSize: opts.ContextSize,
+		maxTokens:   opts.MaxTokens,
+		system:      opts.SystemPrompt,
+	}
+	if c.template == nil {
+		c.template = TemplateFor(model.Settings, model.Metadata)
+	}
+	if c.trim == nil {
+		c.trim = DropOldest
+	}
+	if c.contextSize <= 0 {
+		c.contextSize = model.Settings.CtxSize
+	}
+	if c.contextSize <= 0 {
+		c.contextSize = DefaultContextSize
+	}
+	if c.maxTokens <= 0 {
+		c.maxTokens = model.Settings.NPredict
+	}
+	if c.maxTokens <= 0 {
+		c.maxTokens = 512
+	}
+	if c.system == "" {
+		c.system = model.Settings.SystemPrompt
+	}
+	return c
+}
+
+// Send adds a user message, generates the assistant's reply and adds it to the history.
+func (c *Conversation) Send(ctx context.Context, content string) (*Completion, error) {
+	return c.SendStream(ctx, content, nil)
+}
+
+// SendStream is like Send but calls onToken with the reply as it is generated.
+// If generation fails, the user message is not kept in the history.
+func (c *Conversation) SendStream(ctx context.Context, content string, onToken TokenCallback) (*Completion, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	history := append(c.messages[:len(c.messages):len(c.messages)], Message{Role: RoleUser, Content: content})
+	completion, kept, err := c.generate(ctx, c.system, history, CompletionRequest{}, onToken)
+	if err != nil {
+		return nil, err
+	}
+	c.messages = append(kept, Message{Role: RoleAssistant, Content: completion.Text})
+	return completion, nil
+}
+
+// Complete generates a reply to a complete list of messages without keeping any state,
+// which is how stateless APIs such as OpenAI's chat completions use it. A leading system
+// message overrides the conversation's system prompt. req.Prompt is ignored.
+func (c *Conversation) Complete(ctx context.Context, messages []Message, req CompletionRequest, onToken TokenCallback) (*Completion, error) {
+	system := c.system
+	if len(messages) > 0 && messages[0].Role == RoleSystem {
+		system = messages[0].Content
+		messages = messages[1:]
+	}
+	completion, _, err := c.generate(ctx, system, messages, req, onToken)
+	return completion, err
+}
+
+// History returns a copy of the messages exchanged so far, after any trimming.
+func (c *Conversation) History() []Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Message(nil), c.messages...)
+}
+
+// Reset clears the history, keeping the system prompt.
+func (c *Conversation) Reset() {
+	c.mu.Lock()
+	c.messages = nil
+	c.mu.Unlock()
+}
+
+// generate trims the history to the context window, renders it and runs the generation.
+// It returns the trimmed history that was sent.
+func (c *Conversation) generate(ctx context.Context, system string, history []Message, req CompletionRequest, onToken TokenCallback) (*Completion, []Message, error) {
+	if len(history) == 0 {
+		return nil, nil, fmt.Errorf("conversation has no messages")
+	}
+	maxTokens := c.maxTokens
+	if req.MaxTokens > 0 {
+		maxTokens = req.MaxTokens
+	}
+	fits := func(messages []Message) bool {
+		return EstimateTokens(c.render(system, messages))+maxTokens <= c.contextSize
+	}
+	trimmed, err := c.trim(history, fits)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req.Prompt = c.render(system, trimmed)
+	req.MaxTokens = maxTokens
+	req.Stop = append(append([]string(nil), req.Stop...), c.template.Stop()...)
+	completion, err := c.model.backend.generate(ctx, req, onToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	return completion, trimmed, nil
+}
+
+func (c *Conversation) render(system string, messages []Message) string {
+	if system != "" {
+		messages = append([]Message{{Role: RoleSystem, Content: system}}, messages...)
+	}
+	return c.template.Render(messages)
+}
@@ -1,8 +1,12 @@
 package llm
 
 import (
+	"context"
 	"fmt"
+	"log"
+	"time"
 
+	"github.com/owen-6936/llm-cortex/core/models/gguf"
 	"github.com/owen-6936/llm-cortex/spawn"
 )
 
@@ -10,17 +14,39 @@ var (
 	llmManager = NewLLMManager()
 )
 
-// GGUFModel represents a loaded GGUF model instance, managed as a persistent
-// interactive `llama-cli` process.
+// GGUFModel represents a loaded GGUF model instance, managed either as a persistent
+// interactive `llama-cli` process or as a `llama-server` reached over HTTP.
 type GGUFModel struct {
 	Settings  Settings
-	SessionID string
+	SessionID string     // Empty when attached to an external llama-server
+	Backend   string     // BackendCLI or BackendServer
+	Metadata  *gguf.File // Header of the model file; nil if it couldn't be read
+
+	backend backend
+}
+
+// readMetadata reads a model file's header, logging instead of failing since llama.cpp
+// may still be able to load files this reader doesn't understand.
+func readMetadata(modelPath string) *gguf.File {
+	meta, err := gguf.Open(modelPath)
+	if err != nil {
+		log.Printf("Could not read GGUF metadata: %v", err)
+		return nil
+	}
+	return meta
 }
 
 // NewGGUFModel loads a GGUF model into memory by starting a persistent `llama-cli` process
 // in interactive mode.
 func NewGGUFModel(config Settings) (*GGUFModel, error) {
-	sessionID, err := llmManager.Load(config)
+	ctx, cancel := context.WithTimeout(context.Background(), 180*time.Second)
+	defer cancel()
+	return NewGGUFModelContext(ctx, config)
+}
+
+// NewGGUFModelContext is like NewGGUFModel but aborts loading when ctx is done.
+func NewGGUFModelContext(ctx context.Context, config Settings) (*GGUFModel, error) {
+	sessionID, err := llmManager.LoadContext(ctx, config)
 	if err != nil {
 		return nil, err
 	}
@@ -28,33 +54,67 @@ func NewGGUFModel(config Settings) (*GGUFModel, error) {
 	return &GGUFModel{
 		Settings:  config,
 		SessionID: sessionID,
+		Backend:   BackendCLI,
+		Metadata:  readMetadata(config.ModelPath),
+		backend:   &cliBackend{sessionID: sessionID},
+	}, nil
+}
+
+// NewGGUFServerModelContext loads a GGUF model by starting a `llama-server` process on a
+// local port, or attaches to the server at opts.URL, and waits until it reports healthy.
+// Unlike llama-cli, the server honours per-request sampling parameters and token limits.
+func NewGGUFServerModelContext(ctx context.Context, config Settings, opts ServerOptions) (*GGUFModel, error) {
+	server, err := llmManager.LoadServerContext(ctx, config, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &GGUFModel{
+		Settings:  config,
+		SessionID: server.sessionID,
+		Backend:   BackendServer,
+		Metadata:  readMetadata(config.ModelPath),
+		backend:   server,
 	}, nil
 }
 
 // SendPrompt sends a prompt to the loaded GGUF model.
-// It sends the prompt to the `llama-cli` process's stdin and waits for the response.
+// It is a convenience wrapper around SendPromptContext with a 5-minute timeout.
 func (m *GGUFModel) SendPrompt(prompt string) (string, error) {
-	// The first prompt needs to be handled differently as the buffer is not reset.
-	// Subsequent prompts will use the standard SendCommandAndWait.
-	// A simple way to check is to see if the buffer contains just the initial "> ".
-	session, ok := spawn.GetSession(m.SessionID)
-	if !ok {
-		return "", fmt.Errorf("session not found for GGUF model")
-	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
+	defer cancel()
+	return m.SendPromptContext(ctx, prompt)
+}
 
-	// The delimiter `\n>` indicates it's ready for the next prompt.
-	output, err := spawn.SendCommandAndWait(m.SessionID, prompt, "\n> ")
+// SendPromptContext sends a prompt to the model and waits for the response.
+// If ctx is cancelled mid-generation, the generation is aborted and the partial output is
+// discarded before the model accepts another prompt.
+func (m *GGUFModel) SendPromptContext(ctx context.Context, prompt string) (string, error) {
+	completion, err := m.Complete(ctx, CompletionRequest{Prompt: prompt})
 	if err != nil {
-		return "", fmt.Errorf("failed to execute GGUF prompt: %w", err)
+		return "", err
 	}
-	session.OutputBuf.Reset() // Clean buffer after successful command
-	return output, nil
+	return completion.Text, nil
 }
 
-// Unload terminates the persistent `llama-cli` process.
+// Health reports an error if the model's backing process is not answering.
+func (m *GGUFModel) Health(ctx context.Context) error {
+	return m.backend.health(ctx)
+}
+
+// Exited returns a channel that receives how the model's llama.cpp process ended. It is
+// nil for models attached to an external llama-server.
+func (m *GGUFModel) Exited() <-chan error {
+	if m.backend.session() == "" {
+		return nil
+	}
+	return spawn.Exited(m.backend.session())
+}
+
+// Unload terminates the model's `llama-cli` or `llama-server` process.
 func (m *GGUFModel) Unload() error {
 	if err := llmManager.Unload(m.Settings.ModelPath); err != nil {
 		return fmt.Errorf("failed to close GGUF session for %s: %w", m.Settings.ModelPath, err)
 	}
 	return nil
-}
\ No newline at end of file
+}
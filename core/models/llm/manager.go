@@ -1,16 +1,19 @@
 package llm
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/owen-6936/llm-cortex/spawn"
 )
 
-// LLMManager handles the lifecycle of persistent `llama-cli` processes.
+// LLMManager handles the lifecycle of persistent `llama-cli` and `llama-server` processes.
 type LLMManager struct {
 	sessions map[string]string
+	servers  map[string]*serverBackend
 	mutex    *sync.Mutex
 }
 
@@ -18,12 +21,22 @@ type LLMManager struct {
 func NewLLMManager() *LLMManager {
 	return &LLMManager{
 		sessions: make(map[string]string),
+		servers:  make(map[string]*serverBackend),
 		mutex:    &sync.Mutex{},
 	}
 }
 
 // Load ensures a GGUF model is loaded, starting a new `llama-cli` session if one doesn't exist.
+// It is a convenience wrapper around LoadContext with a 3-minute timeout.
 func (m *LLMManager) Load(config Settings) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 180*time.Second)
+	defer cancel()
+	return m.LoadContext(ctx, config)
+}
+
+// LoadContext ensures a GGUF model is loaded, starting a new `llama-cli` session if one doesn't exist.
+// Cancelling ctx aborts the wait for the interactive prompt and tears the session down.
+func (m *LLMManager) LoadContext(ctx context.Context, config Settings) (string, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
@@ -33,15 +46,18 @@ func (m *LLMManager) Load(config Settings) (string, error) {
 		args := config.ToArgs(true) // Start in interactive mode
 		cmd := append([]string{"bin/llama-cli"}, args...)
 
-		sessionID, err = spawn.NewShellWithCommand(cmd...)
+		sessionID, err = spawn.NewShellWithOptions(config.Process, cmd...)
 		if err != nil {
 			return "", fmt.Errorf("failed to start llama-cli session: %w", err)
 		}
 		m.sessions[config.ModelPath] = sessionID
 		spawn.StartReading(sessionID, spawn.OutputHandler, spawn.InfoOutputHandler)
+		// llama-cli stops generating on SIGINT and returns to its prompt, which lets a
+		// cancelled request be interrupted instead of running to completion.
+		spawn.SetInterruptSignal(sessionID, os.Interrupt)
 
 		// Wait for llama.cpp to be ready for input.
-		err = spawn.WaitForString(sessionID, "\n> ", 180*time.Second)
+		err = spawn.WaitForStringContext(ctx, sessionID, "\n> ")
 		if err != nil {
 			spawn.CloseSession(sessionID)
 			delete(m.sessions, config.ModelPath)
@@ -51,15 +67,38 @@ func (m *LLMManager) Load(config Settings) (string, error) {
 	return sessionID, nil
 }
 
-// Unload closes the session for a given model path.
+// LoadServerContext ensures a GGUF model is served by `llama-server`, starting one if it
+// isn't running yet. Cancelling ctx aborts the health check and stops the server.
+func (m *LLMManager) LoadServerContext(ctx context.Context, config Settings, opts ServerOptions) (*serverBackend, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if server, ok := m.servers[config.ModelPath]; ok {
+		return server, nil
+	}
+	server, err := startServer(ctx, config, opts)
+	if err != nil {
+		return nil, err
+	}
+	m.servers[config.ModelPath] = server
+	return server, nil
+}
+
+// Unload closes the sessions for a given model path.
 func (m *LLMManager) Unload(modelPath string) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	if server, ok := m.servers[modelPath]; ok {
+		delete(m.servers, modelPath)
+		if err := server.stop(); err != nil {
+			return err
+		}
+	}
 	if sessionID, ok := m.sessions[modelPath]; ok {
 		delete(m.sessions, modelPath)
 		// In interactive mode, llama-cli exits on EOF, which `CloseSession` handles.
 		return spawn.CloseSession(sessionID)
 	}
 	return nil
-}
\ No newline at end of file
+}
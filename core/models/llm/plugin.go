@@ -0,0 +1,249 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/owen-6936/llm-cortex/core/config"
+	"github.com/owen-6936/llm-cortex/core/memory"
+	"github.com/owen-6936/llm-cortex/core/plugin"
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	plugin.Register("gguf", func(cfg config.ModelConfig) (plugin.ModelPlugin, error) {
+		switch cfg.Backend {
+		case "", BackendCLI, BackendServer:
+		default:
+			return nil, fmt.Errorf("unknown backend '%s' for model '%s'", cfg.Backend, cfg.Name)
+		}
+		settings, err := settingsFromConfig(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid settings for model '%s': %w", cfg.Name, err)
+		}
+		return &GGUFPlugin{cfg: cfg, settings: settings}, nil
+	})
+}
+
+// settingsFromConfig overlays a model's `settings:` block from config.yaml on the
+// defaults Recommended derives from the model file, or on Balanced if the file can't be
+// read yet. Unknown keys are rejected so typos don't silently fall back to defaults.
+func settingsFromConfig(cfg config.ModelConfig) (Settings, error) {
+	settings, err := Recommended(cfg.Path, 512)
+	if err != nil {
+		settings = Balanced(cfg.Path, "", 512)
+	}
+	if !cfg.Settings.IsZero() {
+		data, err := yaml.Marshal(&cfg.Settings)
+		if err != nil {
+			return settings, err
+		}
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(&settings); err != nil {
+			return settings, err
+		}
+	}
+	settings.ModelPath = cfg.Path
+	settings.Process = plugin.ProcessOptions(cfg)
+	return settings, settings.Validate()
+}
+
+// SamplingParams are the generation options shared by the completion and chat capabilities.
+type SamplingParams struct {
+	MaxTokens     int      `json:"max_tokens"`
+	Temperature   *float64 `json:"temperature"`
+	TopK          *int     `json:"top_k"`
+	TopP          *float64 `json:"top_p"`
+	MinP          *float64 `json:"min_p"`
+	RepeatPenalty *float64 `json:"repeat_penalty"`
+	Seed          *int     `json:"seed"`
+	Stop          []string `json:"stop"`
+}
+
+// request builds a validated CompletionRequest from the parameters.
+func (s SamplingParams) request(prompt string) (CompletionRequest, error) {
+	req := CompletionRequest{
+		Prompt:        prompt,
+		MaxTokens:     s.MaxTokens,
+		Temperature:   s.Temperature,
+		TopK:          s.TopK,
+		TopP:          s.TopP,
+		MinP:          s.MinP,
+		RepeatPenalty: s.RepeatPenalty,
+		Seed:          s.Seed,
+		Stop:          s.Stop,
+	}
+	if err := req.Validate(); err != nil {
+		return req, fmt.Errorf("%w: %v", plugin.ErrInvalidRequest, err)
+	}
+	return req, nil
+}
+
+// CompletionParams are the parameters accepted by the completion capability.
+type CompletionParams struct {
+	SamplingParams
+	Prompt string `json:"prompt"`
+}
+
+// ChatParams are the parameters accepted by the chat capability. The messages are
+// rendered with the model's chat template and answered without keeping any state.
+type ChatParams struct {
+	SamplingParams
+	Messages []Message `json:"messages"`
+}
+
+// GGUFPlugin serves a GGUF model through the plugin interface.
+type GGUFPlugin struct {
+	cfg      config.ModelConfig
+	settings Settings
+
+	mu    sync.RWMutex
+	model *GGUFModel
+}
+
+func (p *GGUFPlugin) Name() string { return p.cfg.Name }
+func (p *GGUFPlugin) Type() string { return p.cfg.Type }
+
+func (p *GGUFPlugin) Load(ctx context.Context) error {
+	ctx, cancel := plugin.WithDefaultTimeout(ctx, 180*time.Second)
+	defer cancel()
+	var model *GGUFModel
+	var err error
+	if p.onServer() {
+		model, err = NewGGUFServerModelContext(ctx, p.settings, ServerOptions{URL: p.cfg.ServerURL})
+	} else {
+		model, err = NewGGUFModelContext(ctx, p.settings)
+	}
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.model = model
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *GGUFPlugin) Invoke(ctx context.Context, req plugin.Request) (*plugin.Result, error) {
+	p.mu.RLock()
+	model := p.model
+	p.mu.RUnlock()
+	if model == nil {
+		return nil, plugin.ErrNotLoaded
+	}
+	start := time.Now()
+	var completion *Completion
+	switch req.Capability {
+	case plugin.CapabilityCompletion:
+		var params CompletionParams
+		if err := req.Decode(&params); err != nil {
+			return nil, err
+		}
+		if params.Prompt == "" {
+			return nil, fmt.Errorf("%w: prompt is required", plugin.ErrInvalidRequest)
+		}
+		request, err := params.request(params.Prompt)
+		if err != nil {
+			return nil, err
+		}
+		completion, err = model.CompleteStream(ctx, request, req.OnChunk)
+		if err != nil {
+			return nil, err
+		}
+	case plugin.CapabilityChat:
+		if !p.onServer() {
+			return nil, fmt.Errorf("%w: chat on model '%s' %v", plugin.ErrUnsupportedCapability, p.cfg.Name, ErrServerRequired)
+		}
+		var params ChatParams
+		if err := req.Decode(&params); err != nil {
+			return nil, err
+		}
+		if len(params.Messages) == 0 {
+			return nil, fmt.Errorf("%w: messages are required", plugin.ErrInvalidRequest)
+		}
+		request, err := params.request("")
+		if err != nil {
+			return nil, err
+		}
+		conversation := NewConversation(model, ConversationOptions{})
+		completion, err = conversation.Complete(ctx, params.Messages, request, req.OnChunk)
+		if errors.Is(err, ErrContextOverflow) {
+			return nil, fmt.Errorf("%w: %v", plugin.ErrInvalidRequest, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, plugin.ErrUnsupportedCapability
+	}
+	return &plugin.Result{Output: completion, Latency: time.Since(start)}, nil
+}
+
+func (p *GGUFPlugin) Unload() error {
+	p.mu.Lock()
+	model := p.model
+	p.model = nil
+	p.mu.Unlock()
+	if model == nil {
+		return nil
+	}
+	return model.Unload()
+}
+
+func (p *GGUFPlugin) Health(ctx context.Context) plugin.Health {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.model == nil {
+		return plugin.Health{Status: plugin.HealthUnloaded}
+	}
+	if err := p.model.Health(ctx); err != nil {
+		return plugin.Health{Status: plugin.HealthUnhealthy, Detail: err.Error()}
+	}
+	return plugin.Health{Status: plugin.HealthOK}
+}
+
+func (p *GGUFPlugin) Exited() <-chan error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.model == nil {
+		return nil
+	}
+	return p.model.Exited()
+}
+
+// EstimateMemory estimates the footprint of the model file and its KV cache. A model
+// served by an external llama-server takes no memory here.
+func (p *GGUFPlugin) EstimateMemory() (uint64, error) {
+	if p.cfg.ServerURL != "" {
+		return 0, nil
+	}
+	return memory.EstimateGGUF(p.settings.ModelPath, p.settings.CtxSize)
+}
+
+// Describe reports what the model file declares about itself.
+func (p *GGUFPlugin) Describe() interface{} {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.model == nil || p.model.Metadata == nil {
+		return nil
+	}
+	return p.model.Metadata.Summary()
+}
+
+// Capabilities offers chat only on the server backend: every chat request renders the
+// whole conversation into a fresh context, which the interactive llama-cli can't start.
+func (p *GGUFPlugin) Capabilities() []plugin.Capability {
+	if !p.onServer() {
+		return []plugin.Capability{plugin.CapabilityCompletion}
+	}
+	return []plugin.Capability{plugin.CapabilityCompletion, plugin.CapabilityChat}
+}
+
+// onServer reports whether the model runs on llama-server, started here or external.
+func (p *GGUFPlugin) onServer() bool {
+	return p.cfg.Backend == BackendServer || p.cfg.ServerURL != ""
+}
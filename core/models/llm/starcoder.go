@@ -10,4 +10,4 @@ func InvokeStarCoder(config Settings) (string, error) {
 	}
 	defer model.Unload()
 	return model.SendPrompt(config.Prompt)
-}
\ No newline at end of file
+}
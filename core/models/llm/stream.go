@@ -0,0 +1,140 @@
+package llm
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// TokenCallback receives generated text as it is produced. Chunks are whole UTF-8
+// sequences but not necessarily whole tokens; concatenated, they form the completion text.
+type TokenCallback func(chunk string)
+
+// StreamSummary describes a finished streamed generation.
+type StreamSummary struct {
+	Text            string        `json:"text"`
+	TokensGenerated int           `json:"tokens_generated"` // Estimated on the llama-cli backend
+	Elapsed         time.Duration `json:"elapsed"`
+	StopReason      string        `json:"stop_reason"` // FinishStop or FinishLength
+}
+
+// StreamPrompt sends a prompt to the model and calls onToken with the output as it is
+// generated. It is a convenience wrapper around StreamPromptContext with a 5-minute timeout.
+func (m *GGUFModel) StreamPrompt(prompt string, onToken TokenCallback) (*StreamSummary, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
+	defer cancel()
+	return m.StreamPromptContext(ctx, prompt, onToken)
+}
+
+// StreamPromptContext is like SendPromptContext but delivers the output incrementally.
+// onToken runs on the calling goroutine and is never called after StreamPromptContext
+// returns, including when ctx is cancelled mid-generation.
+func (m *GGUFModel) StreamPromptContext(ctx context.Context, prompt string, onToken TokenCallback) (*StreamSummary, error) {
+	start := time.Now()
+	completion, err := m.CompleteStream(ctx, CompletionRequest{Prompt: prompt}, onToken)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamSummary{
+		Text:            completion.Text,
+		TokensGenerated: completion.CompletionTokens,
+		Elapsed:         time.Since(start),
+		StopReason:      completion.FinishReason,
+	}, nil
+}
+
+// CompleteStream is like Complete but calls onToken with the output as it is generated.
+func (m *GGUFModel) CompleteStream(ctx context.Context, req CompletionRequest, onToken TokenCallback) (*Completion, error) {
+	return m.backend.complete(ctx, req, onToken)
+}
+
+// textStreamer turns raw process output into TokenCallback chunks. It holds back
+// incomplete UTF-8 sequences and anything that could be the start of a stop sequence, and
+// goes quiet once a stop sequence has been seen.
+type textStreamer struct {
+	stop    []string
+	onToken TokenCallback
+
+	buf     []byte
+	sent    int
+	stopped bool
+}
+
+// Write adds raw output to the stream. Only the output that hasn't been sent, plus enough
+// before it to catch a stop sequence straddling the two, is searched, so a long
+// generation costs time in proportion to its length.
+func (s *textStreamer) Write(chunk []byte) {
+	if s.stopped {
+		return
+	}
+	s.buf = append(s.buf, chunk...)
+	from := s.scanFrom()
+	if cut, found := applyStop(string(s.buf[from:]), s.stop); found {
+		s.stopped = true
+		s.send(from + len(cut))
+		return
+	}
+	// Keep back a possible stop sequence prefix and any partial rune at the end.
+	end := len(s.buf) - s.holdBack(string(s.buf[max(len(s.buf)-s.maxStopLen(), 0):]))
+	for i := end - 1; i >= s.sent && i >= end-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s.buf[i]) {
+			if !utf8.FullRune(s.buf[i:end]) {
+				end = i
+			}
+			break
+		}
+	}
+	s.send(end)
+}
+
+// Flush sends whatever is still held back, up to the first stop sequence.
+func (s *textStreamer) Flush() {
+	if s.stopped {
+		return
+	}
+	from := s.scanFrom()
+	cut, _ := applyStop(string(s.buf[from:]), s.stop)
+	s.send(from + len(cut))
+}
+
+// scanFrom returns where to search for a stop sequence. None has been seen yet, so a new
+// one ends in the output that hasn't been sent and starts at most maxStopLen-1 bytes
+// before it.
+func (s *textStreamer) scanFrom() int {
+	return max(s.sent-max(s.maxStopLen()-1, 0), 0)
+}
+
+// maxStopLen returns the length of the longest stop sequence.
+func (s *textStreamer) maxStopLen() int {
+	longest := 0
+	for _, stop := range s.stop {
+		longest = max(longest, len(stop))
+	}
+	return longest
+}
+
+// holdBack returns the length of the longest suffix of text that is a proper prefix of a
+// stop sequence. Only the last maxStopLen bytes of the output need to be passed.
+func (s *textStreamer) holdBack(text string) int {
+	longest := 0
+	for _, stop := range s.stop {
+		for n := len(stop) - 1; n > longest; n-- {
+			if strings.HasSuffix(text, stop[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+func (s *textStreamer) send(end int) {
+	if end <= s.sent {
+		return
+	}
+	if s.onToken != nil {
+		s.onToken(string(s.buf[s.sent:end]))
+	}
+	s.sent = end
+}
@@ -0,0 +1,117 @@
+package llm
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/owen-6936/llm-cortex/core/models/gguf"
+)
+
+// Role is the author of a chat message.
+type Role string
+
+const (
+	RoleSystem    Role = "system"
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+)
+
+// Message is a single turn of a conversation.
+type Message struct {
+	Role    Role   `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatTemplate renders a conversation into the prompt format a model was trained on.
+type ChatTemplate interface {
+	// Name returns the template's identifier, as used in `chat_format` settings.
+	Name() string
+	// Render formats the messages and opens an assistant turn for the model to complete.
+	Render(messages []Message) string
+	// Stop returns the sequences that mark the end of the assistant's turn.
+	Stop() []string
+}
+
+// Built-in chat templates.
+var (
+	// ChatML is the format used by Qwen models.
+	ChatML ChatTemplate = chatMLTemplate{}
+	// StarCoder2Instruct is the format used by starcoder2-15b-instruct.
+	StarCoder2Instruct ChatTemplate = starCoder2Template{}
+)
+
+var chatTemplates = map[string]ChatTemplate{
+	ChatML.Name():             ChatML,
+	StarCoder2Instruct.Name(): StarCoder2Instruct,
+}
+
+// TemplateByName returns a built-in chat template.
+func TemplateByName(name string) (ChatTemplate, error) {
+	if t, ok := chatTemplates[name]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("unknown chat template '%s'", name)
+}
+
+// TemplateFor picks the chat template for a model: the one its chat_format setting names,
+// otherwise one recognized from the file's metadata (nil if unavailable) or name, falling
+// back to ChatML. llama.cpp's own chat_template setting plays no part in it.
+func TemplateFor(settings Settings, meta *gguf.File) ChatTemplate {
+	if t, err := TemplateByName(settings.ChatFormat); err == nil {
+		return t
+	}
+	if meta != nil {
+		embedded := meta.ChatTemplate()
+		switch {
+		case strings.Contains(embedded, "<|im_start|>"):
+			return ChatML
+		case strings.Contains(embedded, "### Instruction"), meta.Architecture() == "starcoder2":
+			return StarCoder2Instruct
+		}
+	}
+	name := strings.ToLower(filepath.Base(settings.ModelPath))
+	if strings.Contains(name, "starcoder2") {
+		return StarCoder2Instruct
+	}
+	return ChatML
+}
+
+type chatMLTemplate struct{}
+
+func (chatMLTemplate) Name() string { return "chatml" }
+
+func (chatMLTemplate) Render(messages []Message) string {
+	var b strings.Builder
+	for _, m := range messages {
+		fmt.Fprintf(&b, "<|im_start|>%s\n%s<|im_end|>\n", m.Role, m.Content)
+	}
+	b.WriteString("<|im_start|>assistant\n")
+	return b.String()
+}
+
+func (chatMLTemplate) Stop() []string { return []string{"<|im_end|>", "<|im_start|>"} }
+
+type starCoder2Template struct{}
+
+func (starCoder2Template) Name() string { return "starcoder2" }
+
+// Render follows the template shipped with starcoder2-15b-instruct-v0.1: an optional
+// system preamble followed by "### Instruction" and "### Response" sections.
+func (starCoder2Template) Render(messages []Message) string {
+	var b strings.Builder
+	for _, m := range messages {
+		switch m.Role {
+		case RoleSystem:
+			fmt.Fprintf(&b, "%s\n\n", m.Content)
+		case RoleUser:
+			fmt.Fprintf(&b, "### Instruction\n%s\n\n", m.Content)
+		case RoleAssistant:
+			fmt.Fprintf(&b, "### Response\n%s<|endoftext|>\n\n", m.Content)
+		}
+	}
+	b.WriteString("### Response\n")
+	return b.String()
+}
+
+func (starCoder2Template) Stop() []string { return []string{"<|endoftext|>", "### Instruction"} }
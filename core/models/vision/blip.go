@@ -1,12 +1,13 @@
 package vision
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/owen-6936/llm-cortex/spawn"
-	"github.com/owen-6936/llm-cortex/utils"
+	"github.com/owen-6936/llm-cortex/worker"
 )
 
 // BlipResponse represents the JSON output from the blip.py script.
@@ -21,8 +22,6 @@ var (
 	blipManager = NewModelManager()
 )
 
-const BLIP_JSON_DELIMITER = "END_OF_JSON"
-
 // InvokeBlip runs the BLIP model on the given image with optional prompt.
 // This function is a convenience wrapper that loads the model, sends a single prompt,
 // and then unloads the model. It is less efficient for multiple sequential prompts.
@@ -41,18 +40,32 @@ type Blip struct {
 	ModelPath string // Path to the model files.
 	SessionID string // The unique ID for the underlying shell session.
 	Device    string // The device the model is running on ('cpu' or 'cuda').
+
+	worker *worker.Client // Protocol client for the worker process.
 }
 
 // NewBlip loads a BLIP model into memory by starting a persistent Python process
 // in interactive mode. It returns a Blip struct instance which can be used to
 // send multiple prompts efficiently.
 func NewBlip(modelPath string, device string) (*Blip, error) {
-	sessionID, err := blipManager.Load(
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+	return NewBlipContext(ctx, modelPath, device)
+}
+
+// NewBlipContext is like NewBlip but aborts loading when ctx is done.
+func NewBlipContext(ctx context.Context, modelPath string, device string) (*Blip, error) {
+	return NewBlipWithOptions(ctx, modelPath, device, spawn.Options{})
+}
+
+// NewBlipWithOptions is like NewBlipContext but starts the Python process with opts.
+func NewBlipWithOptions(ctx context.Context, modelPath string, device string, opts spawn.Options) (*Blip, error) {
+	client, err := blipManager.LoadWithOptions(
+		ctx,
 		modelPath,
 		device,
 		"python/models/vision/blip.py",
-		"[BLIP] Ready.",
-		120*time.Second,
+		opts,
 	)
 	if err != nil {
 		return nil, err
@@ -60,39 +73,37 @@ func NewBlip(modelPath string, device string) (*Blip, error) {
 
 	return &Blip{
 		ModelPath: modelPath,
-		SessionID: sessionID,
+		SessionID: client.SessionID(),
 		Device:    device,
+		worker:    client,
 	}, nil
 }
 
 // SendPrompt sends a request to the loaded BLIP model.
-// It marshals the request, sends it to the Python process, and parses the JSON response.
+// It is a convenience wrapper around SendPromptContext with a 5-minute timeout.
 func (b *Blip) SendPrompt(imagePath string, prompt string, useFast bool, legacy bool, maxLength int16) (BlipResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
+	defer cancel()
+	return b.SendPromptContext(ctx, imagePath, prompt, useFast, legacy, maxLength)
+}
+
+// SendPromptContext sends a request to the loaded BLIP model.
+// It sends the request to the Python process over the worker protocol and decodes the result.
+// If ctx is done before the response arrives, the late response is discarded by the client.
+func (b *Blip) SendPromptContext(ctx context.Context, imagePath string, prompt string, useFast bool, legacy bool, maxLength int16) (BlipResponse, error) {
 	// Create JSON request for the interactive Python script
 	request := map[string]interface{}{
 		"image_path": imagePath,
 		"prompt":     prompt,
 		"max_length": maxLength,
 	}
-	jsonRequest, err := json.Marshal(request)
-	utils.HandleError(err, "failed to marshal blip request")
-
-	// Send command and wait for response
-	output, err := spawn.SendCommandAndWait(b.SessionID, string(jsonRequest), BLIP_JSON_DELIMITER)
-	if err != nil {
-		return BlipResponse{}, fmt.Errorf("failed to execute blip command: %w", err)
-	}
-
 	var response BlipResponse
-	var errorResponse struct {
-		Error string `json:"error"`
-	}
-	if err := json.Unmarshal([]byte(output), &errorResponse); err == nil && errorResponse.Error != "" {
-		return BlipResponse{}, fmt.Errorf("blip.py script error: %s", errorResponse.Error)
-	}
-
-	if err := json.Unmarshal([]byte(output), &response); err != nil {
-		return BlipResponse{}, fmt.Errorf("failed to parse blip.py output: %w\nOutput: %s", err, output)
+	if _, err := b.worker.Call(ctx, "invoke", request, &response); err != nil {
+		var remoteErr *worker.RemoteError
+		if errors.As(err, &remoteErr) {
+			return BlipResponse{}, fmt.Errorf("blip.py returned an error: %w", err)
+		}
+		return BlipResponse{}, fmt.Errorf("failed to execute blip command: %w", err)
 	}
 
 	return response, nil
@@ -105,4 +116,7 @@ func (b *Blip) UnloadBlipModel() error {
 		return fmt.Errorf("failed to close blip session for %s: %w", b.ModelPath, err)
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+func (b *Blip) client() *worker.Client { return b.worker }
+func (b *Blip) unload() error          { return b.UnloadBlipModel() }
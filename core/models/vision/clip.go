@@ -1,12 +1,13 @@
 package vision
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/owen-6936/llm-cortex/spawn"
-	"github.com/owen-6936/llm-cortex/utils"
+	"github.com/owen-6936/llm-cortex/worker"
 )
 
 // ClipResponse represents the JSON output from the clip.py script.
@@ -20,8 +21,6 @@ var (
 	clipManager = NewModelManager()
 )
 
-const CLIP_JSON_DELIMITER = "END_OF_JSON"
-
 // InvokeClip runs the CLIP model on the given image against a list of text labels.
 // This function is a convenience wrapper that loads the model, sends a single prompt,
 // and then unloads the model. It is less efficient for multiple sequential prompts.
@@ -41,18 +40,32 @@ type Clip struct {
 	ModelPath string // Path to the model files.
 	SessionID string // The unique ID for the underlying shell session.
 	Device    string // The device the model is running on ('cpu' or 'cuda').
+
+	worker *worker.Client // Protocol client for the worker process.
 }
 
 // NewClip loads a CLIP model into memory by starting a persistent Python process
 // in interactive mode. It returns a Clip struct instance which can be used to
 // send multiple prompts efficiently.
 func NewClip(modelPath string, device string) (*Clip, error) {
-	sessionID, err := clipManager.Load(
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
+	return NewClipContext(ctx, modelPath, device)
+}
+
+// NewClipContext is like NewClip but aborts loading when ctx is done.
+func NewClipContext(ctx context.Context, modelPath string, device string) (*Clip, error) {
+	return NewClipWithOptions(ctx, modelPath, device, spawn.Options{})
+}
+
+// NewClipWithOptions is like NewClipContext but starts the Python process with opts.
+func NewClipWithOptions(ctx context.Context, modelPath string, device string, opts spawn.Options) (*Clip, error) {
+	client, err := clipManager.LoadWithOptions(
+		ctx,
 		modelPath,
 		device,
 		"python/models/vision/clip.py",
-		"[CLIP] Ready.",
-		90*time.Second,
+		opts,
 	)
 	if err != nil {
 		return nil, err
@@ -60,36 +73,35 @@ func NewClip(modelPath string, device string) (*Clip, error) {
 
 	return &Clip{
 		ModelPath: modelPath,
-		SessionID: sessionID,
+		SessionID: client.SessionID(),
 		Device:    device,
+		worker:    client,
 	}, nil
 }
 
 // SendPrompt sends a request to the loaded CLIP model.
-// It marshals the request, sends it to the Python process, and parses the JSON response.
+// It is a convenience wrapper around SendPromptContext with a 5-minute timeout.
 func (c *Clip) SendPrompt(imagePath string, texts []string, useFast bool) (ClipResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
+	defer cancel()
+	return c.SendPromptContext(ctx, imagePath, texts, useFast)
+}
+
+// SendPromptContext sends a request to the loaded CLIP model.
+// It sends the request to the Python process over the worker protocol and decodes the result.
+// If ctx is done before the response arrives, the late response is discarded by the client.
+func (c *Clip) SendPromptContext(ctx context.Context, imagePath string, texts []string, useFast bool) (ClipResponse, error) {
 	request := map[string]interface{}{
 		"image_path": imagePath,
 		"texts":      texts,
 	}
-	jsonRequest, err := json.Marshal(request)
-	utils.HandleError(err, "failed to marshal clip request")
-
-	output, err := spawn.SendCommandAndWait(c.SessionID, string(jsonRequest), CLIP_JSON_DELIMITER)
-	if err != nil {
-		return ClipResponse{}, fmt.Errorf("failed to execute clip command: %w", err)
-	}
-
 	var response ClipResponse
-	// Check for a JSON error object from the script
-	var errorResponse struct{ Error string `json:"error"` }
-	if err := json.Unmarshal([]byte(output), &errorResponse); err == nil && errorResponse.Error != "" {
-		return ClipResponse{}, fmt.Errorf("clip.py returned an error: %s", errorResponse.Error)
-	}
-
-	// Parse the successful response
-	if err := json.Unmarshal([]byte(output), &response); err != nil {
-		return ClipResponse{}, fmt.Errorf("failed to parse clip.py output: %w\nOutput: %s", err, output)
+	if _, err := c.worker.Call(ctx, "invoke", request, &response); err != nil {
+		var remoteErr *worker.RemoteError
+		if errors.As(err, &remoteErr) {
+			return ClipResponse{}, fmt.Errorf("clip.py returned an error: %w", err)
+		}
+		return ClipResponse{}, fmt.Errorf("failed to execute clip command: %w", err)
 	}
 
 	return response, nil
@@ -102,4 +114,7 @@ func (c *Clip) UnloadClipModel() error {
 		return fmt.Errorf("failed to close clip session for %s: %w", c.ModelPath, err)
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+func (c *Clip) client() *worker.Client { return c.worker }
+func (c *Clip) unload() error          { return c.UnloadClipModel() }
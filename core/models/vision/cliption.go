@@ -1,12 +1,13 @@
 package vision
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/owen-6936/llm-cortex/spawn"
-	"github.com/owen-6936/llm-cortex/utils"
+	"github.com/owen-6936/llm-cortex/worker"
 )
 
 // CLIPtionResponse represents the JSON output from the cliption.py script.
@@ -20,8 +21,6 @@ var (
 	cliptionManager = NewModelManager()
 )
 
-const CLIPTION_JSON_DELIMITER = "END_OF_JSON"
-
 // InvokeCLIPtion is a convenience wrapper that loads the model, sends a single prompt,
 // and then unloads the model. It is less efficient for multiple sequential prompts.
 func InvokeCLIPtion(modelPath string, imagePath string, useFast bool, beamSearch bool, beamWidth int, bestOf int, temperature float32, device string) (CLIPtionResponse, error) {
@@ -40,18 +39,32 @@ type CLIPtion struct {
 	ModelPath string // Path to the model files.
 	SessionID string // The unique ID for the underlying shell session.
 	Device    string // The device the model is running on ('cpu' or 'cuda').
+
+	worker *worker.Client // Protocol client for the worker process.
 }
 
 // NewCLIPtion loads a CLIPtion model into memory by starting a persistent Python process
 // in interactive mode. It returns a CLIPtion struct instance which can be used to
 // send multiple prompts efficiently.
 func NewCLIPtion(modelPath string, device string) (*CLIPtion, error) {
-	sessionID, err := cliptionManager.Load(
+	ctx, cancel := context.WithTimeout(context.Background(), 90*time.Second)
+	defer cancel()
+	return NewCLIPtionContext(ctx, modelPath, device)
+}
+
+// NewCLIPtionContext is like NewCLIPtion but aborts loading when ctx is done.
+func NewCLIPtionContext(ctx context.Context, modelPath string, device string) (*CLIPtion, error) {
+	return NewCLIPtionWithOptions(ctx, modelPath, device, spawn.Options{})
+}
+
+// NewCLIPtionWithOptions is like NewCLIPtionContext but starts the Python process with opts.
+func NewCLIPtionWithOptions(ctx context.Context, modelPath string, device string, opts spawn.Options) (*CLIPtion, error) {
+	client, err := cliptionManager.LoadWithOptions(
+		ctx,
 		modelPath,
 		device,
 		"python/models/vision/cliption/cliption.py",
-		"[CLIPtion] Ready.",
-		90*time.Second,
+		opts,
 	)
 	if err != nil {
 		return nil, err
@@ -59,14 +72,24 @@ func NewCLIPtion(modelPath string, device string) (*CLIPtion, error) {
 
 	return &CLIPtion{
 		ModelPath: modelPath,
-		SessionID: sessionID,
+		SessionID: client.SessionID(),
 		Device:    device,
+		worker:    client,
 	}, nil
 }
 
 // SendPrompt sends a request to the loaded CLIPtion model.
-// It marshals the request, sends it to the Python process, and parses the JSON response.
+// It is a convenience wrapper around SendPromptContext with a 5-minute timeout.
 func (c *CLIPtion) SendPrompt(imagePath string, useFast bool, beamSearch bool, beamWidth int, bestOf int, temperature float32) (CLIPtionResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
+	defer cancel()
+	return c.SendPromptContext(ctx, imagePath, useFast, beamSearch, beamWidth, bestOf, temperature)
+}
+
+// SendPromptContext sends a request to the loaded CLIPtion model.
+// It sends the request to the Python process over the worker protocol and decodes the result.
+// If ctx is done before the response arrives, the late response is discarded by the client.
+func (c *CLIPtion) SendPromptContext(ctx context.Context, imagePath string, useFast bool, beamSearch bool, beamWidth int, bestOf int, temperature float32) (CLIPtionResponse, error) {
 	request := map[string]interface{}{
 		"image_path":  imagePath,
 		"use_fast":    useFast,
@@ -75,24 +98,13 @@ func (c *CLIPtion) SendPrompt(imagePath string, useFast bool, beamSearch bool, b
 		"best_of":     bestOf,
 		"temperature": temperature,
 	}
-	jsonRequest, err := json.Marshal(request)
-	utils.HandleError(err, "failed to marshal cliption request")
-
-	output, err := spawn.SendCommandAndWait(c.SessionID, string(jsonRequest), CLIPTION_JSON_DELIMITER)
-	if err != nil {
-		return CLIPtionResponse{}, fmt.Errorf("failed to execute cliption command: %w", err)
-	}
-
 	var response CLIPtionResponse
-	// Check for a JSON error object from the script
-	var errorResponse struct{ Error string `json:"error"` }
-	if err := json.Unmarshal([]byte(output), &errorResponse); err == nil && errorResponse.Error != "" {
-		return CLIPtionResponse{}, fmt.Errorf("cliption.py returned an error: %s", errorResponse.Error)
-	}
-
-	// Parse the successful response
-	if err := json.Unmarshal([]byte(output), &response); err != nil {
-		return CLIPtionResponse{}, fmt.Errorf("failed to parse cliption.py output: %w\nOutput: %s", err, output)
+	if _, err := c.worker.Call(ctx, "invoke", request, &response); err != nil {
+		var remoteErr *worker.RemoteError
+		if errors.As(err, &remoteErr) {
+			return CLIPtionResponse{}, fmt.Errorf("cliption.py returned an error: %w", err)
+		}
+		return CLIPtionResponse{}, fmt.Errorf("failed to execute cliption command: %w", err)
 	}
 
 	return response, nil
@@ -105,4 +117,7 @@ func (c *CLIPtion) UnloadCLIPtionModel() error {
 		return fmt.Errorf("failed to close cliption session for %s: %w", c.ModelPath, err)
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+func (c *CLIPtion) client() *worker.Client { return c.worker }
+func (c *CLIPtion) unload() error          { return c.UnloadCLIPtionModel() }
@@ -2,4 +2,4 @@ package vision
 
 // PythonVenvPath specifies the path to the python executable within the virtual environment.
 // Modify this path to match your local setup.
-var PythonVenvPath string
\ No newline at end of file
+var PythonVenvPath string
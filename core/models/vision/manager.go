@@ -1,35 +1,51 @@
 package vision
 
 import (
+	"context"
 	"fmt"
 	"sync"
 	"time"
 
 	"github.com/owen-6936/llm-cortex/spawn"
+	"github.com/owen-6936/llm-cortex/worker"
 )
 
 // ModelManager handles the lifecycle of persistent Python model processes.
 type ModelManager struct {
-	sessions map[string]string
+	sessions map[string]*worker.Client
 	mutex    *sync.Mutex
 }
 
 // NewModelManager creates a new manager for model sessions.
 func NewModelManager() *ModelManager {
 	return &ModelManager{
-		sessions: make(map[string]string),
+		sessions: make(map[string]*worker.Client),
 		mutex:    &sync.Mutex{},
 	}
 }
 
 // Load ensures a model is loaded, starting a new session if one doesn't exist for the given model path.
-func (m *ModelManager) Load(modelPath, device, pythonScript, readyString string, timeout time.Duration) (string, error) {
+// It is a convenience wrapper around LoadContext that gives up after timeout.
+func (m *ModelManager) Load(modelPath, device, pythonScript string, timeout time.Duration) (*worker.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return m.LoadContext(ctx, modelPath, device, pythonScript)
+}
+
+// LoadContext ensures a model is loaded, starting a new session if one doesn't exist for the given
+// model path. It returns a protocol client for the worker once the script reports it is ready.
+// Cancelling ctx aborts the wait and tears the session down.
+func (m *ModelManager) LoadContext(ctx context.Context, modelPath, device, pythonScript string) (*worker.Client, error) {
+	return m.LoadWithOptions(ctx, modelPath, device, pythonScript, spawn.Options{})
+}
+
+// LoadWithOptions is like LoadContext but starts a new Python process with opts.
+func (m *ModelManager) LoadWithOptions(ctx context.Context, modelPath, device, pythonScript string, opts spawn.Options) (*worker.Client, error) {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	sessionID, ok := m.sessions[modelPath]
+	client, ok := m.sessions[modelPath]
 	if !ok {
-		var err error
 		cmd := []string{
 			PythonVenvPath,
 			pythonScript,
@@ -37,21 +53,26 @@ func (m *ModelManager) Load(modelPath, device, pythonScript, readyString string,
 			"--device", device,
 			"--interactive",
 		}
-		sessionID, err = spawn.NewShellWithCommand(cmd...)
+		sessionID, err := spawn.NewShellWithOptions(opts, cmd...)
 		if err != nil {
-			return "", fmt.Errorf("failed to start session for %s: %w", pythonScript, err)
+			return nil, fmt.Errorf("failed to start session for %s: %w", pythonScript, err)
+		}
+		// Attach the client before reading starts so the ready frame cannot be missed.
+		client, err = worker.NewClient(sessionID)
+		if err != nil {
+			spawn.CloseSession(sessionID)
+			return nil, fmt.Errorf("failed to attach to session for %s: %w", pythonScript, err)
 		}
-		m.sessions[modelPath] = sessionID
 		spawn.StartReading(sessionID, spawn.OutputHandler, spawn.ErrorOutputHandler)
 
-		err = spawn.WaitForString(sessionID, readyString, timeout)
-		if err != nil {
+		if err := client.WaitReady(ctx); err != nil {
+			client.Close()
 			spawn.CloseSession(sessionID)
-			delete(m.sessions, modelPath)
-			return "", fmt.Errorf("error waiting for model '%s' to load: %w", modelPath, err)
+			return nil, fmt.Errorf("error waiting for model '%s' to load: %w", modelPath, err)
 		}
+		m.sessions[modelPath] = client
 	}
-	return sessionID, nil
+	return client, nil
 }
 
 // Unload closes the session for a given model path.
@@ -59,9 +80,10 @@ func (m *ModelManager) Unload(modelPath string) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
-	if sessionID, ok := m.sessions[modelPath]; ok {
+	if client, ok := m.sessions[modelPath]; ok {
 		delete(m.sessions, modelPath)
-		return spawn.CloseSession(sessionID)
+		client.Close()
+		return spawn.CloseSession(client.SessionID())
 	}
 	return nil
-}
\ No newline at end of file
+}
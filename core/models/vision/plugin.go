@@ -0,0 +1,274 @@
+package vision
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/owen-6936/llm-cortex/core/config"
+	"github.com/owen-6936/llm-cortex/core/memory"
+	"github.com/owen-6936/llm-cortex/core/plugin"
+	"github.com/owen-6936/llm-cortex/spawn"
+	"github.com/owen-6936/llm-cortex/worker"
+)
+
+func init() {
+	plugin.Register("blip", func(cfg config.ModelConfig) (plugin.ModelPlugin, error) {
+		return &BlipPlugin{visionPlugin: visionPlugin{cfg: cfg, timeout: 120 * time.Second, start: startBlip}}, nil
+	})
+	plugin.Register("clip", func(cfg config.ModelConfig) (plugin.ModelPlugin, error) {
+		return &ClipPlugin{visionPlugin: visionPlugin{cfg: cfg, timeout: 90 * time.Second, start: startClip}}, nil
+	})
+	plugin.Register("cliption", func(cfg config.ModelConfig) (plugin.ModelPlugin, error) {
+		return &CLIPtionPlugin{visionPlugin: visionPlugin{cfg: cfg, timeout: 90 * time.Second, start: startCLIPtion}}, nil
+	})
+}
+
+// CaptionParams are the parameters accepted by the caption and vqa capabilities.
+type CaptionParams struct {
+	ImagePath   string  `json:"image_path"`
+	Prompt      string  `json:"prompt"`      // BLIP only: a question or caption prefix
+	MaxLength   int16   `json:"max_length"`  // BLIP only
+	BeamSearch  bool    `json:"beam_search"` // CLIPtion only
+	BeamWidth   int     `json:"beam_width"`  // CLIPtion only
+	BestOf      int     `json:"best_of"`     // CLIPtion only
+	Temperature float32 `json:"temperature"` // CLIPtion only
+}
+
+// ClassifyParams are the parameters accepted by the classify capability.
+type ClassifyParams struct {
+	ImagePath string   `json:"image_path"`
+	Labels    []string `json:"labels"`
+}
+
+// workerModel is a vision model running in a Python worker process.
+type workerModel interface {
+	client() *worker.Client
+	unload() error
+}
+
+// startFunc starts a vision model's worker.
+type startFunc func(ctx context.Context, path, device string, opts spawn.Options) (workerModel, error)
+
+// visionPlugin holds what the vision plugins have in common: loading, unloading and
+// watching the worker. The plugins themselves only translate requests.
+type visionPlugin struct {
+	cfg     config.ModelConfig
+	timeout time.Duration // Default for loading the model
+	start   startFunc
+
+	mu    sync.RWMutex
+	model workerModel // nil while unloaded
+}
+
+func (p *visionPlugin) Name() string { return p.cfg.Name }
+func (p *visionPlugin) Type() string { return p.cfg.Type }
+
+func (p *visionPlugin) device() string {
+	if p.cfg.Device == "" {
+		return "cpu"
+	}
+	return p.cfg.Device
+}
+
+func (p *visionPlugin) Load(ctx context.Context) error {
+	ctx, cancel := plugin.WithDefaultTimeout(ctx, p.timeout)
+	defer cancel()
+	model, err := p.start(ctx, p.cfg.Path, p.device(), plugin.ProcessOptions(p.cfg))
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.model = model
+	p.mu.Unlock()
+	return nil
+}
+
+// loaded returns the model, or nil while it is unloaded.
+func (p *visionPlugin) loaded() workerModel {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.model
+}
+
+func (p *visionPlugin) Unload() error {
+	p.mu.Lock()
+	model := p.model
+	p.model = nil
+	p.mu.Unlock()
+	if model == nil {
+		return nil
+	}
+	return model.unload()
+}
+
+func (p *visionPlugin) Health(ctx context.Context) plugin.Health {
+	model := p.loaded()
+	if model == nil {
+		return plugin.Health{Status: plugin.HealthUnloaded}
+	}
+	select {
+	case <-model.client().Done():
+		return plugin.Health{Status: plugin.HealthUnhealthy, Detail: "worker process exited"}
+	default:
+		return plugin.Health{Status: plugin.HealthOK}
+	}
+}
+
+// Exited reports when the worker's process ends; it is nil while the model is unloaded.
+func (p *visionPlugin) Exited() <-chan error {
+	model := p.loaded()
+	if model == nil {
+		return nil
+	}
+	return spawn.Exited(model.client().SessionID())
+}
+
+// EstimateMemory estimates the footprint of the model's weights in PyTorch.
+func (p *visionPlugin) EstimateMemory() (uint64, error) {
+	return memory.EstimateDir(p.cfg.Path)
+}
+
+func startBlip(ctx context.Context, path, device string, opts spawn.Options) (workerModel, error) {
+	model, err := NewBlipWithOptions(ctx, path, device, opts)
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func startClip(ctx context.Context, path, device string, opts spawn.Options) (workerModel, error) {
+	model, err := NewClipWithOptions(ctx, path, device, opts)
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func startCLIPtion(ctx context.Context, path, device string, opts spawn.Options) (workerModel, error) {
+	model, err := NewCLIPtionWithOptions(ctx, path, device, opts)
+	if err != nil {
+		return nil, err
+	}
+	return model, nil
+}
+
+func decodeCaption(req plugin.Request) (CaptionParams, error) {
+	var params CaptionParams
+	if err := req.Decode(&params); err != nil {
+		return params, err
+	}
+	if params.ImagePath == "" {
+		return params, fmt.Errorf("%w: image_path is required", plugin.ErrInvalidRequest)
+	}
+	return params, nil
+}
+
+// BlipPlugin serves a BLIP-2 model through the plugin interface.
+type BlipPlugin struct {
+	visionPlugin
+}
+
+func (p *BlipPlugin) Invoke(ctx context.Context, req plugin.Request) (*plugin.Result, error) {
+	model, ok := p.loaded().(*Blip)
+	if !ok {
+		return nil, plugin.ErrNotLoaded
+	}
+	if req.Capability != plugin.CapabilityCaption && req.Capability != plugin.CapabilityVQA {
+		return nil, plugin.ErrUnsupportedCapability
+	}
+	params, err := decodeCaption(req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Capability == plugin.CapabilityVQA && params.Prompt == "" {
+		return nil, fmt.Errorf("%w: prompt is required for vqa", plugin.ErrInvalidRequest)
+	}
+	if params.MaxLength <= 0 {
+		params.MaxLength = 75
+	}
+
+	start := time.Now()
+	response, err := model.SendPromptContext(ctx, params.ImagePath, params.Prompt, true, false, params.MaxLength)
+	if err != nil {
+		return nil, err
+	}
+	return &plugin.Result{Output: response, Latency: time.Since(start)}, nil
+}
+
+func (p *BlipPlugin) Capabilities() []plugin.Capability {
+	return []plugin.Capability{plugin.CapabilityCaption, plugin.CapabilityVQA}
+}
+
+// ClipPlugin serves a CLIP model through the plugin interface.
+type ClipPlugin struct {
+	visionPlugin
+}
+
+func (p *ClipPlugin) Invoke(ctx context.Context, req plugin.Request) (*plugin.Result, error) {
+	model, ok := p.loaded().(*Clip)
+	if !ok {
+		return nil, plugin.ErrNotLoaded
+	}
+	if req.Capability != plugin.CapabilityClassify {
+		return nil, plugin.ErrUnsupportedCapability
+	}
+	var params ClassifyParams
+	if err := req.Decode(&params); err != nil {
+		return nil, err
+	}
+	if params.ImagePath == "" || len(params.Labels) == 0 {
+		return nil, fmt.Errorf("%w: image_path and labels are required", plugin.ErrInvalidRequest)
+	}
+
+	start := time.Now()
+	response, err := model.SendPromptContext(ctx, params.ImagePath, params.Labels, true)
+	if err != nil {
+		return nil, err
+	}
+	return &plugin.Result{Output: response, Latency: time.Since(start)}, nil
+}
+
+func (p *ClipPlugin) Capabilities() []plugin.Capability {
+	return []plugin.Capability{plugin.CapabilityClassify}
+}
+
+// CLIPtionPlugin serves a CLIPtion model through the plugin interface.
+type CLIPtionPlugin struct {
+	visionPlugin
+}
+
+func (p *CLIPtionPlugin) Invoke(ctx context.Context, req plugin.Request) (*plugin.Result, error) {
+	model, ok := p.loaded().(*CLIPtion)
+	if !ok {
+		return nil, plugin.ErrNotLoaded
+	}
+	if req.Capability != plugin.CapabilityCaption {
+		return nil, plugin.ErrUnsupportedCapability
+	}
+	params, err := decodeCaption(req)
+	if err != nil {
+		return nil, err
+	}
+	if params.BeamWidth <= 0 {
+		params.BeamWidth = 5
+	}
+	if params.BestOf <= 0 {
+		params.BestOf = 5
+	}
+	if params.Temperature <= 0 {
+		params.Temperature = 1.0
+	}
+
+	start := time.Now()
+	response, err := model.SendPromptContext(ctx, params.ImagePath, true, params.BeamSearch, params.BeamWidth, params.BestOf, params.Temperature)
+	if err != nil {
+		return nil, err
+	}
+	return &plugin.Result{Output: response, Latency: time.Since(start)}, nil
+}
+
+func (p *CLIPtionPlugin) Capabilities() []plugin.Capability {
+	return []plugin.Capability{plugin.CapabilityCaption}
+}
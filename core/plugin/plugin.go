@@ -0,0 +1,246 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/owen-6936/llm-cortex/core/config"
+	"github.com/owen-6936/llm-cortex/spawn"
+)
+
+// Capability names a task a model plugin can perform.
+type Capability string
+
+const (
+	CapabilityCaption    Capability = "caption"    // Describe an image in natural language
+	CapabilityVQA        Capability = "vqa"        // Answer a question about an image
+	CapabilityClassify   Capability = "classify"   // Zero-shot image classification against text labels
+	CapabilityCompletion Capability = "completion" // Continue a text prompt
+	CapabilityChat       Capability = "chat"       // Reply to a list of chat messages
+)
+
+var (
+	// ErrNotLoaded is returned when a plugin is invoked before Load succeeded.
+	ErrNotLoaded = errors.New("model is not loaded")
+	// ErrUnsupportedCapability is returned when a plugin is asked for a task it cannot perform.
+	ErrUnsupportedCapability = errors.New("capability not supported by model")
+	// ErrInvalidRequest is returned when request parameters are missing or malformed.
+	ErrInvalidRequest = errors.New("invalid request")
+)
+
+// Request is a single invocation of a model plugin.
+type Request struct {
+	Capability Capability             // The task to perform
+	Params     map[string]interface{} // Task-specific parameters, e.g. "image_path" or "prompt"
+	// OnChunk, if set, receives output incrementally from plugins that can stream it.
+	// Plugins that can't ignore it; the complete output is always in the Result.
+	OnChunk func(chunk string)
+}
+
+// Decode copies the request parameters into a typed struct using its JSON tags.
+func (r Request) Decode(v interface{}) error {
+	data, err := json.Marshal(r.Params)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: bad parameters for %s: %v", ErrInvalidRequest, r.Capability, err)
+	}
+	return nil
+}
+
+// Result is the outcome of a successful invocation.
+type Result struct {
+	Output  interface{}   // Model-specific response, e.g. vision.BlipResponse
+	Latency time.Duration // Wall-clock time spent in Invoke
+}
+
+// HealthStatus summarizes the state of a plugin's backing process.
+type HealthStatus string
+
+const (
+	HealthOK        HealthStatus = "ok"
+	HealthUnloaded  HealthStatus = "unloaded"
+	HealthUnhealthy HealthStatus = "unhealthy"
+)
+
+// Health is a point-in-time report on a plugin.
+type Health struct {
+	Status HealthStatus `json:"status"`
+	Detail string       `json:"detail,omitempty"`
+}
+
+// ModelPlugin is the interface every model served by the engine implements.
+type ModelPlugin interface {
+	// Name returns the unique name of the model from the configuration.
+	Name() string
+	// Type returns the model type the plugin was created for, e.g. "blip" or "gguf".
+	Type() string
+	// Load starts the model's backing process and blocks until it is ready.
+	Load(ctx context.Context) error
+	// Invoke runs a single request against the loaded model.
+	Invoke(ctx context.Context, req Request) (*Result, error)
+	// Unload stops the backing process and releases its resources.
+	Unload() error
+	// Health reports whether the backing process is up.
+	Health(ctx context.Context) Health
+	// Capabilities lists the tasks the model can perform.
+	Capabilities() []Capability
+}
+
+// Describer is implemented by plugins that can report details about their model, such as
+// its architecture or quantization. Describe returns nil when there is nothing to report.
+type Describer interface {
+	Describe() interface{}
+}
+
+// LoadState is whether a model is in memory.
+type LoadState string
+
+const (
+	LoadStateUnloaded   LoadState = "unloaded"
+	LoadStateLoading    LoadState = "loading"
+	LoadStateLoaded     LoadState = "loaded"
+	LoadStateRestarting LoadState = "restarting" // The process died and a restart is scheduled
+	LoadStateFailed     LoadState = "failed"     // The process died and won't be restarted
+)
+
+// ProcessExit describes how a model's process ended.
+type ProcessExit struct {
+	Code   int       `json:"code"`             // -1 if the process was killed by a signal
+	Signal string    `json:"signal,omitempty"` // The signal that killed it, if any
+	Stderr string    `json:"stderr,omitempty"` // The last lines it wrote to stderr
+	At     time.Time `json:"at"`
+}
+
+// LifecycleStatus reports how the engine manages a model's process.
+type LifecycleStatus struct {
+	Policy      string       `json:"policy"` // eager, lazy, idle_timeout or pinned
+	State       LoadState    `json:"state"`
+	IdleTimeout string       `json:"idle_timeout,omitempty"`
+	LastUsed    *time.Time   `json:"last_used,omitempty"`
+	Restarts    int          `json:"restarts,omitempty"`  // Consecutive restarts after crashes
+	LastExit    *ProcessExit `json:"last_exit,omitempty"` // The last time the process died
+}
+
+// LifecycleReporter is implemented by plugins whose loading is managed for them.
+type LifecycleReporter interface {
+	Lifecycle() LifecycleStatus
+}
+
+// Supervised is implemented by plugins backed by a local process, so the engine can notice
+// when it dies. Exited returns a channel that receives how the process started by the
+// last Load ended, or nil if there is no such process.
+type Supervised interface {
+	Exited() <-chan error
+}
+
+// MemoryEstimator is implemented by plugins that can estimate how much memory their
+// model takes once loaded, so the engine can keep models within the machine's memory.
+type MemoryEstimator interface {
+	EstimateMemory() (uint64, error)
+}
+
+// Factory creates an unloaded plugin from its configuration.
+type Factory func(cfg config.ModelConfig) (ModelPlugin, error)
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Factory)
+)
+
+// Register makes a factory available for a model type. It is meant to be called from
+// the init function of the package implementing the plugin and panics on duplicates.
+func Register(modelType string, factory Factory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[modelType]; exists {
+		panic(fmt.Sprintf("plugin: factory for model type %q registered twice", modelType))
+	}
+	registry[modelType] = factory
+}
+
+// New creates a plugin for a model configuration using the factory registered for its type.
+func New(cfg config.ModelConfig) (ModelPlugin, error) {
+	registryMu.RLock()
+	factory, ok := registry[cfg.Type]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown model type '%s' for model '%s'", cfg.Type, cfg.Name)
+	}
+	return factory(cfg)
+}
+
+// Types returns the registered model types in sorted order.
+func Types() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	types := make([]string, 0, len(registry))
+	for t := range registry {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// WithDefaultTimeout bounds ctx by timeout unless it already carries a deadline.
+// Plugins use it so callers without an opinion get each model's usual load time.
+func WithDefaultTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// ProcessOptions converts a model's process configuration into spawn options. Model
+// processes always get a process group of their own, so stopping one also stops anything
+// it started, and are labelled with the model's name.
+func ProcessOptions(cfg config.ModelConfig) spawn.Options {
+	p := cfg.Process
+	opts := spawn.Options{
+		Labels:          map[string]string{spawn.LabelKind: spawn.KindModel, spawn.LabelModel: cfg.Name},
+		Dir:             p.Dir,
+		NewProcessGroup: runtime.GOOS == "linux",
+		StopTimeout:     p.StopTimeout,
+		Limits: spawn.Limits{
+			AddressSpace: uint64(p.MaxMemoryMB) << 20,
+			CPUTime:      time.Duration(p.MaxCPUSeconds) * time.Second,
+			OpenFiles:    uint64(p.MaxOpenFiles),
+		},
+		Cgroup: spawn.CgroupOptions{
+			Parent:    p.CgroupParent,
+			MemoryMax: uint64(p.CgroupMemoryMB) << 20,
+			CPUs:      p.CgroupCPUs,
+		},
+		Nice:       p.Nice,
+		CPUs:       p.CPUs,
+		BufferSize: p.OutputBufferKB << 10,
+		Transcript: spawn.TranscriptOptions{
+			Dir:      p.TranscriptDir,
+			MaxBytes: int64(p.TranscriptMaxMB) << 20,
+			MaxFiles: p.TranscriptFiles,
+		},
+		Recording: spawn.RecordingOptions{Dir: p.RecordingDir},
+	}
+	for k, v := range p.Env {
+		opts.Env = append(opts.Env, k+"="+v)
+	}
+	sort.Strings(opts.Env)
+	return opts
+}
+
+// Supports reports whether a plugin advertises a capability.
+func Supports(p ModelPlugin, capability Capability) bool {
+	for _, c := range p.Capabilities() {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
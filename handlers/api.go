@@ -0,0 +1,398 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/owen-6936/llm-cortex/core/memory"
+	"github.com/owen-6936/llm-cortex/core/plugin"
+	"github.com/owen-6936/llm-cortex/scheduler"
+	"github.com/owen-6936/llm-cortex/spawn"
+	"github.com/owen-6936/llm-cortex/worker"
+)
+
+// maxRequestBody caps the size of API request bodies, which may carry base64 images.
+const maxRequestBody = 32 << 20
+
+// ModelRegistry gives the API handlers access to the engine's model plugins.
+type ModelRegistry interface {
+	// Model looks up a plugin by its configured name.
+	Model(name string) (plugin.ModelPlugin, bool)
+	// Models lists every configured plugin.
+	Models() []plugin.ModelPlugin
+}
+
+// SchedulerReporter is implemented by registries that queue model requests on a
+// scheduler, so the API can report on the queues.
+type SchedulerReporter interface {
+	SchedulerStats() []scheduler.Stats
+}
+
+// APIOptions configures the inference API.
+type APIOptions struct {
+	// ImageDir is the directory whose files clients may name in image_path. Empty
+	// rejects image_path, so images can only be uploaded as image_base64.
+	ImageDir string
+}
+
+// API serves the versioned JSON inference endpoints under /api/v1.
+type API struct {
+	models ModelRegistry
+	opts   APIOptions
+}
+
+// NewAPI creates the inference API on top of a model registry.
+func NewAPI(models ModelRegistry, opts APIOptions) *API {
+	return &API{models: models, opts: opts}
+}
+
+// Register adds the API routes to a mux.
+func (a *API) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v1/models", a.ListModelsHandler)
+	mux.HandleFunc("GET /api/v1/models/{name}", a.GetModelHandler)
+	mux.HandleFunc("GET /api/v1/scheduler", a.SchedulerHandler)
+	mux.HandleFunc("GET /api/v1/pipelines", a.ListPipelinesHandler)
+	mux.HandleFunc("POST /api/v1/pipelines/{name}/run", a.RunPipelineHandler)
+	mux.HandleFunc("POST /api/v1/vision/caption", a.capabilityHandler(plugin.CapabilityCaption))
+	mux.HandleFunc("POST /api/v1/vision/vqa", a.capabilityHandler(plugin.CapabilityVQA))
+	mux.HandleFunc("POST /api/v1/vision/classify", a.capabilityHandler(plugin.CapabilityClassify))
+	mux.HandleFunc("POST /api/v1/llm/completions", a.capabilityHandler(plugin.CapabilityCompletion))
+	mux.HandleFunc("POST /api/v1/llm/chat", a.capabilityHandler(plugin.CapabilityChat))
+}
+
+// ModelInfo describes a configured model in API responses.
+type ModelInfo struct {
+	Name         string                  `json:"name"`
+	Type         string                  `json:"type"`
+	Capabilities []plugin.Capability     `json:"capabilities"`
+	Health       plugin.Health           `json:"health"`
+	Lifecycle    *plugin.LifecycleStatus `json:"lifecycle,omitempty"`
+	Details      interface{}             `json:"details,omitempty"`
+}
+
+// InvokeResponse is the body returned by every inference endpoint.
+type InvokeResponse struct {
+	Model     string      `json:"model"`
+	Result    interface{} `json:"result"`
+	LatencyMs float64     `json:"latency_ms"`
+}
+
+// APIError is the body returned by every failed API request.
+type APIError struct {
+	Error APIErrorDetail `json:"error"`
+}
+
+// APIErrorDetail carries a stable machine-readable code next to a human-readable message.
+type APIErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ListModelsHandler returns every model from config.yaml with its capabilities and health.
+func (a *API) ListModelsHandler(w http.ResponseWriter, r *http.Request) {
+	models := a.models.Models()
+	infos := make([]ModelInfo, 0, len(models))
+	for _, p := range models {
+		infos = append(infos, modelInfo(r.Context(), p))
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"models": infos})
+}
+
+// GetModelHandler returns a single model by name.
+func (a *API) GetModelHandler(w http.ResponseWriter, r *http.Request) {
+	p, ok := a.models.Model(r.PathValue("name"))
+	if !ok {
+		writeError(w, http.StatusNotFound, "model_not_found", fmt.Sprintf("model '%s' is not configured", r.PathValue("name")))
+		return
+	}
+	writeJSON(w, http.StatusOK, modelInfo(r.Context(), p))
+}
+
+// QueueStats describes one model's request queue in API responses.
+type QueueStats struct {
+	Model        string  `json:"model"`
+	Workers      int     `json:"workers"`
+	Running      int     `json:"running"`
+	Queued       int     `json:"queued"`
+	Completed    uint64  `json:"completed"`
+	Failed       uint64  `json:"failed"`
+	Cancelled    uint64  `json:"cancelled"`
+	Rejected     uint64  `json:"rejected"`
+	AvgWaitMs    float64 `json:"avg_wait_ms"`
+	MaxWaitMs    float64 `json:"max_wait_ms"`
+	OldestWaitMs float64 `json:"oldest_wait_ms"` // How long the oldest queued request has waited
+}
+
+// SchedulerHandler reports the depth, throughput and wait times of every model's
+// request queue.
+func (a *API) SchedulerHandler(w http.ResponseWriter, r *http.Request) {
+	reporter, ok := a.models.(SchedulerReporter)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "requests are not queued by a scheduler")
+		return
+	}
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	queues := []QueueStats{}
+	for _, st := range reporter.SchedulerStats() {
+		queues = append(queues, QueueStats{
+			Model:        st.Model,
+			Workers:      st.Workers,
+			Running:      st.Running,
+			Queued:       st.Queued,
+			Completed:    st.Completed,
+			Failed:       st.Failed,
+			Cancelled:    st.Cancelled,
+			Rejected:     st.Rejected,
+			AvgWaitMs:    ms(st.AvgWait),
+			MaxWaitMs:    ms(st.MaxWait),
+			OldestWaitMs: ms(st.OldestWait),
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"queues": queues})
+}
+
+// requestContext returns the context to run a request's model calls in. It carries the
+// priority from the X-Priority header, a whole number where higher runs sooner when the
+// model is busy; the default is 0.
+func requestContext(r *http.Request) (context.Context, error) {
+	header := r.Header.Get("X-Priority")
+	if header == "" {
+		return r.Context(), nil
+	}
+	priority, err := strconv.Atoi(header)
+	if err != nil {
+		return nil, fmt.Errorf("X-Priority must be a whole number, got %q", header)
+	}
+	return scheduler.WithPriority(r.Context(), priority), nil
+}
+
+// capabilityHandler returns a handler that runs a request body against a model with the
+// given capability. The body is a JSON object whose "model" field selects the model; all
+// other fields are passed to the plugin as parameters. If "model" is omitted, the first
+// configured model offering the capability is used.
+func (a *API) capabilityHandler(capability plugin.Capability) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]interface{}
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&params); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", "request body must be a JSON object: "+err.Error())
+			return
+		}
+
+		name, _ := params["model"].(string)
+		delete(params, "model")
+		p, err := a.resolveModel(name, capability)
+		if err != nil {
+			writeInvokeError(w, err)
+			return
+		}
+
+		if err := a.checkImagePath(params); err != nil {
+			writeError(w, http.StatusForbidden, "image_path_forbidden", err.Error())
+			return
+		}
+		cleanup, err := materializeImage(params)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+		defer cleanup()
+
+		ctx, err := requestContext(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+			return
+		}
+		result, err := p.Invoke(ctx, plugin.Request{Capability: capability, Params: params})
+		if err != nil {
+			log.Printf("API %s request to model '%s' failed: %v", capability, p.Name(), err)
+			writeInvokeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, InvokeResponse{
+			Model:     p.Name(),
+			Result:    result.Output,
+			LatencyMs: float64(result.Latency.Microseconds()) / 1000,
+		})
+	}
+}
+
+// errModelNotFound is returned when a request names a model that isn't configured.
+var errModelNotFound = errors.New("model not found")
+
+// resolveModel finds the named model, or the first one offering the capability.
+func (a *API) resolveModel(name string, capability plugin.Capability) (plugin.ModelPlugin, error) {
+	if name != "" {
+		p, ok := a.models.Model(name)
+		if !ok {
+			return nil, fmt.Errorf("%w: '%s' is not configured", errModelNotFound, name)
+		}
+		if !plugin.Supports(p, capability) {
+			return nil, fmt.Errorf("%w: model '%s' does not support %s", plugin.ErrUnsupportedCapability, name, capability)
+		}
+		return p, nil
+	}
+	for _, p := range a.models.Models() {
+		if plugin.Supports(p, capability) {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: no configured model supports %s", errModelNotFound, capability)
+}
+
+// materializeImage writes an "image_base64" parameter to a temporary file and replaces it
+// with "image_path", since the Python workers read images from disk.
+func materializeImage(params map[string]interface{}) (func(), error) {
+	encoded, ok := params["image_base64"].(string)
+	if !ok {
+		return func() {}, nil
+	}
+	delete(params, "image_base64")
+	// Accept data URLs as produced by browsers, e.g. "data:image/png;base64,...".
+	if i := strings.Index(encoded, ","); strings.HasPrefix(encoded, "data:") && i >= 0 {
+		encoded = encoded[i+1:]
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("image_base64 is not valid base64: %w", err)
+	}
+	f, err := os.CreateTemp("", "llm-cortex-image-*")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return nil, err
+	}
+	params["image_path"] = f.Name()
+	return func() { os.Remove(f.Name()) }, nil
+}
+
+// checkImagePath keeps clients from making models open arbitrary local files: an
+// image_path in a request must name a file inside ImageDir once symlinks are resolved,
+// and is replaced by that file's absolute path. Relative paths are taken relative to
+// ImageDir.
+func (a *API) checkImagePath(params map[string]interface{}) error {
+	raw, ok := params["image_path"]
+	if !ok {
+		return nil
+	}
+	path, ok := raw.(string)
+	if !ok {
+		return fmt.Errorf("image_path must be a string")
+	}
+	if a.opts.ImageDir == "" {
+		return fmt.Errorf("image_path is disabled on this server; send the image as image_base64")
+	}
+	dir, err := filepath.Abs(a.opts.ImageDir)
+	if err == nil {
+		dir, err = filepath.EvalSymlinks(dir)
+	}
+	if err != nil {
+		return fmt.Errorf("image directory is unavailable: %w", err)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return fmt.Errorf("image_path '%s' is not a readable file in the image directory", raw)
+	}
+	if rel, err := filepath.Rel(dir, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("image_path '%s' is outside the image directory", raw)
+	}
+	params["image_path"] = resolved
+	return nil
+}
+
+func modelInfo(ctx context.Context, p plugin.ModelPlugin) ModelInfo {
+	info := ModelInfo{
+		Name:         p.Name(),
+		Type:         p.Type(),
+		Capabilities: p.Capabilities(),
+		Health:       p.Health(ctx),
+	}
+	if l, ok := p.(plugin.LifecycleReporter); ok {
+		status := l.Lifecycle()
+		info.Lifecycle = &status
+	}
+	if d, ok := p.(plugin.Describer); ok {
+		info.Details = d.Describe()
+	}
+	return info
+}
+
+// writeInvokeError maps errors from model plugins and their workers to HTTP responses.
+func writeInvokeError(w http.ResponseWriter, err error) {
+	status, code, message := classifyError(err)
+	if status == http.StatusTooManyRequests {
+		w.Header().Set("Retry-After", "1")
+	}
+	writeError(w, status, code, message)
+}
+
+// classifyError maps an error from a model plugin or its worker to a status code and a
+// stable error code.
+func classifyError(err error) (int, string, string) {
+	var remoteErr *worker.RemoteError
+	switch {
+	case errors.Is(err, errModelNotFound):
+		return http.StatusNotFound, "model_not_found", err.Error()
+	case errors.Is(err, plugin.ErrInvalidRequest):
+		return http.StatusBadRequest, "invalid_request", err.Error()
+	case errors.Is(err, plugin.ErrUnsupportedCapability):
+		return http.StatusBadRequest, "unsupported_capability", err.Error()
+	case errors.Is(err, plugin.ErrNotLoaded):
+		return http.StatusServiceUnavailable, "model_not_loaded", err.Error()
+	case errors.Is(err, memory.ErrInsufficientMemory):
+		return http.StatusServiceUnavailable, "insufficient_memory", err.Error()
+	case errors.Is(err, spawn.ErrSessionBusy):
+		return http.StatusTooManyRequests, "model_busy", err.Error()
+	case errors.Is(err, scheduler.ErrQueueFull):
+		return http.StatusTooManyRequests, "queue_full", err.Error()
+	case errors.Is(err, scheduler.ErrClosed):
+		return http.StatusServiceUnavailable, "shutting_down", err.Error()
+	case errors.Is(err, scheduler.ErrMissingValue):
+		// A pipeline step refers to input the request didn't give, or output a step didn't return.
+		return http.StatusUnprocessableEntity, "missing_input", err.Error()
+	case errors.Is(err, scheduler.ErrStepSkipped):
+		return http.StatusFailedDependency, "step_skipped", err.Error()
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "timeout", err.Error()
+	case errors.Is(err, context.Canceled):
+		// The client went away; nobody is listening for the body.
+		return http.StatusServiceUnavailable, "cancelled", err.Error()
+	case errors.Is(err, worker.ErrWorkerExited), errors.Is(err, spawn.ErrSessionExited):
+		return http.StatusBadGateway, "worker_exited", err.Error()
+	case errors.As(err, &remoteErr):
+		// Errors raised by the Python script. Bad inputs surface as these exception types.
+		switch remoteErr.Type {
+		case "FileNotFoundError", "ValueError", "KeyError", "TypeError", "UnidentifiedImageError":
+			return http.StatusUnprocessableEntity, "model_input_error", remoteErr.Message
+		default:
+			return http.StatusInternalServerError, "model_error", remoteErr.Error()
+		}
+	default:
+		return http.StatusInternalServerError, "internal_error", err.Error()
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, APIError{Error: APIErrorDetail{Code: code, Message: message}})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Permission is an area of the API a key may use.
+type Permission string
+
+const (
+	PermissionInference Permission = "inference" // The inference API and the OpenAI-compatible API
+	PermissionShell     Permission = "shell"     // Interactive shells on the server
+)
+
+// APIKey is a secret that grants a set of permissions. Its name identifies the caller,
+// e.g. as the owner of the shells it starts.
+type APIKey struct {
+	Name        string
+	Key         string
+	Permissions []Permission
+}
+
+// AuthOptions configures Auth.
+type AuthOptions struct {
+	Keys []APIKey
+	// Unauthenticated is granted to every request, with or without a key, as long as no
+	// keys are configured. It keeps a server without keys usable on a trusted machine.
+	Unauthenticated []Permission
+}
+
+// Auth checks the API key sent with a request. Keys are read from the Authorization
+// header ("Bearer <key>"), the X-API-Key header or, because browsers can't set headers
+// on WebSocket and EventSource requests, the access_token query parameter.
+type Auth struct {
+	keys            []hashedKey
+	unauthenticated []Permission
+}
+
+type hashedKey struct {
+	name        string
+	sum         [sha256.Size]byte
+	permissions []Permission
+}
+
+type callerKey struct{}
+
+// NewAuth creates an authenticator for a set of keys.
+func NewAuth(opts AuthOptions) *Auth {
+	a := &Auth{}
+	for _, k := range opts.Keys {
+		a.keys = append(a.keys, hashedKey{name: k.Name, sum: sha256.Sum256([]byte(k.Key)), permissions: k.Permissions})
+	}
+	if len(a.keys) == 0 {
+		a.unauthenticated = opts.Unauthenticated
+	}
+	return a
+}
+
+// Require wraps a handler so it only serves requests whose key grants permission.
+// Requests without a valid key get 401, those whose key lacks the permission 403.
+func (a *Auth) Require(permission Permission, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(a.unauthenticated, permission) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(a.keys) == 0 {
+			writeError(w, http.StatusForbidden, "forbidden", "the "+string(permission)+" API is disabled until API keys are configured")
+			return
+		}
+		key, ok := a.lookup(requestKey(r))
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="llm-cortex"`)
+			writeError(w, http.StatusUnauthorized, "unauthorized", "a valid API key is required")
+			return
+		}
+		if !slices.Contains(key.permissions, permission) {
+			writeError(w, http.StatusForbidden, "forbidden", "API key '"+key.name+"' does not grant the "+string(permission)+" permission")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), callerKey{}, key.name)))
+	})
+}
+
+// lookup finds the key matching secret. Every key is compared, in constant time, so the
+// response time doesn't tell how close a guess was.
+func (a *Auth) lookup(secret string) (hashedKey, bool) {
+	if secret == "" {
+		return hashedKey{}, false
+	}
+	sum := sha256.Sum256([]byte(secret))
+	var found hashedKey
+	ok := false
+	for _, k := range a.keys {
+		if subtle.ConstantTimeCompare(sum[:], k.sum[:]) == 1 {
+			found, ok = k, true
+		}
+	}
+	return found, ok
+}
+
+func requestKey(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(auth[len("Bearer "):])
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// Caller returns the name of the API key a request was authenticated with. It is empty
+// for requests let through without a key.
+func Caller(r *http.Request) string {
+	name, _ := r.Context().Value(callerKey{}).(string)
+	return name
+}
@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	uuid "github.com/google/uuid"
+	"github.com/owen-6936/llm-cortex/core/models/llm"
+	"github.com/owen-6936/llm-cortex/core/plugin"
+)
+
+// OpenAI serves an OpenAI-compatible facade (/v1/models, /v1/completions and
+// /v1/chat/completions) over the configured GGUF models, so existing SDKs and editor
+// integrations can talk to them directly. Model names are the names from config.yaml.
+type OpenAI struct {
+	models ModelRegistry
+}
+
+// NewOpenAI creates the OpenAI-compatible API on top of a model registry.
+func NewOpenAI(models ModelRegistry) *OpenAI {
+	return &OpenAI{models: models}
+}
+
+// Register adds the OpenAI-compatible routes to a mux.
+func (o *OpenAI) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v1/models", o.ListModelsHandler)
+	mux.HandleFunc("POST /v1/completions", o.CompletionsHandler)
+	mux.HandleFunc("POST /v1/chat/completions", o.ChatCompletionsHandler)
+}
+
+// stringOrList accepts either a JSON string or an array of strings, as the OpenAI API does
+// for "prompt" and "stop".
+type stringOrList []string
+
+func (s *stringOrList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = stringOrList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("expected a string or an array of strings")
+	}
+	*s = list
+	return nil
+}
+
+// samplingParams are the generation options shared by both completion endpoints.
+// TopK, MinP and RepeatPenalty are llama.cpp extensions to the OpenAI parameters.
+type samplingParams struct {
+	Model         string       `json:"model"`
+	MaxTokens     int          `json:"max_tokens"`
+	Temperature   *float64     `json:"temperature"`
+	TopP          *float64     `json:"top_p"`
+	TopK          *int         `json:"top_k"`
+	MinP          *float64     `json:"min_p"`
+	RepeatPenalty *float64     `json:"repeat_penalty"`
+	Seed          *int         `json:"seed"`
+	Stop          stringOrList `json:"stop"`
+	Stream        bool         `json:"stream"`
+}
+
+type completionRequest struct {
+	samplingParams
+	Prompt stringOrList `json:"prompt"`
+}
+
+// ChatMessage is a single message of an OpenAI chat completion request or response.
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatCompletionRequest struct {
+	samplingParams
+	Messages []ChatMessage `json:"messages"`
+}
+
+type openAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type openAIError struct {
+	Error openAIErrorDetail `json:"error"`
+}
+
+type openAIErrorDetail struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Param   *string `json:"param"`
+	Code    string  `json:"code"`
+}
+
+// ListModelsHandler lists the configured models that can generate text.
+func (o *OpenAI) ListModelsHandler(w http.ResponseWriter, r *http.Request) {
+	type model struct {
+		ID      string `json:"id"`
+		Object  string `json:"object"`
+		Created int64  `json:"created"`
+		OwnedBy string `json:"owned_by"`
+	}
+	data := []model{}
+	for _, p := range o.models.Models() {
+		if plugin.Supports(p, plugin.CapabilityCompletion) {
+			data = append(data, model{ID: p.Name(), Object: "model", OwnedBy: "llm-cortex"})
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": data})
+}
+
+// CompletionsHandler implements POST /v1/completions.
+func (o *OpenAI) CompletionsHandler(w http.ResponseWriter, r *http.Request) {
+	var req completionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "invalid_json", err.Error())
+		return
+	}
+	if len(req.Prompt) != 1 {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "invalid_prompt", "exactly one prompt is supported per request")
+		return
+	}
+	o.complete(w, r, req.samplingParams, plugin.CapabilityCompletion, "prompt", req.Prompt[0])
+}
+
+// ChatCompletionsHandler implements POST /v1/chat/completions.
+func (o *OpenAI) ChatCompletionsHandler(w http.ResponseWriter, r *http.Request) {
+	var req chatCompletionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "invalid_json", err.Error())
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "invalid_messages", "messages must not be empty")
+		return
+	}
+	o.complete(w, r, req.samplingParams, plugin.CapabilityChat, "messages", req.Messages)
+}
+
+// complete runs a completion or chat request and writes it either as a single JSON
+// response or as an SSE stream of chunks terminated by "data: [DONE]". The input is the
+// prompt or the messages, passed to the plugin under inputKey.
+func (o *OpenAI) complete(w http.ResponseWriter, r *http.Request, params samplingParams, capability plugin.Capability, inputKey string, input interface{}) {
+	chat := capability == plugin.CapabilityChat
+	p, ok := o.models.Model(params.Model)
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "model_not_found", fmt.Sprintf("The model '%s' does not exist", params.Model))
+		return
+	}
+	if !plugin.Supports(p, capability) {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "model_not_supported", fmt.Sprintf("The model '%s' does not support %s", params.Model, capability))
+		return
+	}
+
+	id := "cmpl-" + uuid.New().String()
+	created := time.Now().Unix()
+	req := plugin.Request{
+		Capability: capability,
+		Params: map[string]interface{}{
+			inputKey:         input,
+			"max_tokens":     params.MaxTokens,
+			"temperature":    params.Temperature,
+			"top_p":          params.TopP,
+			"top_k":          params.TopK,
+			"min_p":          params.MinP,
+			"repeat_penalty": params.RepeatPenalty,
+			"seed":           params.Seed,
+			"stop":           []string(params.Stop),
+		},
+	}
+	var stream *openAIStream
+	if params.Stream {
+		stream = &openAIStream{w: w, id: id, created: created, model: p.Name(), chat: chat}
+		req.OnChunk = stream.Text
+	}
+
+	ctx, err := requestContext(r)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "invalid_priority", err.Error())
+		return
+	}
+	result, err := p.Invoke(ctx, req)
+	if err != nil {
+		log.Printf("OpenAI request to model '%s' failed: %v", p.Name(), err)
+		status, code, message := classifyError(err)
+		if stream != nil && stream.started() {
+			stream.Fail(openAIErrorType(status), code, message)
+			return
+		}
+		writeOpenAIError(w, status, openAIErrorType(status), code, message)
+		return
+	}
+	completion, ok := result.Output.(*llm.Completion)
+	if !ok {
+		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "unexpected_output", fmt.Sprintf("model '%s' returned %T", p.Name(), result.Output))
+		return
+	}
+
+	if stream != nil {
+		stream.Finish(completion.FinishReason)
+		return
+	}
+
+	usage := openAIUsage{
+		PromptTokens:     completion.PromptTokens,
+		CompletionTokens: completion.CompletionTokens,
+		TotalTokens:      completion.PromptTokens + completion.CompletionTokens,
+	}
+	if chat {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"id": "chat" + id, "object": "chat.completion", "created": created, "model": p.Name(),
+			"choices": []map[string]interface{}{{
+				"index":         0,
+				"message":       ChatMessage{Role: "assistant", Content: completion.Text},
+				"finish_reason": completion.FinishReason,
+			}},
+			"usage": usage,
+		})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id": id, "object": "text_completion", "created": created, "model": p.Name(),
+		"choices": []map[string]interface{}{{
+			"index":         0,
+			"text":          completion.Text,
+			"logprobs":      nil,
+			"finish_reason": completion.FinishReason,
+		}},
+		"usage": usage,
+	})
+}
+
+// openAIStream writes a completion in the OpenAI streaming format as it is generated.
+// The SSE response is only started with the first chunk, so errors that occur before
+// any output still get a regular JSON error response with the right status code.
+type openAIStream struct {
+	w       http.ResponseWriter
+	id      string
+	created int64
+	model   string
+	chat    bool
+
+	sse *sseWriter
+	err error // Set once the stream can't be written, e.g. after the client left
+}
+
+func (s *openAIStream) started() bool {
+	return s.sse != nil || s.err != nil
+}
+
+// Text sends a chunk of generated text.
+func (s *openAIStream) Text(text string) {
+	if text != "" {
+		s.send(s.chunk(text, nil))
+	}
+}
+
+// Finish sends the final chunk and the [DONE] marker.
+func (s *openAIStream) Finish(finishReason string) {
+	s.send(s.chunk("", finishReason))
+	if s.err == nil {
+		s.sse.Event("", "", "[DONE]")
+	}
+}
+
+// Fail reports an error that happened after the stream started, which is the only way
+// left to tell the client the completion is incomplete.
+func (s *openAIStream) Fail(errType, code, message string) {
+	s.send(openAIError{Error: openAIErrorDetail{Message: message, Type: errType, Code: code}})
+}
+
+func (s *openAIStream) send(v interface{}) {
+	if s.err != nil {
+		return
+	}
+	if s.sse == nil {
+		if s.sse, s.err = newSSEWriter(s.w); s.err != nil {
+			writeOpenAIError(s.w, http.StatusInternalServerError, "server_error", "streaming_unsupported", s.err.Error())
+			return
+		}
+		if s.chat {
+			first := s.chunk("", nil)
+			first["choices"].([]map[string]interface{})[0]["delta"] = map[string]string{"role": "assistant"}
+			s.write(first)
+		}
+	}
+	s.write(v)
+}
+
+func (s *openAIStream) write(v interface{}) {
+	data, _ := json.Marshal(v)
+	if s.err == nil {
+		s.err = s.sse.Event("", "", string(data))
+	}
+}
+
+func (s *openAIStream) chunk(text string, finishReason interface{}) map[string]interface{} {
+	if s.chat {
+		delta := map[string]string{}
+		if text != "" {
+			delta["content"] = text
+		}
+		return map[string]interface{}{
+			"id": "chat" + s.id, "object": "chat.completion.chunk", "created": s.created, "model": s.model,
+			"choices": []map[string]interface{}{{"index": 0, "delta": delta, "finish_reason": finishReason}},
+		}
+	}
+	return map[string]interface{}{
+		"id": s.id, "object": "text_completion", "created": s.created, "model": s.model,
+		"choices": []map[string]interface{}{{"index": 0, "text": text, "logprobs": nil, "finish_reason": finishReason}},
+	}
+}
+
+// openAIErrorType picks the OpenAI error type for a status code.
+func openAIErrorType(status int) string {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= 500:
+		return "server_error"
+	default:
+		return "invalid_request_error"
+	}
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, errType, code, message string) {
+	writeJSON(w, status, openAIError{Error: openAIErrorDetail{Message: message, Type: errType, Code: code}})
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/owen-6936/llm-cortex/scheduler"
+)
+
+// PipelineRunner is implemented by registries that run pipelines of model calls.
+type PipelineRunner interface {
+	// Pipelines lists every configured pipeline.
+	Pipelines() []*scheduler.Pipeline
+	// RunPipeline runs a pipeline by name on an input.
+	RunPipeline(ctx context.Context, name string, input map[string]interface{}) (*scheduler.PipelineResult, error)
+}
+
+// PipelineInfo describes a configured pipeline in API responses.
+type PipelineInfo struct {
+	Name   string             `json:"name"`
+	Output string             `json:"output"` // The step whose output is the pipeline's
+	Steps  []PipelineStepInfo `json:"steps"`
+}
+
+// PipelineStepInfo describes one step of a pipeline.
+type PipelineStepInfo struct {
+	Name       string   `json:"name"`
+	Model      string   `json:"model"`
+	Capability string   `json:"capability"`
+	DependsOn  []string `json:"depends_on"`
+	Optional   bool     `json:"optional,omitempty"`
+}
+
+// PipelineResponse is the body returned by a pipeline run. A run whose required steps
+// all succeeded is a 200, with status "ok", or "partial" if optional steps failed; one
+// that failed has the status code and error of the first required step that failed.
+type PipelineResponse struct {
+	Pipeline  string               `json:"pipeline"`
+	Status    string               `json:"status"`
+	Output    interface{}          `json:"output"`
+	Steps     []PipelineStepResult `json:"steps"`
+	LatencyMs float64              `json:"latency_ms"`
+	Error     *APIErrorDetail      `json:"error,omitempty"`
+}
+
+// PipelineStepResult is the outcome and timing of one step of a pipeline run.
+type PipelineStepResult struct {
+	Name      string          `json:"name"`
+	Model     string          `json:"model"`
+	Status    string          `json:"status"` // ok, failed or skipped
+	Output    interface{}     `json:"output,omitempty"`
+	Error     *APIErrorDetail `json:"error,omitempty"`
+	StartMs   float64         `json:"start_ms"`  // When the step's inputs were ready, from the start of the run
+	QueuedMs  float64         `json:"queued_ms"` // Time spent waiting for a worker
+	LatencyMs float64         `json:"latency_ms"`
+}
+
+// ListPipelinesHandler returns every pipeline from config.yaml with its steps.
+func (a *API) ListPipelinesHandler(w http.ResponseWriter, r *http.Request) {
+	runner, ok := a.models.(PipelineRunner)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "pipelines are not supported")
+		return
+	}
+	infos := []PipelineInfo{}
+	for _, p := range runner.Pipelines() {
+		info := PipelineInfo{Name: p.Name, Output: p.Output, Steps: []PipelineStepInfo{}}
+		if info.Output == "" {
+			info.Output = p.Steps[len(p.Steps)-1].Name
+		}
+		for _, step := range p.Steps {
+			info.Steps = append(info.Steps, PipelineStepInfo{
+				Name:       step.Name,
+				Model:      step.Model,
+				Capability: step.Capability,
+				DependsOn:  p.DependsOn(step.Name),
+				Optional:   step.Optional,
+			})
+		}
+		infos = append(infos, info)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"pipelines": infos})
+}
+
+// RunPipelineHandler runs a named pipeline. The body is a JSON object that steps refer
+// to as ${input.key}; "image_path" and "image_base64" are handled as for the vision
+// endpoints, and the resulting file is passed on as "image_path".
+func (a *API) RunPipelineHandler(w http.ResponseWriter, r *http.Request) {
+	runner, ok := a.models.(PipelineRunner)
+	if !ok {
+		writeError(w, http.StatusNotFound, "not_found", "pipelines are not supported")
+		return
+	}
+	name := r.PathValue("name")
+	found := false
+	for _, p := range runner.Pipelines() {
+		found = found || p.Name == name
+	}
+	if !found {
+		writeError(w, http.StatusNotFound, "pipeline_not_found", fmt.Sprintf("pipeline '%s' is not configured", name))
+		return
+	}
+
+	var input map[string]interface{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBody)).Decode(&input); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", "request body must be a JSON object: "+err.Error())
+		return
+	}
+	if input == nil {
+		input = map[string]interface{}{}
+	}
+	if err := a.checkImagePath(input); err != nil {
+		writeError(w, http.StatusForbidden, "image_path_forbidden", err.Error())
+		return
+	}
+	cleanup, err := materializeImage(input)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	defer cleanup()
+
+	ctx, err := requestContext(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	result, err := runner.RunPipeline(ctx, name, input)
+	if result == nil {
+		log.Printf("API pipeline '%s' failed: %v", name, err)
+		writeInvokeError(w, err)
+		return
+	}
+
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	response := PipelineResponse{
+		Pipeline:  result.Pipeline,
+		Status:    result.Status,
+		Output:    result.Output,
+		Steps:     make([]PipelineStepResult, 0, len(result.Steps)),
+		LatencyMs: ms(result.Duration),
+	}
+	for _, step := range result.Steps {
+		stepResult := PipelineStepResult{
+			Name:      step.Name,
+			Model:     step.Model,
+			Status:    step.Status,
+			Output:    step.Output,
+			StartMs:   ms(step.Start),
+			QueuedMs:  ms(step.Queued),
+			LatencyMs: ms(step.Duration),
+		}
+		if step.Err != nil {
+			_, code, message := classifyError(step.Err)
+			stepResult.Error = &APIErrorDetail{Code: code, Message: message}
+		}
+		response.Steps = append(response.Steps, stepResult)
+	}
+	status := http.StatusOK
+	if err != nil {
+		log.Printf("API pipeline '%s' failed: %v", name, err)
+		var code, message string
+		status, code, message = classifyError(err)
+		response.Error = &APIErrorDetail{Code: code, Message: message}
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+	}
+	writeJSON(w, status, response)
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/owen-6936/llm-cortex/spawn"
+)
+
+// recordingFromPath returns the ID in /shell/recordings/{id}[/...].
+func recordingFromPath(path string) string {
+	parts := strings.Split(path, "/")
+	if len(parts) >= 4 {
+		return parts[3]
+	}
+	return ""
+}
+
+// canSee reports whether the caller may see a recording: their own shells, and
+// sessions nobody owns, such as model workers recorded into the same directory.
+func canSee(r *http.Request, labels map[string]string) bool {
+	owner := labels[spawn.LabelOwner]
+	return owner == "" || owner == Caller(r)
+}
+
+// openRecording opens the recording a request is for, provided the caller may see it.
+// Otherwise it responds with 404.
+func (s *Shells) openRecording(w http.ResponseWriter, r *http.Request) (*os.File, spawn.CastHeader, bool) {
+	if s.opts.RecordingDir == "" {
+		http.Error(w, "Shell recording is not enabled", http.StatusNotFound)
+		return nil, spawn.CastHeader{}, false
+	}
+	f, header, err := spawn.OpenRecording(s.opts.RecordingDir, recordingFromPath(r.URL.Path))
+	if err == nil && !canSee(r, header.Labels) {
+		f.Close()
+		err = spawn.ErrRecordingNotFound
+	}
+	if err != nil {
+		if !errors.Is(err, spawn.ErrRecordingNotFound) {
+			log.Printf("Failed to open recording: %v", err)
+		}
+		http.Error(w, "Recording not found", http.StatusNotFound)
+		return nil, header, false
+	}
+	return f, header, true
+}
+
+// ListRecordingsHandler lists the recordings the caller may see, newest first.
+func (s *Shells) ListRecordingsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	if s.opts.RecordingDir == "" {
+		http.Error(w, "Shell recording is not enabled", http.StatusNotFound)
+		return
+	}
+	all, err := spawn.ListRecordings(s.opts.RecordingDir)
+	if err != nil {
+		log.Printf("Failed to list recordings: %v", err)
+		http.Error(w, "Failed to list recordings", http.StatusInternalServerError)
+		return
+	}
+	recordings := make([]spawn.Recording, 0, len(all))
+	for _, rec := range all {
+		if canSee(r, rec.Labels) {
+			recordings = append(recordings, rec)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"recordings": recordings})
+}
+
+// DownloadRecordingHandler sends a recording as an asciicast v2 file, which
+// `asciinema play` and the asciinema web player can replay.
+func (s *Shells) DownloadRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	f, _, ok := s.openRecording(w, r)
+	if !ok {
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Failed to read recording", http.StatusInternalServerError)
+		return
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		http.Error(w, "Failed to read recording", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-asciicast")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", info.Name()))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// ReplayRecordingHandler plays a recording back as Server-Sent Events, with the pauses
+// between events as they were recorded. It sends a "header" event with the recording's
+// header, then "output", "input" and "resize" events, and finally "end". Output and
+// input data is a JSON string; resize data is {"cols": ..., "rows": ...}.
+//
+// ?speed= plays faster or slower than recorded, and ?idle_limit= caps every pause at
+// that many seconds. Each event's ID is its number in the recording, so a client that
+// reconnects with Last-Event-ID carries on where it left off.
+func (s *Shells) ReplayRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	speed, idleLimit := 1.0, 0.0
+	if v := query.Get("speed"); v != "" {
+		var err error
+		if speed, err = strconv.ParseFloat(v, 64); err != nil || speed <= 0 {
+			http.Error(w, "speed must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := query.Get("idle_limit"); v != "" {
+		var err error
+		if idleLimit, err = strconv.ParseFloat(v, 64); err != nil || idleLimit < 0 {
+			http.Error(w, "idle_limit must be a number of seconds", http.StatusBadRequest)
+			return
+		}
+	}
+	resumeAfter := -1
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		var err error
+		if resumeAfter, err = strconv.Atoi(v); err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+	}
+
+	f, header, ok := s.openRecording(w, r)
+	if !ok {
+		return
+	}
+	defer f.Close()
+	sse, err := newSSEWriter(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data, _ := json.Marshal(header)
+	if sse.Event("header", "", string(data)) != nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), wsMaxMessage)
+	var last float64 // Time of the previous event
+	for n := 0; scanner.Scan(); n++ {
+		var event spawn.CastEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			break // A recording cut short mid-line
+		}
+		pause := event.Time - last
+		last = event.Time
+		if n <= resumeAfter {
+			continue
+		}
+		if idleLimit > 0 {
+			pause = min(pause, idleLimit)
+		}
+		if pause > 0 {
+			select {
+			case <-time.After(time.Duration(pause / speed * float64(time.Second))):
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		var name string
+		switch event.Type {
+		case spawn.CastOutput:
+			name = "output"
+			data, _ = json.Marshal(event.Data)
+		case spawn.CastInput:
+			name = "input"
+			data, _ = json.Marshal(event.Data)
+		case spawn.CastResize:
+			var cols, rows int
+			if _, err := fmt.Sscanf(event.Data, "%dx%d", &cols, &rows); err != nil {
+				continue
+			}
+			name = "resize"
+			data, _ = json.Marshal(map[string]int{"cols": cols, "rows": rows})
+		default:
+			continue // Markers and anything newer
+		}
+		if sse.Event(name, strconv.Itoa(n), string(data)) != nil {
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Printf("Failed to read recording %s: %v", f.Name(), err)
+	}
+	sse.Event("end", "", "{}")
+}
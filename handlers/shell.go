@@ -2,19 +2,82 @@ package handlers
 
 import (
 	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/owen-6936/llm-cortex/spawn"
 )
 
-var mu sync.Mutex
+// Shells serves the interactive shell endpoints under /shell. Its sessions live in a
+// spawn.Manager of their own, so the API can never reach a model's worker process.
+// Shells are owned by the API key that started them and can't be seen with any other.
+type Shells struct {
+	sessions *spawn.Manager
+	opts     ShellOptions
+	startMu  sync.Mutex // Serializes starts, so concurrent ones can't exceed the limits
+	ends     shellEnds
+}
+
+// ShellOptions restricts what shells started through the API can do.
+type ShellOptions struct {
+	Policy  *CommandPolicy // Commands SendCommandHandler accepts; nil accepts all
+	Sandbox spawn.Sandbox  // Where shells run
+	// RecordingDir is where every shell is recorded in asciicast format, and where the
+	// recording endpoints look. Empty disables recording.
+	RecordingDir string
+
+	MaxSessions          int           // Shells running at once; 0 is unlimited
+	MaxSessionsPerClient int           // Shells running at once per API key; 0 is unlimited
+	IdleTimeout          time.Duration // Close shells without input or output for this long; 0 never does
+	MaxLifetime          time.Duration // Close shells open for this long; 0 never does
+}
+
+// NewShells creates the shell endpoints on top of a session manager.
+func NewShells(sessions *spawn.Manager, opts ShellOptions) *Shells {
+	return &Shells{sessions: sessions, opts: opts}
+}
 
-// StartShellHandler spawns a new shell and returns its session ID
-func StartShellHandler(w http.ResponseWriter, r *http.Request) {
-	sessionID, err := spawn.NewShell()
+// session finds the shell a request is for, provided the caller owns it. Otherwise it
+// responds with 404, so other callers can't even tell the shell exists.
+func (s *Shells) session(w http.ResponseWriter, r *http.Request) (*spawn.ShellSession, bool) {
+	session, ok := s.sessions.GetSession(extractID(r.URL.Path))
+	if !ok || session.Labels[spawn.LabelOwner] != Caller(r) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return nil, false
+	}
+	return session, true
+}
+
+// StartShellHandler spawns a new shell and returns its session ID. With ?pty=true the
+// shell runs on a pseudo-terminal, for use through ShellSocketHandler.
+func (s *Shells) StartShellHandler(w http.ResponseWriter, r *http.Request) {
+	opts := spawn.Options{
+		Labels:    map[string]string{spawn.LabelKind: spawn.KindShell},
+		Sandbox:   s.opts.Sandbox,
+		Recording: spawn.RecordingOptions{Dir: s.opts.RecordingDir},
+	}
+	if caller := Caller(r); caller != "" {
+		opts.Labels[spawn.LabelOwner] = caller
+	}
+	if usePTY, _ := strconv.ParseBool(r.URL.Query().Get("pty")); usePTY {
+		opts.PTY = true
+		opts.Env = []string{"TERM=xterm-256color"}
+	}
+	s.startMu.Lock()
+	if err := s.checkLimits(Caller(r)); err != nil {
+		s.startMu.Unlock()
+		writeError(w, http.StatusTooManyRequests, "too_many_shells", err.Error())
+		return
+	}
+	sessionID, err := s.sessions.NewShellWithOptions(opts, "bash", "-i")
+	s.startMu.Unlock()
 	if err != nil {
+		log.Printf("Failed to start shell: %v", err)
 		http.Error(w, "Failed to start shell", http.StatusInternalServerError)
 		return
 	}
@@ -22,26 +85,20 @@ func StartShellHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(map[string]string{"id": sessionID})
 
 	// The output handler now needs the session object, which we get from the spawn manager
-	spawn.StartReading(sessionID, spawn.OutputHandler, spawn.ErrorOutputHandler)
+	s.sessions.StartReading(sessionID, spawn.OutputHandler, spawn.ErrorOutputHandler)
 
 }
 
 // SendCommandHandler sends a command to a shell session
-func SendCommandHandler(w http.ResponseWriter, r *http.Request) {
-	id := extractID(r.URL.Path)
-	if id == "" {
+func (s *Shells) SendCommandHandler(w http.ResponseWriter, r *http.Request) {
+	if extractID(r.URL.Path) == "" {
 		http.Error(w, "Missing session ID", http.StatusBadRequest)
 		return
 	}
-	session, ok := spawn.GetSession(id)
+	session, ok := s.session(w, r)
 	if !ok {
-		http.Error(w, "Session not found", http.StatusNotFound)
 		return
 	}
-	// reset the output string after each command execution
-	mu.Lock()
-	session.OutputBuf.Reset()
-	mu.Unlock()
 
 	var payload struct {
 		Command string `json:"command"`
@@ -50,8 +107,16 @@ func SendCommandHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid command payload", http.StatusBadRequest)
 		return
 	}
+	if err := s.opts.Policy.Check(payload.Command); err != nil {
+		log.Printf("Refused command for shell %s: %v", session.ID, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 
-	if err := spawn.SendCommand(id, payload.Command); err != nil {
+	// /stream shows the output of the latest command. Nothing is discarded, so
+	// /stream?since= and /events still see everything.
+	session.ResetOutput()
+	if err := s.sessions.SendCommand(session.ID, payload.Command); err != nil {
 		http.Error(w, "Failed to send command", http.StatusInternalServerError)
 		return
 	}
@@ -59,27 +124,42 @@ func SendCommandHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// StreamOutputHandler returns the latest output from a shell session
-func StreamOutputHandler(w http.ResponseWriter, r *http.Request) {
-	id := extractID(r.URL.Path)
-	session, ok := spawn.GetSession(id)
+// StreamOutputHandler returns the latest output from a shell session. With ?since=<offset>
+// it returns everything still buffered from that offset on instead; X-Output-Offset holds
+// the offset to pass next time, and X-Output-Start where the data begins, which is past
+// since if older output has already been dropped.
+func (s *Shells) StreamOutputHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.session(w, r)
 	if !ok {
-		http.Error(w, "Session not found", http.StatusNotFound)
 		return
 	}
-	w.Header().Set("Content-type", "plain/text")
-	// Inside StreamOutputHandler:
-	// CRITICAL FIX: Lock before reading shared state
-	mu.Lock()
-	output := session.OutputBuf.Bytes()
-	mu.Unlock()
-	w.Write(output)
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(session.Output())
+		return
+	}
+	offset, err := strconv.ParseInt(since, 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "since must be a non-negative offset", http.StatusBadRequest)
+		return
+	}
+	data, start := session.OutputSince(spawn.StreamStdout, offset)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Output-Start", strconv.FormatInt(start, 10))
+	w.Header().Set("X-Output-Offset", strconv.FormatInt(start+int64(len(data)), 10))
+	w.Write(data)
 }
 
 // CloseShellHandler gracefully shuts down a shell session
-func CloseShellHandler(w http.ResponseWriter, r *http.Request) {
-	id := extractID(r.URL.Path)
-	if err := spawn.CloseSession(id); err != nil {
+func (s *Shells) CloseShellHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.session(w, r)
+	if !ok {
+		return
+	}
+	// A shell that exits with a status or on SIGHUP, as shells on a terminal do, has
+	// still been closed.
+	if err := s.end(session, EndClosed); err != nil {
 		http.Error(w, "Failed to close session", http.StatusInternalServerError)
 		return
 	}
@@ -87,6 +167,269 @@ func CloseShellHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
+// shellEventsKeepAlive is how often an idle event stream sends a comment.
+const shellEventsKeepAlive = 15 * time.Second
+
+// ShellEventsHandler streams a shell's output as Server-Sent Events. "stdout" and
+// "stderr" events carry the output as a JSON string, and an "exit" event with the exit
+// status and the reason the shell ended (see EndClosed) ends the stream. Each event's ID
+// is a cursor of the form "<stdout>-<stderr>" offsets; a client that reconnects with
+// Last-Event-ID, or passes ?cursor=, resumes right after it. Without a cursor the stream
+// starts with all the output still buffered. If output the client hasn't seen has
+// already been dropped, a "gap" event says how much.
+func (s *Shells) ShellEventsHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := s.session(w, r)
+	if !ok {
+		return
+	}
+	id := session.ID
+	cursor := r.Header.Get("Last-Event-ID")
+	if cursor == "" {
+		cursor = r.URL.Query().Get("cursor")
+	}
+	var offsets [2]int64
+	if cursor != "" {
+		var err error
+		if offsets, err = parseEventCursor(cursor); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	type follower struct {
+		name    string
+		sub     *spawn.Subscription
+		dropped int64 // Output from the cursor on that is no longer buffered
+	}
+	followers := []*follower{{name: "stdout"}, {name: "stderr"}}
+	for i, stream := range []spawn.Stream{spawn.StreamStdout, spawn.StreamStderr} {
+		if stream == spawn.StreamStderr && session.Stderr == nil {
+			continue // On a terminal, stderr arrives with stdout.
+		}
+		sub, start, err := s.sessions.Follow(id, stream, offsets[i])
+		if err != nil {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		defer sub.Close()
+		followers[i].sub = sub
+		followers[i].dropped = start - offsets[i]
+		offsets[i] = start
+	}
+
+	sse, err := newSSEWriter(w)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, f := range followers {
+		if f.dropped > 0 {
+			data, _ := json.Marshal(map[string]interface{}{"stream": f.name, "bytes": f.dropped})
+			if sse.Event("gap", formatEventCursor(offsets), string(data)) != nil {
+				return
+			}
+		}
+	}
+
+	keepAlive := time.NewTicker(shellEventsKeepAlive)
+	defer keepAlive.Stop()
+	for followers[0].sub != nil || followers[1].sub != nil {
+		var stdoutReady, stderrReady <-chan struct{}
+		if followers[0].sub != nil {
+			stdoutReady = followers[0].sub.Ready()
+		}
+		if followers[1].sub != nil {
+			stderrReady = followers[1].sub.Ready()
+		}
+		var i int
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if sse.Comment("keep-alive") != nil {
+				return
+			}
+			continue
+		case <-stdoutReady:
+			i = 0
+		case <-stderrReady:
+			i = 1
+		}
+		f := followers[i]
+		data, open := f.sub.Next()
+		if len(data) > 0 {
+			offsets[i] += int64(len(data))
+			text, _ := json.Marshal(string(data))
+			if sse.Event(f.name, formatEventCursor(offsets), string(text)) != nil {
+				return
+			}
+		}
+		if !open {
+			f.sub = nil
+		}
+	}
+
+	// The output has ended; report how the process exited once the reaper has seen it.
+	select {
+	case <-session.Done():
+	case <-r.Context().Done():
+		return
+	}
+	exit := session.Exit()
+	data, _ := json.Marshal(map[string]interface{}{"code": exit.Code, "signal": exit.Signal, "reason": s.ends.reason(id)})
+	sse.Event("exit", formatEventCursor(offsets), string(data))
+}
+
+// parseEventCursor reads the stdout and stderr offsets from a shell event ID.
+func parseEventCursor(cursor string) ([2]int64, error) {
+	var offsets [2]int64
+	stdout, stderr, ok := strings.Cut(cursor, "-")
+	if !ok {
+		return offsets, fmt.Errorf("invalid event cursor %q", cursor)
+	}
+	for i, part := range []string{stdout, stderr} {
+		offset, err := strconv.ParseInt(part, 10, 64)
+		if err != nil || offset < 0 {
+			return offsets, fmt.Errorf("invalid event cursor %q", cursor)
+		}
+		offsets[i] = offset
+	}
+	return offsets, nil
+}
+
+func formatEventCursor(offsets [2]int64) string {
+	return fmt.Sprintf("%d-%d", offsets[0], offsets[1])
+}
+
+// terminalMessage is a control message on a shell's WebSocket. Clients send "input"
+// and "resize"; the server sends "exit" once the process has ended.
+type terminalMessage struct {
+	Type   string `json:"type"`
+	Data   string `json:"data,omitempty"`   // input: bytes to type
+	Rows   uint16 `json:"rows,omitempty"`   // resize
+	Cols   uint16 `json:"cols,omitempty"`   // resize
+	Code   *int   `json:"code,omitempty"`   // exit: the exit code, -1 if killed by a signal
+	Signal string `json:"signal,omitempty"` // exit: the signal that killed the process
+	Reason string `json:"reason,omitempty"` // exit: why the shell ended, e.g. EndIdle
+}
+
+// ShellSocketHandler connects a WebSocket to a shell session. Binary messages from the
+// client are typed into the shell as they are, and text messages carry a
+// terminalMessage. The server replays the output still buffered, then sends new output
+// as binary messages as it arrives, and finally an "exit" message.
+func (s *Shells) ShellSocketHandler(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Policy != nil {
+		// Keystrokes can't be checked against a command policy.
+		http.Error(w, "Interactive terminals are disabled by the command policy", http.StatusForbidden)
+		return
+	}
+	session, ok := s.session(w, r)
+	if !ok {
+		return
+	}
+	id := session.ID
+	stdout, _, err := s.sessions.Follow(id, spawn.StreamStdout, 0)
+	if err != nil {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	defer stdout.Close()
+	// On a terminal, stderr arrives with stdout.
+	var stderr *spawn.Subscription
+	if session.Stderr != nil {
+		if stderr, _, err = s.sessions.Follow(id, spawn.StreamStderr, 0); err != nil {
+			http.Error(w, "Session not found", http.StatusNotFound)
+			return
+		}
+		defer stderr.Close()
+	}
+
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		log.Printf("Shell %s WebSocket upgrade failed: %v", id, err)
+		return
+	}
+	defer ws.Close(wsCloseNormal, "")
+
+	inputDone := make(chan struct{})
+	go func() {
+		defer close(inputDone)
+		s.copyTerminalInput(ws, session)
+	}()
+
+	stdoutOpen, stderrOpen := true, stderr != nil
+	for stdoutOpen || stderrOpen {
+		var stdoutReady, stderrReady <-chan struct{}
+		if stdoutOpen {
+			stdoutReady = stdout.Ready()
+		}
+		if stderrOpen {
+			stderrReady = stderr.Ready()
+		}
+		var sub *spawn.Subscription
+		select {
+		case <-inputDone:
+			return
+		case <-stdoutReady:
+			sub = stdout
+		case <-stderrReady:
+			sub = stderr
+		}
+		data, open := sub.Next()
+		if len(data) > 0 {
+			if err := ws.WriteMessage(wsBinary, data); err != nil {
+				return
+			}
+		}
+		if !open {
+			stdoutOpen = stdoutOpen && sub != stdout
+			stderrOpen = stderrOpen && sub != stderr
+		}
+	}
+
+	// The output has ended; report how the process exited once the reaper has seen it.
+	select {
+	case <-session.Done():
+	case <-inputDone:
+		return
+	}
+	exit := session.Exit()
+	msg, _ := json.Marshal(terminalMessage{Type: "exit", Code: &exit.Code, Signal: exit.Signal, Reason: s.ends.reason(id)})
+	ws.WriteMessage(wsText, msg)
+}
+
+// copyTerminalInput feeds what the client types into a shell and applies its resizes,
+// until the client goes away.
+func (s *Shells) copyTerminalInput(ws *wsConn, session *spawn.ShellSession) {
+	for {
+		opcode, data, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if opcode == wsText {
+			var msg terminalMessage
+			if err := json.Unmarshal(data, &msg); err != nil {
+				ws.fail(wsCloseUnsupported, "text messages must be JSON control messages")
+				return
+			}
+			switch msg.Type {
+			case "input":
+				data = []byte(msg.Data)
+			case "resize":
+				if err := s.sessions.Resize(session.ID, msg.Rows, msg.Cols); err != nil {
+					log.Printf("Shell %s resize failed: %v", session.ID, err)
+				}
+				continue
+			default:
+				continue
+			}
+		}
+		if _, err := session.Stdin.Write(data); err != nil {
+			return
+		}
+	}
+}
+
 // extractID parses the session ID from the URL path
 func extractID(path string) string {
 	parts := strings.Split(path, "/")
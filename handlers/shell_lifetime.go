@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/owen-6936/llm-cortex/spawn"
+)
+
+// Why a shell ended, as reported by GET /shell and the exit events.
+const (
+	EndClosed   = "closed"       // Closed through the API
+	EndExited   = "exited"       // The shell exited by itself
+	EndIdle     = "idle"         // No input or output for ShellOptions.IdleTimeout
+	EndLifetime = "max_lifetime" // Open for ShellOptions.MaxLifetime
+)
+
+// maxEndedShells is how many ended shells GET /shell keeps reporting.
+const maxEndedShells = 100
+
+// endedShell is a shell that has been closed.
+type endedShell struct {
+	ID      string    `json:"id"`
+	Reason  string    `json:"reason"`
+	EndedAt time.Time `json:"ended_at"`
+	Code    *int      `json:"code,omitempty"`   // -1 if the process was killed by a signal
+	Signal  string    `json:"signal,omitempty"` // The signal that killed the process, if any
+
+	owner string
+}
+
+// shellInfo describes a live shell in GET /shell.
+type shellInfo struct {
+	ID      string    `json:"id"`
+	PTY     bool      `json:"pty"`
+	State   string    `json:"state"` // running or exited
+	Created time.Time `json:"created"`
+	Age     string    `json:"age"`
+	Idle    string    `json:"idle"` // Time since the last input or output
+	Code    *int      `json:"code,omitempty"`
+	Signal  string    `json:"signal,omitempty"`
+}
+
+// shellEnds remembers why recent shells ended.
+type shellEnds struct {
+	mu    sync.Mutex
+	byID  map[string]*endedShell
+	order []string // Oldest first
+}
+
+// begin records why a shell is about to end, so its exit events can say.
+func (e *shellEnds) begin(session *spawn.ShellSession, reason string) *endedShell {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.byID == nil {
+		e.byID = make(map[string]*endedShell)
+	}
+	if end, ok := e.byID[session.ID]; ok {
+		return end
+	}
+	end := &endedShell{ID: session.ID, Reason: reason, owner: session.Labels[spawn.LabelOwner]}
+	e.byID[session.ID] = end
+	e.order = append(e.order, session.ID)
+	if len(e.order) > maxEndedShells {
+		delete(e.byID, e.order[0])
+		e.order = e.order[1:]
+	}
+	return end
+}
+
+// finish records how the shell's process ended.
+func (e *shellEnds) finish(end *endedShell, exit *spawn.ExitError) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	end.EndedAt = time.Now()
+	if exit != nil {
+		end.Code, end.Signal = &exit.Code, exit.Signal
+	}
+}
+
+// reason returns why a shell ended; a shell that wasn't closed exited by itself.
+func (e *shellEnds) reason(id string) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if end, ok := e.byID[id]; ok {
+		return end.Reason
+	}
+	return EndExited
+}
+
+// list returns the ended shells owned by owner, most recent first.
+func (e *shellEnds) list(owner string) []endedShell {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ended := []endedShell{}
+	for i := len(e.order) - 1; i >= 0; i-- {
+		if end := e.byID[e.order[i]]; end.owner == owner && !end.EndedAt.IsZero() {
+			ended = append(ended, *end)
+		}
+	}
+	return ended
+}
+
+// end closes a shell, recording why.
+func (s *Shells) end(session *spawn.ShellSession, reason string) error {
+	end := s.ends.begin(session, reason)
+	err := s.sessions.CloseSession(session.ID)
+	var exitErr *spawn.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return err
+	}
+	s.ends.finish(end, session.Exit())
+	return nil
+}
+
+// checkLimits returns an error if the caller may not start another shell.
+func (s *Shells) checkLimits(caller string) error {
+	if s.opts.MaxSessions <= 0 && s.opts.MaxSessionsPerClient <= 0 {
+		return nil
+	}
+	total, own := 0, 0
+	for _, session := range s.sessions.List(map[string]string{spawn.LabelKind: spawn.KindShell}) {
+		if session.Exit() != nil {
+			continue // Exited shells are on their way out.
+		}
+		total++
+		if session.Labels[spawn.LabelOwner] == caller {
+			own++
+		}
+	}
+	if s.opts.MaxSessions > 0 && total >= s.opts.MaxSessions {
+		return fmt.Errorf("the server already runs the maximum of %d shells", s.opts.MaxSessions)
+	}
+	if s.opts.MaxSessionsPerClient > 0 && own >= s.opts.MaxSessionsPerClient {
+		return fmt.Errorf("you already have the maximum of %d shells open", s.opts.MaxSessionsPerClient)
+	}
+	return nil
+}
+
+// ListShellsHandler lists the caller's shells with their age, idle time and state, and
+// the shells that ended recently with the reason why.
+func (s *Shells) ListShellsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+		return
+	}
+	caller := Caller(r)
+	now := time.Now()
+	shells := []shellInfo{}
+	for _, session := range s.sessions.List(map[string]string{spawn.LabelKind: spawn.KindShell}) {
+		if session.Labels[spawn.LabelOwner] != caller {
+			continue
+		}
+		info := shellInfo{
+			ID:      session.ID,
+			PTY:     session.HasPTY(),
+			State:   "running",
+			Created: session.CreatedAt,
+			Age:     now.Sub(session.CreatedAt).Round(time.Second).String(),
+			Idle:    now.Sub(session.LastActivity()).Round(time.Second).String(),
+		}
+		if exit := session.Exit(); exit != nil {
+			info.State = "exited"
+			info.Code, info.Signal = &exit.Code, exit.Signal
+		}
+		shells = append(shells, info)
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"shells": shells, "ended": s.ends.list(caller)})
+}
+
+// Reap closes expired shells until ctx is done: those without input or output for
+// IdleTimeout, those open for MaxLifetime, and those whose process has exited. It checks
+// at a quarter of the shorter timeout, but at least every 30s.
+func (s *Shells) Reap(ctx context.Context) {
+	interval := 30 * time.Second
+	for _, timeout := range []time.Duration{s.opts.IdleTimeout, s.opts.MaxLifetime} {
+		if timeout > 0 && timeout/4 < interval {
+			interval = max(timeout/4, time.Second)
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.reapExpired(now)
+		}
+	}
+}
+
+// reapExpired closes the shells that have expired by now. Each is closed in the
+// background, so one that is slow to exit doesn't hold up the others; CloseSession
+// forgets it at once, so the next check doesn't close it again.
+func (s *Shells) reapExpired(now time.Time) {
+	s.sessions.Range(func(session *spawn.ShellSession) bool {
+		reason, detail := s.expired(session, now)
+		if reason == "" {
+			return true
+		}
+		go func() {
+			log.Printf("Closing shell %s: %s", session.ID, detail)
+			if err := s.end(session, reason); err != nil {
+				log.Printf("Failed to close shell %s: %v", session.ID, err)
+			}
+		}()
+		return true
+	})
+}
+
+// expired returns why a shell should be closed by now, if it should.
+func (s *Shells) expired(session *spawn.ShellSession, now time.Time) (string, string) {
+	if session.Exit() != nil {
+		return EndExited, "its process has exited"
+	}
+	if age := now.Sub(session.CreatedAt); s.opts.MaxLifetime > 0 && age >= s.opts.MaxLifetime {
+		return EndLifetime, fmt.Sprintf("open for %s, the maximum lifetime", age.Round(time.Second))
+	}
+	if idle := now.Sub(session.LastActivity()); s.opts.IdleTimeout > 0 && idle >= s.opts.IdleTimeout {
+		return EndIdle, fmt.Sprintf("idle for %s", idle.Round(time.Second))
+	}
+	return "", ""
+}
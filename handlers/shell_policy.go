@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// CommandPolicy decides which commands may be sent to a shell. It matches the whole
+// command line against regular expressions: deny rules win, and if there are allow rules
+// a command must match one of them. It is a guard against mistakes, not a security
+// boundary; a shell has many ways to run a command that no pattern anticipates, so
+// confine shells with a sandbox as well.
+type CommandPolicy struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// NewCommandPolicy compiles allow and deny rules. It returns nil if there are none.
+func NewCommandPolicy(allow, deny []string) (*CommandPolicy, error) {
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	p := &CommandPolicy{}
+	for _, rules := range []struct {
+		patterns []string
+		into     *[]*regexp.Regexp
+	}{{allow, &p.allow}, {deny, &p.deny}} {
+		for _, pattern := range rules.patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid command rule %q: %w", pattern, err)
+			}
+			*rules.into = append(*rules.into, re)
+		}
+	}
+	return p, nil
+}
+
+// Check returns an error if the policy doesn't allow every line of command. A nil
+// policy allows everything.
+func (p *CommandPolicy) Check(command string) error {
+	if p == nil {
+		return nil
+	}
+	// A terminal takes a carriage return as Enter too.
+	lines := strings.FieldsFunc(command, func(r rune) bool { return r == '\n' || r == '\r' })
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			if err := p.checkLine(line); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *CommandPolicy) checkLine(command string) error {
+	for _, re := range p.deny {
+		if re.MatchString(command) {
+			return fmt.Errorf("command denied by rule %q", re.String())
+		}
+	}
+	if len(p.allow) == 0 {
+		return nil
+	}
+	for _, re := range p.allow {
+		if re.MatchString(command) {
+			return nil
+		}
+	}
+	return fmt.Errorf("command %q not allowed by any rule", command)
+}
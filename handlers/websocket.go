@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// WebSocket opcodes from RFC 6455, section 5.2.
+const (
+	wsContinuation = 0x0
+	wsText         = 0x1
+	wsBinary       = 0x2
+	wsClose        = 0x8
+	wsPing         = 0x9
+	wsPong         = 0xA
+)
+
+// Close status codes from RFC 6455, section 7.4.1.
+const (
+	wsCloseNormal      = 1000
+	wsCloseProtocol    = 1002
+	wsCloseUnsupported = 1003
+	wsCloseTooBig      = 1009
+)
+
+// wsMaxMessage caps the size of a message from the client.
+const wsMaxMessage = 1 << 20
+
+// wsAcceptGUID is appended to the client's key to prove the server speaks WebSocket.
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var errWSClosed = errors.New("websocket closed")
+
+// wsConn is the server side of a WebSocket connection. It implements just what the
+// terminal needs: text and binary messages, fragmentation, ping and close, without
+// extensions or subprotocols. Reads must come from a single goroutine; writes may come
+// from any.
+type wsConn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+// upgradeWebSocket completes the opening handshake of a WebSocket request. On failure it
+// has already written an error response.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	if r.Method != http.MethodGet || !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "Expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("not a websocket request")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusUpgradeRequired)
+		return nil, errors.New("unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "Missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, errors.New("missing websocket key")
+	}
+	// Browsers send cookies and credentials with WebSocket requests from any page, so
+	// only let the dashboard's own origin in.
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			http.Error(w, "Cross-origin WebSocket requests are not allowed", http.StatusForbidden)
+			return nil, fmt.Errorf("websocket origin %q does not match host %q", origin, r.Host)
+		}
+	}
+
+	conn, rw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, "WebSocket upgrade not supported", http.StatusInternalServerError)
+		return nil, err
+	}
+	sum := sha1.Sum([]byte(key + wsAcceptGUID))
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, br: rw.Reader}, nil
+}
+
+// headerHasToken reports whether a comma-separated header contains token.
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage returns the next text or binary message. Pings are answered and pongs
+// skipped on the way. Once the client closes the connection it returns io.EOF.
+func (c *wsConn) ReadMessage() (int, []byte, error) {
+	var (
+		opcode  int
+		message []byte
+	)
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+		switch op {
+		case wsPing:
+			c.writeFrame(wsPong, payload)
+			continue
+		case wsPong:
+			continue
+		case wsClose:
+			code := wsCloseNormal
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			c.Close(code, "")
+			return 0, nil, io.EOF
+		case wsText, wsBinary:
+			if opcode != 0 {
+				return 0, nil, c.fail(wsCloseProtocol, "new message inside a fragmented one")
+			}
+			opcode = op
+		case wsContinuation:
+			if opcode == 0 {
+				return 0, nil, c.fail(wsCloseProtocol, "continuation without a message")
+			}
+		default:
+			return 0, nil, c.fail(wsCloseProtocol, fmt.Sprintf("unknown opcode %d", op))
+		}
+		if len(message)+len(payload) > wsMaxMessage {
+			return 0, nil, c.fail(wsCloseTooBig, "message too big")
+		}
+		message = append(message, payload...)
+		if fin {
+			return opcode, message, nil
+		}
+	}
+}
+
+// readFrame reads and unmasks a single frame.
+func (c *wsConn) readFrame() (bool, int, []byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin := header[0]&0x80 != 0
+	op := int(header[0] & 0x0F)
+	if header[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(wsCloseProtocol, "reserved bits set")
+	}
+	if header[1]&0x80 == 0 {
+		return false, 0, nil, c.fail(wsCloseProtocol, "client frames must be masked")
+	}
+
+	length := uint64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	if op >= wsClose && (length > 125 || !fin) {
+		return false, 0, nil, c.fail(wsCloseProtocol, "bad control frame")
+	}
+	if length > wsMaxMessage {
+		return false, 0, nil, c.fail(wsCloseTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, op, payload, nil
+}
+
+// WriteMessage sends a text or binary message in a single frame.
+func (c *wsConn) WriteMessage(opcode int, data []byte) error {
+	return c.writeFrame(opcode, data)
+}
+
+func (c *wsConn) writeFrame(opcode int, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return errWSClosed
+	}
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | byte(opcode)
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// fail closes the connection with a status code after the client broke the protocol.
+func (c *wsConn) fail(code int, reason string) error {
+	c.Close(code, reason)
+	return fmt.Errorf("websocket protocol error: %s", reason)
+}
+
+// Close sends a close frame with a status code and closes the connection. It is safe to
+// call more than once.
+func (c *wsConn) Close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	c.writeFrame(wsClose, append(payload, reason...))
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
@@ -4,9 +4,13 @@ import torch
 import time
 import argparse
 import json
+import os
 import sys
 from typing import Dict
 
+sys.path.insert(0, os.path.join(os.path.dirname(os.path.abspath(__file__)), "..", ".."))
+from worker.protocol import Worker
+
 
 class BlipPlugin:
     def __init__(self, model_path: str, device: str = "cpu", dtype=torch.float32, use_fast: bool = True, legacy: bool = True):
@@ -69,29 +73,20 @@ def main():
     args = parser.parse_args()
 
     try:
+        # Claim stdout for protocol frames before anything else can print to it.
+        worker = Worker() if args.interactive else None
         device = "cuda" if torch.cuda.is_available() else "cpu"
         plugin = BlipPlugin(model_path=args.model_path, device=device)
 
-        if args.interactive:
-            for line in sys.stdin:
-                try:
-                    input_data = json.loads(line)
-                    # The processor is loaded once, so we can't change these per request,
-                    # but we can honor them on the first interactive request if needed.
-                    # For now, we just ensure the invoke call gets the right params.
-                    result = plugin.invoke(
-                        image_path=input_data.get("image_path"),
-                        prompt=input_data.get("prompt", ""),
-                        max_length=input_data.get("max_length", 75)
-                    )
-                    print(json.dumps(result), flush=True)
-                    print("END_OF_JSON", flush=True)
-                except json.JSONDecodeError:
-                    # Ignore invalid JSON lines
-                    pass
-                except Exception as e:
-                    print(json.dumps({"error": str(e)}), flush=True)
-                    print("END_OF_JSON", flush=True)
+        if worker:
+            # The processor is loaded once, so use_fast and legacy can't change per request.
+            worker.serve({
+                "invoke": lambda params: plugin.invoke(
+                    image_path=params.get("image_path"),
+                    prompt=params.get("prompt", ""),
+                    max_length=params.get("max_length", 75)
+                )
+            }, info={"model": "blip", "device": device})
         else:
             result = plugin.invoke(image_path=args.image_path, prompt=args.prompt, max_length=args.max_length)
             print(json.dumps(result, indent=2))
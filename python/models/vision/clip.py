@@ -1,3 +1,4 @@
+import os
 import sys
 import argparse
 import json
@@ -7,6 +8,9 @@ from PIL import Image
 import torch
 from transformers import CLIPProcessor, CLIPModel
 
+sys.path.insert(0, os.path.join(os.path.dirname(os.path.abspath(__file__)), "..", ".."))
+from worker.protocol import Worker
+
 class CLIPPlugin:
     def __init__(self, model_path: str, device: str = "cpu", dtype=torch.float32, use_fast: bool = True):
         self.device = device
@@ -63,23 +67,19 @@ def main():
     args = parser.parse_args()
 
     try:
+        # Claim stdout for protocol frames before anything else can print to it.
+        worker = Worker() if args.interactive else None
         device = args.device if args.device != "auto" else ("cuda" if torch.cuda.is_available() else "cpu")
         dtype = torch.float16 if device == "cuda" else torch.float32
         plugin = CLIPPlugin(model_path=args.model_path, device=device, dtype=dtype)
 
-        if args.interactive:
-            for line in sys.stdin:
-                try:
-                    input_data = json.loads(line)
-                    result = plugin.invoke(
-                        image_path=input_data.get("image_path"),
-                        texts=input_data.get("texts", [])
-                    )
-                    print(json.dumps(result), flush=True)
-                    print("END_OF_JSON", flush=True)
-                except Exception as e:
-                    print(json.dumps({"error": str(e)}), flush=True)
-                    print("END_OF_JSON", flush=True)
+        if worker:
+            worker.serve({
+                "invoke": lambda params: plugin.invoke(
+                    image_path=params.get("image_path"),
+                    texts=params.get("texts", [])
+                )
+            }, info={"model": "clip", "device": device})
         else:
             plugin.use_fast = args.use_fast
             result = plugin.invoke(image_path=args.image_path, texts=args.texts)
@@ -11,6 +11,9 @@ from PIL import Image
 from transformers import CLIPModel, CLIPProcessor
 from model import CLIPtionModel
 
+sys.path.insert(0, os.path.join(os.path.dirname(os.path.abspath(__file__)), "..", "..", ".."))
+from worker.protocol import Worker
+
 class CLIPtionPlugin:
     def __init__(self, model_path: str, device: str = "cpu", dtype=torch.float16, use_fast: bool = True):
         print(f"[CLIPtion] Loading processor and model from {model_path}...")
@@ -75,23 +78,19 @@ def main():
     dtype = torch.float32 if device == "cpu" else torch.float16
 
     try:
+        # Claim stdout for protocol frames before anything else can print to it.
+        worker = Worker() if args.interactive else None
         plugin = CLIPtionPlugin(args.model_path, device=device, dtype=dtype, use_fast=args.use_fast)
-        if args.interactive:
-            for line in sys.stdin:
-                try:
-                    input_data = json.loads(line)
-                    result = plugin.invoke(
-                        image_path=input_data.get("image_path"),
-                        beam_search=input_data.get("beam_search", False),
-                        beam_width=input_data.get("beam_width", 5),
-                        best_of=input_data.get("best_of", 5),
-                        temperature=input_data.get("temperature", 1.0)
-                    )
-                    print(json.dumps(result), flush=True)
-                    print("END_OF_JSON", flush=True)
-                except Exception as e:
-                    print(json.dumps({"error": str(e)}), flush=True)
-                    print("END_OF_JSON", flush=True)
+        if worker:
+            worker.serve({
+                "invoke": lambda params: plugin.invoke(
+                    image_path=params.get("image_path"),
+                    beam_search=params.get("beam_search", False),
+                    beam_width=params.get("beam_width", 5),
+                    best_of=params.get("best_of", 5),
+                    temperature=params.get("temperature", 1.0)
+                )
+            }, info={"model": "cliption", "device": device})
         else:
             if not args.image_path:
                 print(json.dumps({"error": "image-path is required for non-interactive mode"}), file=sys.stderr)
@@ -0,0 +1,76 @@
+"""
+Framed JSON-lines protocol spoken between the Go orchestrator and model workers.
+
+Requests arrive on stdin, one JSON object per line:
+
+    {"v": 1, "id": "<request id>", "method": "invoke", "params": {...}}
+
+Every response is a single stdout line made of FRAME_PREFIX followed by a JSON envelope:
+
+    {"v": 1, "id": "<request id>", "ok": true, "result": {...}, "timings": {"compute_ms": 12.3}}
+    {"v": 1, "id": "<request id>", "ok": false, "error": {"type": "ValueError", "message": "..."}}
+
+Once the model is loaded the worker announces itself with {"v": 1, "event": "ready", "ok": true}.
+Anything else printed while the worker runs is redirected to stderr, so log lines and
+library warnings can never be mistaken for a response.
+"""
+import json
+import sys
+import time
+from typing import Any, Callable, Dict, Optional
+
+PROTOCOL_VERSION = 1
+FRAME_PREFIX = "@@cortex:v1 "
+
+Handler = Callable[[Dict[str, Any]], Any]
+
+
+class Worker:
+    def __init__(self):
+        # Keep the real stdout for frames and send every other print to stderr.
+        self._out = sys.stdout
+        sys.stdout = sys.stderr
+
+    def send(self, envelope: Dict[str, Any]):
+        envelope["v"] = PROTOCOL_VERSION
+        self._out.write(FRAME_PREFIX + json.dumps(envelope) + "\n")
+        self._out.flush()
+
+    def ready(self, info: Optional[Dict[str, Any]] = None):
+        self.send({"event": "ready", "ok": True, "result": info or {}})
+
+    def serve(self, handlers: Dict[str, Handler], info: Optional[Dict[str, Any]] = None):
+        """
+        Announces readiness and answers requests from stdin until it is closed.
+        A built-in "ping" method is always available for health checks.
+        """
+        handlers = {"ping": lambda params: {"pong": True}, **handlers}
+        self.ready(info)
+        for line in sys.stdin:
+            line = line.strip()
+            if not line:
+                continue
+            started = time.time()
+            request_id = None
+            try:
+                request = json.loads(line)
+                request_id = request.get("id")
+                if request.get("v") != PROTOCOL_VERSION:
+                    raise ValueError(f"unsupported protocol version {request.get('v')!r}")
+                method = request.get("method", "invoke")
+                if method not in handlers:
+                    raise ValueError(f"unknown method {method!r}")
+                result = handlers[method](request.get("params") or {})
+                self.send({
+                    "id": request_id,
+                    "ok": True,
+                    "result": result,
+                    "timings": {"compute_ms": (time.time() - started) * 1000},
+                })
+            except Exception as e:
+                self.send({
+                    "id": request_id,
+                    "ok": False,
+                    "error": {"type": type(e).__name__, "message": str(e)},
+                    "timings": {"compute_ms": (time.time() - started) * 1000},
+                })
@@ -0,0 +1,83 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+)
+
+// Future is the result of a submitted job, available once the job has finished.
+type Future[T any] struct {
+	done   chan struct{}
+	value  T
+	err    error
+	cancel context.CancelCauseFunc
+}
+
+// Submit queues fn to run on opts.Model's workers and returns a future for its result.
+// fn gets a context that is cancelled when ctx is, when the future is cancelled and at
+// opts.Deadline; a job that is still queued by then fails with the context's error
+// without running. Submit fails at once with ErrQueueFull or ErrClosed.
+func Submit[T any](ctx context.Context, s *Scheduler, opts JobOptions, fn func(ctx context.Context) (T, error)) (*Future[T], error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	jobCtx, cancel := context.WithCancelCause(ctx)
+	if !opts.Deadline.IsZero() {
+		var cancelDeadline context.CancelFunc
+		jobCtx, cancelDeadline = context.WithDeadline(jobCtx, opts.Deadline)
+		parentCancel := cancel
+		cancel = func(cause error) {
+			parentCancel(cause)
+			cancelDeadline()
+		}
+	}
+	f := &Future[T]{done: make(chan struct{}), cancel: cancel}
+	j := &job{
+		priority: opts.Priority,
+		ctx:      jobCtx,
+		run: func() (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					err = fmt.Errorf("job for model '%s' panicked: %v", opts.Model, r)
+					f.complete(*new(T), err)
+				}
+			}()
+			value, err := fn(jobCtx)
+			f.complete(value, err)
+			return err
+		},
+		fail: func(err error) {
+			f.complete(*new(T), err)
+		},
+	}
+	if err := s.enqueue(opts.Model, j); err != nil {
+		cancel(err)
+		return nil, err
+	}
+	return f, nil
+}
+
+// complete sets the result and releases the job's context.
+func (f *Future[T]) complete(value T, err error) {
+	f.value, f.err = value, err
+	f.cancel(nil)
+	close(f.done)
+}
+
+// Done returns a channel that is closed once the job has finished.
+func (f *Future[T]) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the job has finished and returns its result. A job that ignores its
+// context keeps Wait waiting even after it is cancelled.
+func (f *Future[T]) Wait() (T, error) {
+	<-f.done
+	return f.value, f.err
+}
+
+// Cancel cancels the job. A queued job fails with context.Canceled without running;
+// a running one sees its context cancelled.
+func (f *Future[T]) Cancel() {
+	f.cancel(context.Canceled)
+}
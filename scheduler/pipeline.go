@@ -0,0 +1,528 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrStepSkipped is the error of a step that didn't run because a step it needs failed.
+	ErrStepSkipped = errors.New("step skipped")
+	// ErrMissingValue is returned when a step input refers to a value that isn't there.
+	ErrMissingValue = errors.New("referenced value is missing")
+)
+
+// Step and pipeline outcomes.
+const (
+	StatusOK      = "ok"      // Every step ran successfully
+	StatusPartial = "partial" // Only optional steps failed or were skipped
+	StatusFailed  = "failed"  // A required step failed or was skipped
+	StatusSkipped = "skipped" // The step didn't run because a step it needs failed
+)
+
+// InputStep is the name under which step inputs refer to the pipeline's own input.
+const InputStep = "input"
+
+// Pipeline is a named graph of model calls. Steps take their inputs from the pipeline's
+// input and from the outputs of earlier steps, and each one runs once the steps it needs
+// have finished, so independent branches run in parallel.
+//
+// A string anywhere in a step's Inputs may contain references of the form
+// ${step.path}, where step is a step name or "input" and path is a dot-separated list
+// of object keys and array indexes into its output, e.g. ${caption.caption} or
+// ${input.labels.0}. A string that is a single reference is replaced by the value
+// itself; otherwise each reference is replaced by its text, with non-strings as JSON.
+// A reference may end in a filter: ${classify.results|top} is the key of the largest
+// number in an object, such as the winning label of a classification, and
+// ${classify.results|json} is the value as JSON text.
+type Pipeline struct {
+	Name    string
+	Steps   []Step
+	Output  string        // The step whose output is the pipeline's; defaults to the last one
+	Timeout time.Duration // Limit on the whole run; zero is none
+}
+
+// Step is one model call in a pipeline.
+type Step struct {
+	Name       string
+	Model      string                 // The model the step runs on, on that model's workers
+	Capability string                 // What the step asks of the model, e.g. "caption"; passed to the StepFunc
+	Inputs     map[string]interface{} // The step's parameters, with references to other outputs
+	After      []string               // Steps to wait for besides those Inputs refer to
+	Optional   bool                   // The pipeline still succeeds, as partial, if the step fails
+	Timeout    time.Duration          // Limit on the step, including time queued; zero is none
+}
+
+// StepFunc runs a step with its references resolved, once one of its model's workers is
+// free, and returns its output.
+type StepFunc func(ctx context.Context, step Step, params map[string]interface{}) (interface{}, error)
+
+// StepResult is the outcome of one step.
+type StepResult struct {
+	Name     string
+	Model    string
+	Status   string        // StatusOK, StatusFailed or StatusSkipped
+	Output   interface{}   // The StepFunc's output, if the step succeeded
+	Err      error         // Why the step failed or was skipped
+	Start    time.Duration // When the step's inputs were ready, from the start of the run
+	Queued   time.Duration // Time spent waiting for a worker
+	Duration time.Duration // Time spent running
+}
+
+// PipelineResult is the outcome of a pipeline run.
+type PipelineResult struct {
+	Pipeline string
+	Status   string       // StatusOK, StatusPartial or StatusFailed
+	Output   interface{}  // The output of the pipeline's Output step
+	Steps    []StepResult // In the order of the pipeline's steps
+	Duration time.Duration
+}
+
+// Validate checks that step names are unique, that every step has a model, that
+// references and After name existing steps and that the steps don't depend on each
+// other in a cycle.
+func (p *Pipeline) Validate() error {
+	if len(p.Steps) == 0 {
+		return fmt.Errorf("pipeline '%s' has no steps", p.Name)
+	}
+	steps := make(map[string]bool, len(p.Steps))
+	for _, step := range p.Steps {
+		switch {
+		case step.Name == "":
+			return fmt.Errorf("pipeline '%s' has a step without a name", p.Name)
+		case step.Name == InputStep || strings.ContainsAny(step.Name, ".|${} "):
+			return fmt.Errorf("pipeline '%s' has a step named '%s'; step names may not be '%s' or contain . | $ { } or spaces", p.Name, step.Name, InputStep)
+		case steps[step.Name]:
+			return fmt.Errorf("pipeline '%s' has more than one step named '%s'", p.Name, step.Name)
+		case step.Model == "":
+			return fmt.Errorf("step '%s' of pipeline '%s' has no model", step.Name, p.Name)
+		}
+		steps[step.Name] = true
+	}
+	if p.Output != "" && !steps[p.Output] {
+		return fmt.Errorf("pipeline '%s' takes its output from unknown step '%s'", p.Name, p.Output)
+	}
+	for _, step := range p.Steps {
+		if _, err := parseReferences(step.Inputs); err != nil {
+			return fmt.Errorf("step '%s' of pipeline '%s': %w", step.Name, p.Name, err)
+		}
+		for _, dep := range p.DependsOn(step.Name) {
+			if !steps[dep] {
+				return fmt.Errorf("step '%s' of pipeline '%s' depends on unknown step '%s'", step.Name, p.Name, dep)
+			}
+		}
+	}
+
+	// Depth-first search for a cycle.
+	const visiting, visited = 1, 2
+	marks := make(map[string]int, len(p.Steps))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch marks[name] {
+		case visiting:
+			return fmt.Errorf("steps of pipeline '%s' depend on each other in a cycle: %s", p.Name, strings.Join(append(path, name), " -> "))
+		case visited:
+			return nil
+		}
+		marks[name] = visiting
+		for _, dep := range p.DependsOn(name) {
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+		marks[name] = visited
+		return nil
+	}
+	for _, step := range p.Steps {
+		if err := visit(step.Name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DependsOn returns the steps the named step waits for, sorted: those in its After and
+// those its inputs refer to.
+func (p *Pipeline) DependsOn(name string) []string {
+	seen := make(map[string]bool)
+	for _, step := range p.Steps {
+		if step.Name != name {
+			continue
+		}
+		for _, dep := range step.After {
+			seen[dep] = true
+		}
+		refs, _ := parseReferences(step.Inputs)
+		for _, ref := range refs {
+			if ref.step != InputStep {
+				seen[ref.step] = true
+			}
+		}
+	}
+	deps := make([]string, 0, len(seen))
+	for dep := range seen {
+		deps = append(deps, dep)
+	}
+	sort.Strings(deps)
+	return deps
+}
+
+// RunPipeline runs a pipeline on input. Each step is queued on its model's workers, at
+// the priority carried by ctx, as soon as the steps it needs have succeeded, and run
+// with run. When a step fails, the steps that need it are skipped, but independent
+// branches carry on. The result covers every step; the error is that of the first
+// required step that failed, or else was skipped, if any.
+func (s *Scheduler) RunPipeline(ctx context.Context, p *Pipeline, input map[string]interface{}, run StepFunc) (*PipelineResult, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	results := make([]StepResult, len(p.Steps))
+	index := make(map[string]int, len(p.Steps))
+	done := make(map[string]chan struct{}, len(p.Steps))
+	for i, step := range p.Steps {
+		index[step.Name] = i
+		done[step.Name] = make(chan struct{})
+	}
+	values := &pipelineValues{raw: map[string]interface{}{InputStep: input}}
+
+	var wg sync.WaitGroup
+	for i, step := range p.Steps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[step.Name])
+			deps := p.DependsOn(step.Name)
+			for _, dep := range deps {
+				<-done[dep]
+			}
+			// Each result is written before its done channel is closed, so it can be read now.
+			result := StepResult{Name: step.Name, Model: step.Model}
+			for _, dep := range deps {
+				if results[index[dep]].Status != StatusOK {
+					result.Status = StatusSkipped
+					result.Err = fmt.Errorf("%w: step '%s' %s", ErrStepSkipped, dep, results[index[dep]].Status)
+					results[i] = result
+					return
+				}
+			}
+			results[i] = s.runStep(ctx, step, values, start, run)
+			if results[i].Status == StatusOK {
+				values.set(step.Name, results[i].Output)
+			}
+		}()
+	}
+	wg.Wait()
+
+	result := &PipelineResult{Pipeline: p.Name, Status: StatusOK, Steps: results, Duration: time.Since(start)}
+	output := p.Output
+	if output == "" {
+		output = p.Steps[len(p.Steps)-1].Name
+	}
+	result.Output = results[index[output]].Output
+	var failed, skipped error
+	for i, step := range p.Steps {
+		if results[i].Status == StatusOK {
+			continue
+		}
+		if step.Optional {
+			if result.Status == StatusOK {
+				result.Status = StatusPartial
+			}
+			continue
+		}
+		result.Status = StatusFailed
+		err := fmt.Errorf("step '%s' of pipeline '%s' %s: %w", step.Name, p.Name, results[i].Status, results[i].Err)
+		switch {
+		case results[i].Status == StatusFailed && failed == nil:
+			failed = err
+		case results[i].Status == StatusSkipped && skipped == nil:
+			skipped = err
+		}
+	}
+	if failed != nil {
+		return result, failed
+	}
+	// Only required steps that needed a failed optional one are left to blame.
+	return result, skipped
+}
+
+// runStep resolves a step's inputs, queues it on its model's workers and waits for it.
+func (s *Scheduler) runStep(ctx context.Context, step Step, values *pipelineValues, start time.Time, run StepFunc) StepResult {
+	result := StepResult{Name: step.Name, Model: step.Model, Status: StatusFailed, Start: time.Since(start)}
+	params, err := values.resolve(step.Inputs)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	opts := JobOptions{Model: step.Model, Priority: PriorityFrom(ctx)}
+	queued := time.Now()
+	if step.Timeout > 0 {
+		opts.Deadline = queued.Add(step.Timeout)
+	}
+	var started time.Time
+	future, err := Submit(ctx, s, opts, func(ctx context.Context) (interface{}, error) {
+		started = time.Now()
+		return run(ctx, step, params)
+	})
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	result.Output, result.Err = future.Wait()
+	if started.IsZero() {
+		// Cancelled, or past its deadline, while still queued.
+		result.Queued = time.Since(queued)
+	} else {
+		result.Queued = started.Sub(queued)
+		result.Duration = time.Since(started)
+	}
+	if result.Err == nil {
+		result.Status = StatusOK
+	}
+	return result
+}
+
+// pipelineValues holds the pipeline's input and the outputs of finished steps.
+type pipelineValues struct {
+	mu    sync.Mutex
+	raw   map[string]interface{}
+	plain map[string]interface{} // raw values turned into JSON-like maps and slices on first use
+}
+
+func (v *pipelineValues) set(step string, value interface{}) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.raw[step] = value
+}
+
+// get returns the value a reference points at.
+func (v *pipelineValues) get(ref reference) (interface{}, error) {
+	v.mu.Lock()
+	plain, ok := v.plain[ref.step]
+	if !ok {
+		raw, found := v.raw[ref.step]
+		if !found {
+			v.mu.Unlock()
+			return nil, fmt.Errorf("%w: %s has no output", ErrMissingValue, ref.step)
+		}
+		// Outputs are typed structs; their JSON form is what references address.
+		data, err := json.Marshal(raw)
+		if err == nil {
+			err = json.Unmarshal(data, &plain)
+		}
+		if err != nil {
+			v.mu.Unlock()
+			return nil, fmt.Errorf("output of %s can't be referenced: %w", ref.step, err)
+		}
+		if v.plain == nil {
+			v.plain = make(map[string]interface{})
+		}
+		v.plain[ref.step] = plain
+	}
+	v.mu.Unlock()
+
+	value := plain
+	for i, key := range ref.path {
+		switch node := value.(type) {
+		case map[string]interface{}:
+			value, ok = node[key]
+		case []interface{}:
+			var n int
+			n, ok = parseIndex(key, len(node))
+			if ok {
+				value = node[n]
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			return nil, fmt.Errorf("%w: %s has no %s", ErrMissingValue, ref.step, strings.Join(ref.path[:i+1], "."))
+		}
+	}
+	return applyFilter(ref, value)
+}
+
+// resolve returns a copy of inputs with every reference replaced by its value.
+func (v *pipelineValues) resolve(inputs map[string]interface{}) (map[string]interface{}, error) {
+	params := make(map[string]interface{}, len(inputs))
+	for key, input := range inputs {
+		value, err := v.resolveValue(input)
+		if err != nil {
+			return nil, fmt.Errorf("input %s: %w", key, err)
+		}
+		params[key] = value
+	}
+	return params, nil
+}
+
+func (v *pipelineValues) resolveValue(input interface{}) (interface{}, error) {
+	switch input := input.(type) {
+	case string:
+		return v.resolveString(input)
+	case map[string]interface{}:
+		return v.resolve(input)
+	case []interface{}:
+		values := make([]interface{}, len(input))
+		for i, item := range input {
+			value, err := v.resolveValue(item)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = value
+		}
+		return values, nil
+	default:
+		return input, nil
+	}
+}
+
+func (v *pipelineValues) resolveString(s string) (interface{}, error) {
+	matches := referencePattern.FindAllStringSubmatchIndex(s, -1)
+	if len(matches) == 0 {
+		return s, nil
+	}
+	if len(matches) == 1 && matches[0][0] == 0 && matches[0][1] == len(s) {
+		return v.get(parseReference(s[matches[0][2]:matches[0][3]]))
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		b.WriteString(s[last:m[0]])
+		value, err := v.get(parseReference(s[m[2]:m[3]]))
+		if err != nil {
+			return nil, err
+		}
+		if text, ok := value.(string); ok {
+			b.WriteString(text)
+		} else {
+			data, err := json.Marshal(value)
+			if err != nil {
+				return nil, err
+			}
+			b.Write(data)
+		}
+		last = m[1]
+	}
+	b.WriteString(s[last:])
+	return b.String(), nil
+}
+
+// referencePattern matches ${step.path|filter} in step inputs.
+var referencePattern = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// reference points at a value in the pipeline's input or a step's output.
+type reference struct {
+	step   string
+	path   []string
+	filter string
+}
+
+func parseReference(s string) reference {
+	var ref reference
+	s, ref.filter, _ = strings.Cut(strings.TrimSpace(s), "|")
+	ref.filter = strings.TrimSpace(ref.filter)
+	parts := strings.Split(strings.TrimSpace(s), ".")
+	ref.step, ref.path = parts[0], parts[1:]
+	return ref
+}
+
+// parseReferences returns the references anywhere in inputs, or an error for a
+// malformed one.
+func parseReferences(inputs interface{}) ([]reference, error) {
+	var refs []reference
+	switch inputs := inputs.(type) {
+	case string:
+		for _, m := range referencePattern.FindAllStringSubmatch(inputs, -1) {
+			ref := parseReference(m[1])
+			if ref.step == "" || slices.Contains(ref.path, "") {
+				return nil, fmt.Errorf("malformed reference %s", m[0])
+			}
+			if _, ok := filters[ref.filter]; !ok {
+				return nil, fmt.Errorf("unknown filter '%s' in %s", ref.filter, m[0])
+			}
+			refs = append(refs, ref)
+		}
+	case map[string]interface{}:
+		for _, value := range inputs {
+			more, err := parseReferences(value)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, more...)
+		}
+	case []interface{}:
+		for _, value := range inputs {
+			more, err := parseReferences(value)
+			if err != nil {
+				return nil, err
+			}
+			refs = append(refs, more...)
+		}
+	}
+	return refs, nil
+}
+
+// filters transform a referenced value. The empty filter leaves it as it is.
+var filters = map[string]func(value interface{}) (interface{}, bool){
+	"": func(value interface{}) (interface{}, bool) {
+		return value, true
+	},
+	// top is the key of the largest number in an object.
+	"top": func(value interface{}) (interface{}, bool) {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		var top string
+		var best float64
+		found := false
+		for key, v := range object {
+			n, ok := v.(float64)
+			if ok && (!found || n > best || n == best && key < top) {
+				top, best, found = key, n, true
+			}
+		}
+		return top, found
+	},
+	// json is the value as JSON text.
+	"json": func(value interface{}) (interface{}, bool) {
+		data, err := json.Marshal(value)
+		return string(data), err == nil
+	},
+}
+
+func applyFilter(ref reference, value interface{}) (interface{}, error) {
+	value, ok := filters[ref.filter](value)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s can't be filtered with %s", ErrMissingValue, strings.Join(append([]string{ref.step}, ref.path...), "."), ref.filter)
+	}
+	return value, nil
+}
+
+// parseIndex parses an array index, counting from the end if it's negative.
+func parseIndex(key string, length int) (int, bool) {
+	n, err := strconv.Atoi(key)
+	if err != nil {
+		return 0, false
+	}
+	if n < 0 {
+		n += length
+	}
+	return n, n >= 0 && n < length
+}
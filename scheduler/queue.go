@@ -0,0 +1,298 @@
+package scheduler
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrQueueFull is returned by Submit when a model's queue is at its limit.
+	ErrQueueFull = errors.New("job queue is full")
+	// ErrClosed is returned by Submit after Close, and fails the jobs Close drops.
+	ErrClosed = errors.New("scheduler is closed")
+)
+
+// DefaultWorkers is the number of jobs run at once for a model without a SetWorkers.
+var DefaultWorkers = 1
+
+// DefaultMaxQueue is the number of jobs allowed to wait for each model when
+// Options.MaxQueue is zero.
+var DefaultMaxQueue = 64
+
+// Options configures a Scheduler.
+type Options struct {
+	Workers  int // Jobs run at once per model; 0 uses DefaultWorkers
+	MaxQueue int // Jobs waiting per model; 0 uses DefaultMaxQueue, negative is unlimited
+}
+
+// JobOptions describes where and when a job runs.
+type JobOptions struct {
+	Model    string    // The model the job runs on; each model has a worker pool of its own
+	Priority int       // Higher priorities run first; equal ones in submission order
+	Deadline time.Time // The job fails with context.DeadlineExceeded if it hasn't finished by then; zero is none
+}
+
+type priorityKey struct{}
+
+// WithPriority returns a context that carries a job priority, for code that submits
+// jobs on behalf of a caller further up.
+func WithPriority(ctx context.Context, priority int) context.Context {
+	return context.WithValue(ctx, priorityKey{}, priority)
+}
+
+// PriorityFrom returns the priority carried by ctx, or 0.
+func PriorityFrom(ctx context.Context) int {
+	priority, _ := ctx.Value(priorityKey{}).(int)
+	return priority
+}
+
+// Scheduler queues jobs per model and runs them on a bounded pool of workers for each,
+// highest priority first. Jobs are submitted with Submit, which returns a Future for
+// the job's result.
+type Scheduler struct {
+	mu      sync.Mutex
+	opts    Options
+	pools   map[string]*pool
+	seq     uint64 // Orders jobs of equal priority
+	closed  bool
+	running sync.WaitGroup // Jobs being run
+}
+
+// pool is the queue and the workers of one model.
+type pool struct {
+	model   string
+	workers int
+	queue   jobHeap
+	running int
+
+	completed, failed, cancelled, rejected uint64
+	waited                                 uint64        // Jobs that started, for the average wait
+	totalWait, maxWait                     time.Duration // Time from submission to start
+}
+
+// job is a queued job, independent of its result type.
+type job struct {
+	priority  int
+	seq       uint64
+	index     int // Position in the queue; -1 once it has left it
+	submitted time.Time
+	ctx       context.Context
+	run       func() error    // Runs the job and completes its future
+	fail      func(err error) // Completes its future without running it
+	unwatch   func() bool     // Stops watching ctx for cancellation while queued
+}
+
+// New creates a scheduler.
+func New(opts Options) *Scheduler {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.MaxQueue == 0 {
+		opts.MaxQueue = DefaultMaxQueue
+	}
+	return &Scheduler{opts: opts, pools: make(map[string]*pool)}
+}
+
+// SetWorkers changes how many jobs run at once for a model. Raising it starts queued
+// jobs at once; lowering it lets running jobs finish.
+func (s *Scheduler) SetWorkers(model string, workers int) {
+	if workers <= 0 {
+		workers = s.opts.Workers
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pool(model)
+	p.workers = workers
+	s.dispatch(p)
+}
+
+// pool returns the pool of a model, creating it on first use. s.mu must be held.
+func (s *Scheduler) pool(model string) *pool {
+	p, ok := s.pools[model]
+	if !ok {
+		p = &pool{model: model, workers: s.opts.Workers}
+		s.pools[model] = p
+	}
+	return p
+}
+
+// enqueue queues a job, or fails if the model's queue is full or the scheduler closed.
+func (s *Scheduler) enqueue(model string, j *job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	p := s.pool(model)
+	if s.opts.MaxQueue > 0 && len(p.queue) >= s.opts.MaxQueue && p.running >= p.workers {
+		p.rejected++
+		return ErrQueueFull
+	}
+	s.seq++
+	j.seq = s.seq
+	j.submitted = time.Now()
+	heap.Push(&p.queue, j)
+	// A job cancelled or past its deadline while queued leaves the queue at once.
+	j.unwatch = context.AfterFunc(j.ctx, func() {
+		s.mu.Lock()
+		if j.index < 0 {
+			s.mu.Unlock()
+			return
+		}
+		heap.Remove(&p.queue, j.index)
+		p.cancelled++
+		s.mu.Unlock()
+		j.fail(context.Cause(j.ctx))
+	})
+	s.dispatch(p)
+	return nil
+}
+
+// dispatch starts queued jobs while the pool has idle workers. s.mu must be held.
+func (s *Scheduler) dispatch(p *pool) {
+	for p.running < p.workers && len(p.queue) > 0 {
+		j := heap.Pop(&p.queue).(*job)
+		if !j.unwatch() {
+			// Cancelled just now; the watcher found it gone from the queue.
+			p.cancelled++
+			go j.fail(context.Cause(j.ctx))
+			continue
+		}
+		wait := time.Since(j.submitted)
+		p.waited++
+		p.totalWait += wait
+		p.maxWait = max(p.maxWait, wait)
+		p.running++
+		s.running.Add(1)
+		go s.execute(p, j)
+	}
+}
+
+func (s *Scheduler) execute(p *pool, j *job) {
+	defer s.running.Done()
+	err := j.run()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p.running--
+	p.completed++
+	if err != nil {
+		p.failed++
+	}
+	s.dispatch(p)
+}
+
+// Close stops accepting jobs, fails every queued job with ErrClosed and waits, until
+// ctx is done, for the running ones to finish.
+func (s *Scheduler) Close(ctx context.Context) error {
+	s.mu.Lock()
+	s.closed = true
+	var dropped []*job
+	for _, p := range s.pools {
+		for len(p.queue) > 0 {
+			dropped = append(dropped, heap.Pop(&p.queue).(*job))
+			p.cancelled++
+		}
+	}
+	s.mu.Unlock()
+	for _, j := range dropped {
+		if j.unwatch() {
+			j.fail(ErrClosed)
+		} else {
+			j.fail(context.Cause(j.ctx))
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Stats is a snapshot of one model's queue and workers.
+type Stats struct {
+	Model      string
+	Workers    int           // Jobs run at once
+	Running    int           // Jobs running now
+	Queued     int           // Jobs waiting now
+	Completed  uint64        // Jobs that ran, successfully or not
+	Failed     uint64        // Jobs that ran and returned an error
+	Cancelled  uint64        // Jobs cancelled, or past their deadline, before they ran
+	Rejected   uint64        // Jobs refused because the queue was full
+	AvgWait    time.Duration // Average time from submission to start
+	MaxWait    time.Duration // Longest time from submission to start
+	OldestWait time.Duration // How long the oldest queued job has been waiting
+}
+
+// Stats reports on every model that has had jobs, sorted by model.
+func (s *Scheduler) Stats() []Stats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	stats := make([]Stats, 0, len(s.pools))
+	for _, p := range s.pools {
+		st := Stats{
+			Model:     p.model,
+			Workers:   p.workers,
+			Running:   p.running,
+			Queued:    len(p.queue),
+			Completed: p.completed,
+			Failed:    p.failed,
+			Cancelled: p.cancelled,
+			Rejected:  p.rejected,
+			MaxWait:   p.maxWait,
+		}
+		if p.waited > 0 {
+			st.AvgWait = p.totalWait / time.Duration(p.waited)
+		}
+		for _, j := range p.queue {
+			st.OldestWait = max(st.OldestWait, now.Sub(j.submitted))
+		}
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Model < stats[j].Model })
+	return stats
+}
+
+// jobHeap orders queued jobs by priority, then submission. It implements heap.Interface.
+type jobHeap []*job
+
+func (h jobHeap) Len() int { return len(h) }
+
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].priority != h[j].priority {
+		return h[i].priority > h[j].priority
+	}
+	return h[i].seq < h[j].seq
+}
+
+func (h jobHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *jobHeap) Push(x interface{}) {
+	j := x.(*job)
+	j.index = len(*h)
+	*h = append(*h, j)
+}
+
+func (h *jobHeap) Pop() interface{} {
+	old := *h
+	j := old[len(old)-1]
+	old[len(old)-1] = nil
+	j.index = -1
+	*h = old[:len(old)-1]
+	return j
+}
@@ -0,0 +1,217 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// block occupies one of a model's workers until the returned function is called.
+func block(t *testing.T, s *Scheduler, model string) func() {
+	t.Helper()
+	started := make(chan struct{})
+	gate := make(chan struct{})
+	_, err := Submit(context.Background(), s, JobOptions{Model: model}, func(ctx context.Context) (struct{}, error) {
+		close(started)
+		<-gate
+		return struct{}{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	return func() { close(gate) }
+}
+
+func stats(t *testing.T, s *Scheduler, model string) Stats {
+	t.Helper()
+	for _, st := range s.Stats() {
+		if st.Model == model {
+			return st
+		}
+	}
+	t.Fatalf("no stats for model %s", model)
+	return Stats{}
+}
+
+func TestPriorityOrder(t *testing.T) {
+	s := New(Options{})
+	unblock := block(t, s, "m")
+
+	var mu sync.Mutex
+	var order []string
+	jobs := []struct {
+		name     string
+		priority int
+	}{{"low", 1}, {"high", 5}, {"mid", 3}, {"high2", 5}, {"default", 0}, {"negative", -1}}
+	var futures []*Future[string]
+	for _, j := range jobs {
+		f, err := Submit(context.Background(), s, JobOptions{Model: "m", Priority: j.priority}, func(ctx context.Context) (string, error) {
+			mu.Lock()
+			order = append(order, j.name)
+			mu.Unlock()
+			return j.name, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		futures = append(futures, f)
+	}
+	unblock()
+	for i, f := range futures {
+		if got, err := f.Wait(); got != jobs[i].name || err != nil {
+			t.Errorf("job %s returned %q, %v", jobs[i].name, got, err)
+		}
+	}
+	want := []string{"high", "high2", "mid", "low", "default", "negative"}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("jobs ran in order %q, want %q", order, want)
+		}
+	}
+}
+
+func TestWorkersRunInParallel(t *testing.T) {
+	s := New(Options{Workers: 2})
+	var running, peak atomic.Int32
+	var futures []*Future[int]
+	for i := 0; i < 6; i++ {
+		f, _ := Submit(context.Background(), s, JobOptions{Model: "m"}, func(ctx context.Context) (int, error) {
+			n := running.Add(1)
+			for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+			}
+			time.Sleep(20 * time.Millisecond)
+			running.Add(-1)
+			return i, nil
+		})
+		futures = append(futures, f)
+	}
+	for _, f := range futures {
+		f.Wait()
+	}
+	if peak.Load() != 2 {
+		t.Errorf("%d jobs ran at once on 2 workers", peak.Load())
+	}
+	// Pools are independent: another model isn't held up by this one's queue.
+	unblock := block(t, s, "m")
+	defer unblock()
+	block(t, s, "other")()
+}
+
+func TestCancelledQueuedJobNeverRuns(t *testing.T) {
+	s := New(Options{})
+	unblock := block(t, s, "m")
+
+	var ran atomic.Bool
+	run := func(ctx context.Context) (int, error) {
+		ran.Store(true)
+		return 0, nil
+	}
+	cancelled, _ := Submit(context.Background(), s, JobOptions{Model: "m"}, run)
+	ctx, cancel := context.WithCancel(context.Background())
+	callerGone, _ := Submit(ctx, s, JobOptions{Model: "m"}, run)
+	expired, _ := Submit(context.Background(), s, JobOptions{Model: "m", Deadline: time.Now().Add(10 * time.Millisecond)}, run)
+
+	cancelled.Cancel()
+	cancel()
+	for name, tt := range map[string]struct {
+		f    *Future[int]
+		want error
+	}{
+		"Cancel":   {cancelled, context.Canceled},
+		"caller":   {callerGone, context.Canceled},
+		"deadline": {expired, context.DeadlineExceeded},
+	} {
+		select {
+		case <-tt.f.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: a cancelled job stayed queued behind the running one", name)
+		}
+		if _, err := tt.f.Wait(); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Wait returned %v, want %v", name, err, tt.want)
+		}
+	}
+	if st := stats(t, s, "m"); st.Queued != 0 || st.Cancelled != 3 {
+		t.Errorf("stats after cancelling = %+v, want nothing queued and 3 cancelled", st)
+	}
+
+	unblock()
+	last, _ := Submit(context.Background(), s, JobOptions{Model: "m"}, func(ctx context.Context) (int, error) { return 1, nil })
+	last.Wait()
+	if ran.Load() {
+		t.Error("a job cancelled while queued ran")
+	}
+}
+
+func TestStats(t *testing.T) {
+	s := New(Options{MaxQueue: 2})
+	s.SetWorkers("m", 1)
+	unblock := block(t, s, "m")
+
+	fail, _ := Submit(context.Background(), s, JobOptions{Model: "m"}, func(ctx context.Context) (int, error) {
+		return 0, errors.New("boom")
+	})
+	ok, _ := Submit(context.Background(), s, JobOptions{Model: "m"}, func(ctx context.Context) (int, error) {
+		return 1, nil
+	})
+	if _, err := Submit(context.Background(), s, JobOptions{Model: "m"}, func(ctx context.Context) (int, error) {
+		return 2, nil
+	}); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("Submit to a full queue returned %v, want ErrQueueFull", err)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	st := stats(t, s, "m")
+	if st.Workers != 1 || st.Running != 1 || st.Queued != 2 || st.Rejected != 1 || st.Completed != 0 {
+		t.Errorf("stats while blocked = %+v", st)
+	}
+	if st.OldestWait < 10*time.Millisecond {
+		t.Errorf("OldestWait = %v, want at least 10ms", st.OldestWait)
+	}
+
+	unblock()
+	fail.Wait()
+	ok.Wait()
+	// The last job's worker updates the counters just after completing its future.
+	deadline := time.Now().Add(5 * time.Second)
+	for stats(t, s, "m").Running != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	st = stats(t, s, "m")
+	if st.Running != 0 || st.Queued != 0 || st.Completed != 3 || st.Failed != 1 || st.Cancelled != 0 || st.Rejected != 1 {
+		t.Errorf("stats after the run = %+v", st)
+	}
+	if st.MaxWait < 10*time.Millisecond || st.AvgWait > st.MaxWait {
+		t.Errorf("AvgWait = %v and MaxWait = %v", st.AvgWait, st.MaxWait)
+	}
+}
+
+func TestClose(t *testing.T) {
+	s := New(Options{})
+	unblock := block(t, s, "m")
+	queued, _ := Submit(context.Background(), s, JobOptions{Model: "m"}, func(ctx context.Context) (int, error) {
+		t.Error("a job queued at Close ran")
+		return 0, nil
+	})
+
+	closed := make(chan error)
+	go func() { closed <- s.Close(context.Background()) }()
+	if _, err := queued.Wait(); !errors.Is(err, ErrClosed) {
+		t.Errorf("queued job returned %v, want ErrClosed", err)
+	}
+	if _, err := Submit(context.Background(), s, JobOptions{Model: "m"}, func(ctx context.Context) (int, error) { return 0, nil }); !errors.Is(err, ErrClosed) {
+		t.Errorf("Submit after Close returned %v, want ErrClosed", err)
+	}
+	select {
+	case <-closed:
+		t.Fatal("Close returned while a job was still running")
+	case <-time.After(20 * time.Millisecond):
+	}
+	unblock()
+	if err := <-closed; err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
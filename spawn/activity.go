@@ -0,0 +1,28 @@
+package spawn
+
+import (
+	"io"
+	"time"
+)
+
+// sessionStdin is a session's stdin. Every write counts as activity and is recorded.
+type sessionStdin struct {
+	io.WriteCloser
+	session *ShellSession
+}
+
+func (w sessionStdin) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.session.touch()
+	w.session.recording.event(CastInput, p[:n])
+	return n, err
+}
+
+func (session *ShellSession) touch() {
+	session.activity.Store(time.Now().UnixNano())
+}
+
+// LastActivity returns when the session last received input or printed output.
+func (session *ShellSession) LastActivity() time.Time {
+	return time.Unix(0, session.activity.Load())
+}
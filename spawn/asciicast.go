@@ -0,0 +1,269 @@
+package spawn
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// RecordingOptions makes a session record everything typed into and printed by its
+// process, with timestamps, as an asciicast v2 file that asciinema and its web player
+// can replay.
+type RecordingOptions struct {
+	Dir string // Directory for <session>.cast; empty disables recording
+}
+
+// Asciicast event types.
+const (
+	CastOutput = "o" // Output from the process
+	CastInput  = "i" // Input sent to the process
+	CastResize = "r" // The terminal was resized to "<cols>x<rows>"
+)
+
+// CastHeader is the first line of an asciicast v2 file.
+type CastHeader struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+	// Labels are the session's labels. They are not part of the format; players ignore them.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// CastEvent is one line of an asciicast v2 file after the header.
+type CastEvent struct {
+	Time float64 // Seconds since the start of the recording
+	Type string  // CastOutput, CastInput or CastResize
+	Data string
+}
+
+// MarshalJSON encodes the event as the [time, type, data] array the format uses.
+func (e CastEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal([]interface{}{e.Time, e.Type, e.Data})
+}
+
+// UnmarshalJSON decodes a [time, type, data] array.
+func (e *CastEvent) UnmarshalJSON(data []byte) error {
+	var fields []json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("asciicast event has %d fields, want 3", len(fields))
+	}
+	if err := json.Unmarshal(fields[0], &e.Time); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(fields[1], &e.Type); err != nil {
+		return err
+	}
+	return json.Unmarshal(fields[2], &e.Data)
+}
+
+// recorder appends events to a session's recording. Each event is written straight to
+// the file, so a recording is complete up to the moment the server dies.
+type recorder struct {
+	mu      sync.Mutex
+	file    *os.File
+	start   time.Time
+	partial map[string][]byte // The start of a UTF-8 sequence split across chunks, per event type
+}
+
+func openRecording(id string, opts RecordingOptions, header CastHeader) (*recorder, error) {
+	if opts.Dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	file, err := os.OpenFile(filepath.Join(opts.Dir, id+".cast"), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording: %w", err)
+	}
+	rec := &recorder{file: file, start: time.Now(), partial: make(map[string][]byte)}
+	header.Version = 2
+	header.Timestamp = rec.start.Unix()
+	line, _ := json.Marshal(header)
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to write recording: %w", err)
+	}
+	return rec, nil
+}
+
+// castHeader describes the session a command is about to start.
+func castHeader(cmd *exec.Cmd, opts Options) CastHeader {
+	header := CastHeader{
+		Width:  DefaultCols,
+		Height: DefaultRows,
+		Title:  strings.Join(cmd.Args, " "),
+		Labels: opts.Labels,
+		Env:    make(map[string]string),
+	}
+	for _, name := range []string{"SHELL", "TERM"} {
+		if value, ok := os.LookupEnv(name); ok {
+			header.Env[name] = value
+		}
+	}
+	for _, kv := range opts.Env {
+		if name, value, _ := strings.Cut(kv, "="); name == "SHELL" || name == "TERM" {
+			header.Env[name] = value
+		}
+	}
+	return header
+}
+
+// event appends an event. Incomplete UTF-8 at the end of data is held back until the
+// rest of it arrives, since the format stores text.
+func (r *recorder) event(kind string, data []byte) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return
+	}
+	data = append(r.partial[kind], data...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.partial[kind] = append([]byte(nil), data[cut:]...)
+	if cut == 0 {
+		return
+	}
+	line, _ := json.Marshal(CastEvent{Time: time.Since(r.start).Seconds(), Type: kind, Data: string(data[:cut])})
+	if _, err := r.file.Write(append(line, '\n')); err != nil {
+		fmt.Printf("⚠️ Failed to write recording %s: %v\n", r.file.Name(), err)
+	}
+}
+
+func (r *recorder) Close() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file != nil {
+		r.file.Close()
+		r.file = nil
+	}
+}
+
+// Recording describes a recording file in a recording directory.
+type Recording struct {
+	ID       string            `json:"id"` // The session ID
+	Title    string            `json:"title,omitempty"`
+	Started  time.Time         `json:"started"`
+	Duration float64           `json:"duration"` // Seconds, up to the last event
+	Size     int64             `json:"size"`
+	Labels   map[string]string `json:"labels,omitempty"`
+}
+
+var (
+	// ErrRecordingNotFound is returned for a recording that doesn't exist.
+	ErrRecordingNotFound = errors.New("recording not found")
+
+	recordingID = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+)
+
+// ListRecordings describes the recordings in dir, newest first.
+func ListRecordings(dir string) ([]Recording, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.cast"))
+	if err != nil {
+		return nil, err
+	}
+	recordings := make([]Recording, 0, len(paths))
+	for _, path := range paths {
+		rec, err := StatRecording(dir, strings.TrimSuffix(filepath.Base(path), ".cast"))
+		if err != nil {
+			continue // Not a recording of ours, or unreadable
+		}
+		recordings = append(recordings, rec)
+	}
+	sort.Slice(recordings, func(i, j int) bool { return recordings[i].Started.After(recordings[j].Started) })
+	return recordings, nil
+}
+
+// StatRecording describes a single recording.
+func StatRecording(dir, id string) (Recording, error) {
+	f, header, err := OpenRecording(dir, id)
+	if err != nil {
+		return Recording{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Recording{}, err
+	}
+	rec := Recording{
+		ID:      id,
+		Title:   header.Title,
+		Started: time.Unix(header.Timestamp, 0),
+		Size:    info.Size(),
+		Labels:  header.Labels,
+	}
+	// The duration is the time of the last event, which is on the last full line.
+	const tailSize = 64 << 10
+	offset := max(0, info.Size()-tailSize)
+	tail := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(tail, offset); err == nil {
+		lines := strings.Split(strings.TrimRight(string(tail), "\n"), "\n")
+		var last CastEvent
+		if json.Unmarshal([]byte(lines[len(lines)-1]), &last) == nil {
+			rec.Duration = last.Time
+		}
+	}
+	return rec, nil
+}
+
+// OpenRecording opens a recording and reads its header. The file is positioned at the
+// first event.
+func OpenRecording(dir, id string) (*os.File, CastHeader, error) {
+	var header CastHeader
+	if !recordingID.MatchString(id) {
+		return nil, header, ErrRecordingNotFound
+	}
+	f, err := os.Open(filepath.Join(dir, id+".cast"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, header, ErrRecordingNotFound
+	}
+	if err != nil {
+		return nil, header, err
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err == nil {
+		err = json.Unmarshal(line, &header)
+	}
+	if err == nil && header.Version != 2 {
+		err = fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+	if err != nil {
+		f.Close()
+		return nil, header, fmt.Errorf("bad recording %s: %w", id, err)
+	}
+	if _, err := f.Seek(int64(len(line)), io.SeekStart); err != nil {
+		f.Close()
+		return nil, header, err
+	}
+	return f, header, nil
+}
@@ -0,0 +1,79 @@
+package spawn
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// RecoveryTimeout bounds how long a session may spend draining the remainder of a
+// cancelled response before it accepts new requests again.
+var RecoveryTimeout = 60 * time.Second
+
+// SetInterruptSignal configures the signal delivered to a session's process when a request
+// waiting on it is cancelled. Processes such as llama-cli stop generating on SIGINT and
+// print their prompt again; Python workers should leave it unset so the in-flight request
+// simply runs to completion and its output is discarded.
+func (m *Manager) SetInterruptSignal(sessionID string, sig os.Signal) error {
+	session, ok := m.GetSession(sessionID)
+	if !ok {
+		return errSessionNotFound(sessionID)
+	}
+	session.mu.Lock()
+	session.interruptSignal = sig
+	session.mu.Unlock()
+	return nil
+}
+
+// Interrupt delivers the session's configured interrupt signal to its process, if any.
+func (m *Manager) Interrupt(sessionID string) error {
+	session, ok := m.GetSession(sessionID)
+	if !ok {
+		return errSessionNotFound(sessionID)
+	}
+	return session.interrupt()
+}
+
+func (session *ShellSession) interrupt() error {
+	session.mu.Lock()
+	sig := session.interruptSignal
+	session.mu.Unlock()
+	if sig == nil || session.Cmd.Process == nil {
+		return nil
+	}
+	return session.signal(sig)
+}
+
+// awaitResponse waits for a response on an open subscription and takes ownership of it and
+// of the request slot. On cancellation the process is interrupted and both are handed to a
+// background drain, so the next queued request only starts once the delimiter shows up.
+func (session *ShellSession) awaitResponse(ctx context.Context, sub *Subscription, matcher *delimiterMatcher, release func()) (string, error) {
+	output, err := awaitDelimiter(ctx, sub, matcher)
+	if err == nil || ctx.Err() == nil {
+		sub.Close()
+		release()
+		return output, err
+	}
+	session.abandon(sub, matcher, release)
+	return "", err
+}
+
+// abandon interrupts the process and drains an abandoned response in the background.
+func (session *ShellSession) abandon(sub *Subscription, matcher *delimiterMatcher, release func()) {
+	if err := session.interrupt(); err != nil {
+		fmt.Printf("⚠️ Failed to interrupt session %s: %v\n", session.ID, err)
+	}
+	// Nobody is listening for the rest of the response any more.
+	matcher.onOutput = nil
+
+	go func() {
+		defer release()
+		defer sub.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), RecoveryTimeout)
+		defer cancel()
+		if _, err := awaitDelimiter(ctx, sub, matcher); err != nil {
+			fmt.Printf("⚠️ Session %s did not recover from a cancelled request: %v\n", session.ID, err)
+		}
+	}()
+}
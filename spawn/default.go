@@ -0,0 +1,141 @@
+package spawn
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// defaultManager holds the sessions started through the package-level functions below,
+// which the model workers and llama.cpp backends use.
+var defaultManager = NewManager()
+
+// Default returns the manager behind the package-level functions.
+func Default() *Manager {
+	return defaultManager
+}
+
+// NewShell calls Manager.NewShell on the default manager.
+func NewShell() (string, error) {
+	return defaultManager.NewShell()
+}
+
+// NewShellWithCommand calls Manager.NewShellWithCommand on the default manager.
+func NewShellWithCommand(command ...string) (string, error) {
+	return defaultManager.NewShellWithCommand(command...)
+}
+
+// NewShellWithOptions calls Manager.NewShellWithOptions on the default manager.
+func NewShellWithOptions(opts Options, command ...string) (string, error) {
+	return defaultManager.NewShellWithOptions(opts, command...)
+}
+
+// GetSession calls Manager.GetSession on the default manager.
+func GetSession(sessionID string) (*ShellSession, bool) {
+	return defaultManager.GetSession(sessionID)
+}
+
+// IsRunning calls Manager.IsRunning on the default manager.
+func IsRunning(sessionID string) bool {
+	return defaultManager.IsRunning(sessionID)
+}
+
+// StartReading calls Manager.StartReading on the default manager.
+func StartReading(sessionID string, stdoutHandler func(output []byte, sessionID string, session *ShellSession), stderrHandler func(output []byte, sessionID string, session *ShellSession)) error {
+	return defaultManager.StartReading(sessionID, stdoutHandler, stderrHandler)
+}
+
+// SendCommand calls Manager.SendCommand on the default manager.
+func SendCommand(sessionID string, command string) error {
+	return defaultManager.SendCommand(sessionID, command)
+}
+
+// SendCommandAndWait calls Manager.SendCommandAndWait on the default manager.
+func SendCommandAndWait(sessionID string, command string, delimiter string) (string, error) {
+	return defaultManager.SendCommandAndWait(sessionID, command, delimiter)
+}
+
+// SendCommandAndWaitContext calls Manager.SendCommandAndWaitContext on the default manager.
+func SendCommandAndWaitContext(ctx context.Context, sessionID string, command string, delimiter string) (string, error) {
+	return defaultManager.SendCommandAndWaitContext(ctx, sessionID, command, delimiter)
+}
+
+// SendCommandAndStreamContext calls Manager.SendCommandAndStreamContext on the default manager.
+func SendCommandAndStreamContext(ctx context.Context, sessionID string, command string, delimiter string, onOutput func([]byte)) (string, error) {
+	return defaultManager.SendCommandAndStreamContext(ctx, sessionID, command, delimiter, onOutput)
+}
+
+// SendCommandFromReady calls Manager.SendCommandFromReady on the default manager.
+func SendCommandFromReady(sessionID string, command string, delimiter string) (string, error) {
+	return defaultManager.SendCommandFromReady(sessionID, command, delimiter)
+}
+
+// SendCommandFromReadyContext calls Manager.SendCommandFromReadyContext on the default manager.
+func SendCommandFromReadyContext(ctx context.Context, sessionID string, command string, delimiter string) (string, error) {
+	return defaultManager.SendCommandFromReadyContext(ctx, sessionID, command, delimiter)
+}
+
+// WaitForString calls Manager.WaitForString on the default manager.
+func WaitForString(sessionID string, target string, timeout time.Duration) error {
+	return defaultManager.WaitForString(sessionID, target, timeout)
+}
+
+// WaitForStringContext calls Manager.WaitForStringContext on the default manager.
+func WaitForStringContext(ctx context.Context, sessionID string, target string) error {
+	return defaultManager.WaitForStringContext(ctx, sessionID, target)
+}
+
+// Subscribe calls Manager.Subscribe on the default manager.
+func Subscribe(sessionID string, stream Stream) (*Subscription, error) {
+	return defaultManager.Subscribe(sessionID, stream)
+}
+
+// Follow calls Manager.Follow on the default manager.
+func Follow(sessionID string, stream Stream, offset int64) (*Subscription, int64, error) {
+	return defaultManager.Follow(sessionID, stream, offset)
+}
+
+// Resize calls Manager.Resize on the default manager.
+func Resize(sessionID string, rows, cols uint16) error {
+	return defaultManager.Resize(sessionID, rows, cols)
+}
+
+// Acquire calls Manager.Acquire on the default manager.
+func Acquire(ctx context.Context, sessionID string) (func(), error) {
+	return defaultManager.Acquire(ctx, sessionID)
+}
+
+// SetMaxQueueDepth calls Manager.SetMaxQueueDepth on the default manager.
+func SetMaxQueueDepth(sessionID string, depth int) error {
+	return defaultManager.SetMaxQueueDepth(sessionID, depth)
+}
+
+// QueueDepth calls Manager.QueueDepth on the default manager.
+func QueueDepth(sessionID string) int {
+	return defaultManager.QueueDepth(sessionID)
+}
+
+// SetInterruptSignal calls Manager.SetInterruptSignal on the default manager.
+func SetInterruptSignal(sessionID string, sig os.Signal) error {
+	return defaultManager.SetInterruptSignal(sessionID, sig)
+}
+
+// Interrupt calls Manager.Interrupt on the default manager.
+func Interrupt(sessionID string) error {
+	return defaultManager.Interrupt(sessionID)
+}
+
+// Exited calls Manager.Exited on the default manager.
+func Exited(sessionID string) <-chan error {
+	return defaultManager.Exited(sessionID)
+}
+
+// WaitExit calls Manager.WaitExit on the default manager.
+func WaitExit(sessionID string, timeout time.Duration) *ExitError {
+	return defaultManager.WaitExit(sessionID, timeout)
+}
+
+// CloseSession calls Manager.CloseSession on the default manager.
+func CloseSession(sessionID string) error {
+	return defaultManager.CloseSession(sessionID)
+}
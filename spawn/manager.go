@@ -1,12 +1,16 @@
 package spawn
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"os"
 	"os/exec"
-	"strings"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	uuid "github.com/google/uuid"
@@ -15,24 +19,77 @@ import (
 // ShellSession represents an active, interactive shell process.
 type ShellSession struct {
 	ID        string
+	Labels    map[string]string // Set at creation, e.g. {"kind": "model", "model": "blip"}; read-only
 	Cmd       *exec.Cmd
 	Stdin     io.WriteCloser
 	Stdout    io.ReadCloser // Pipe for standard output
 	Stderr    io.ReadCloser // Pipe for standard error
 	CreatedAt time.Time
-	OutputBuf bytes.Buffer // Buffer for stdout
-	StderrBuf bytes.Buffer // Buffer for stderr
-	mu        sync.Mutex   // Mutex to protect this session's buffers
+	OutputBuf *RingBuffer // The most recent stdout
+	StderrBuf *RingBuffer // The most recent stderr
+	mu        sync.Mutex  // Mutex to protect this session's buffers
+
+	outputMark  int64                    // Stdout offset where the current command's output starts
+	transcripts map[Stream]*rotatingFile // Per-stream transcript files, if enabled
+	recording   *recorder                // Asciicast recording of the session, if enabled
+	activity    atomic.Int64             // Unix nanoseconds of the last input or output
+
+	interruptSignal os.Signal     // Sent to the process when a pending request is cancelled
+	queue           *requestQueue // Serializes requests so only one is in flight at a time
+
+	done chan struct{} // Closed by the reaper once the process has exited
+	exit *ExitError    // How the process ended; set before done is closed
+
+	opts      Options          // How the process was started and how it is stopped
+	resources processResources // Platform resources to release once the process is gone
+	pty       *os.File         // The terminal's master if the process runs on a PTY; also Stdin and Stdout
+
+	subMu       sync.Mutex                            // Protects the subscriber registry below
+	subscribers map[Stream]map[*Subscription]struct{} // Live subscribers per stream
+	streamEnded map[Stream]bool                       // Streams whose reader has hit EOF
 }
 
-var (
-	mu       sync.Mutex
-	sessions = make(map[string]*ShellSession)
+// Well-known session labels.
+const (
+	LabelKind  = "kind"  // What the session is for: KindModel or KindShell
+	LabelModel = "model" // The name of the model a session serves
+	LabelOwner = "owner" // Who started the session
+)
+
+// Values of LabelKind.
+const (
+	KindModel = "model" // A model worker or llama.cpp process
+	KindShell = "shell" // An interactive shell opened through the HTTP API
 )
 
+// Manager owns a set of sessions. Sessions started through one manager are invisible to
+// every other, so independent engines, or the shell API and the model workers, can't
+// reach each other's processes. The package-level functions use a default manager.
+type Manager struct {
+	mu       sync.Mutex
+	sessions map[string]*ShellSession
+}
+
+// NewManager creates an empty session manager.
+func NewManager() *Manager {
+	return &Manager{sessions: make(map[string]*ShellSession)}
+}
+
+func (m *Manager) register(session *ShellSession) {
+	m.mu.Lock()
+	m.sessions[session.ID] = session
+	m.mu.Unlock()
+	go session.reap()
+}
+
 // NewShell creates, starts, and registers a new interactive bash session.
 // It returns the unique session ID for future interactions.
-func NewShell() (string, error) {
+func (m *Manager) NewShell() (string, error) {
+	return m.NewShellWithLabels(nil)
+}
+
+// NewShellWithLabels is like NewShell but attaches labels to the session.
+func (m *Manager) NewShellWithLabels(labels map[string]string) (string, error) {
 	cmd := exec.Command("bash", "-i")
 
 	stdin, err := cmd.StdinPipe()
@@ -53,18 +110,21 @@ func NewShell() (string, error) {
 
 	session := &ShellSession{
 		ID:        id,
+		Labels:    maps.Clone(labels),
 		Cmd:       cmd,
 		Stdin:     stdin,
 		Stdout:    stdout,
 		CreatedAt: time.Now(),
-		OutputBuf: *bytes.NewBuffer(nil),
+		OutputBuf: NewRingBuffer(DefaultBufferSize),
+		queue:     newRequestQueue(),
 		// Stderr is not captured for basic shells, only for command shells.
-		StderrBuf: *bytes.NewBuffer(nil),
+		StderrBuf: NewRingBuffer(DefaultBufferSize),
+		done:      make(chan struct{}),
 	}
+	session.Stdin = sessionStdin{stdin, session}
+	session.touch()
 
-	mu.Lock()
-	sessions[session.ID] = session
-	mu.Unlock()
+	m.register(session)
 
 	fmt.Printf("🧠 New shell started: %s\n", id)
 	return id, nil
@@ -73,154 +133,287 @@ func NewShell() (string, error) {
 // NewShellWithCommand creates, starts, and registers a new interactive session with a custom command.
 // It is used for launching persistent Python model scripts.
 // It returns the unique session ID for future interactions.
-func NewShellWithCommand(command ...string) (string, error) {
+func (m *Manager) NewShellWithCommand(command ...string) (string, error) {
 	if len(command) == 0 {
 		return "", fmt.Errorf("NewShellWithCommand requires a command to execute")
 	}
-	cmd := exec.Command(command[0], command[1:]...)
+	return m.start(exec.Command(command[0], command[1:]...), Options{})
+}
 
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return "", err
+// start starts a command with its stdio connected to a new session.
+func (m *Manager) start(cmd *exec.Cmd, opts Options) (string, error) {
+	var (
+		stdin          io.WriteCloser
+		stdout, stderr io.ReadCloser
+		pty, tty       *os.File
+		err            error
+	)
+	if opts.PTY {
+		// The process reads and writes the terminal; we talk to it through the master,
+		// which carries its stdout and stderr interleaved as a terminal would show them.
+		if pty, tty, err = openPTY(); err != nil {
+			return "", err
+		}
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+		stdin, stdout = pty, pty
+	} else {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return "", err
+		}
+		if stdout, err = cmd.StdoutPipe(); err != nil {
+			return "", err
+		}
+		// Create a separate pipe for stderr to distinguish errors from normal output.
+		if stderr, err = cmd.StderrPipe(); err != nil {
+			return "", err
+		}
+	}
+	closePTY := func() {
+		if pty != nil {
+			pty.Close()
+			tty.Close()
+		}
 	}
 
-	stdout, err := cmd.StdoutPipe()
+	id := uuid.New().String()
+	transcripts, err := openTranscripts(id, opts.Transcript)
 	if err != nil {
+		closePTY()
 		return "", err
 	}
-
-	// Create a separate pipe for stderr to distinguish errors from normal output.
-	stderr, err := cmd.StderrPipe()
+	recording, err := openRecording(id, opts.Recording, castHeader(cmd, opts))
 	if err != nil {
+		closePTY()
+		closeTranscripts(transcripts)
 		return "", err
 	}
-
+	resources, err := prepareProcess(cmd, id, opts)
+	if err != nil {
+		closePTY()
+		closeTranscripts(transcripts)
+		recording.Close()
+		return "", fmt.Errorf("failed to prepare process: %w", err)
+	}
 	if err := cmd.Start(); err != nil {
+		closePTY()
+		closeTranscripts(transcripts)
+		recording.Close()
+		resources.release()
 		return "", err
 	}
+	if tty != nil {
+		// Only the process holds the terminal now, so reads from the master end when it exits.
+		tty.Close()
+	}
+	resources.started(cmd.Process.Pid)
+	// Limits that can't be set before exec are applied right after it, before the
+	// process has had time to load anything.
+	if err := applyLimits(cmd.Process.Pid, opts); err != nil {
+		cmd.Process.Kill()
+		cmd.Process.Wait()
+		closePTY()
+		closeTranscripts(transcripts)
+		recording.Close()
+		resources.release()
+		return "", fmt.Errorf("failed to apply process limits: %w", err)
+	}
 
-	id := uuid.New().String()
 	session := &ShellSession{
 		ID:        id,
+		Labels:    maps.Clone(opts.Labels),
 		Cmd:       cmd,
 		Stdin:     stdin,
 		Stdout:    stdout,
 		Stderr:    stderr,
 		CreatedAt: time.Now(),
-		OutputBuf: *bytes.NewBuffer(nil),
-		StderrBuf: *bytes.NewBuffer(nil),
+		OutputBuf: NewRingBuffer(opts.BufferSize),
+		queue:     newRequestQueue(),
+		StderrBuf: NewRingBuffer(opts.BufferSize),
+		done:      make(chan struct{}),
+		opts:      opts,
+		resources: resources,
+		pty:       pty,
+
+		transcripts: transcripts,
+		recording:   recording,
 	}
+	session.Stdin = sessionStdin{stdin, session}
+	session.touch()
 
-	mu.Lock()
-	sessions[id] = session
-	mu.Unlock()
+	m.register(session)
 
-	fmt.Printf("🧠 New command shell started: %s with command %v\n", id, command)
+	fmt.Printf("🧠 New command shell started: %s with command %v\n", id, cmd.Args)
 	return id, nil
 }
 
 // SendCommand writes a command string to the Stdin of a specific shell session.
 // The command should not include a newline character, as it is appended automatically.
-func SendCommand(sessionID string, command string) error {
-	mu.Lock()
-	session, ok := sessions[sessionID] // Use package-level sessions
-	mu.Unlock()
+// The write waits its turn behind any in-flight request so it cannot interleave with one.
+func (m *Manager) SendCommand(sessionID string, command string) error {
+	session, ok := m.GetSession(sessionID)
 
 	if !ok {
 		return fmt.Errorf("shell session %s not found", sessionID)
 	}
 
+	release, err := session.queue.acquire(context.Background())
+	if err != nil {
+		return err
+	}
+	defer release()
+
 	// Append a newline to ensure the shell executes the command
 	commandWithNewline := command + "\n"
 
 	// Write the command bytes to the shell's input pipe
-	_, err := session.Stdin.Write([]byte(commandWithNewline))
+	_, err = session.Stdin.Write([]byte(commandWithNewline))
 
 	return err
 }
 
 // SendCommandAndWait sends a command and waits for a specific delimiter in the response.
-// It polls the session's output buffer until the delimiter is found or a timeout occurs.
-func SendCommandAndWait(sessionID string, command string, delimiter string) (string, error) {
-	mu.Lock()
-	session, ok := sessions[sessionID]
-	mu.Unlock()
+// It is a convenience wrapper around SendCommandAndWaitContext with a 5-minute timeout.
+func (m *Manager) SendCommandAndWait(sessionID string, command string, delimiter string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Second)
+	defer cancel()
+	return m.SendCommandAndWaitContext(ctx, sessionID, command, delimiter)
+}
+
+// SendCommandAndWaitContext sends a command and waits for a specific delimiter in the response.
+// The session's stdout is subscribed to before the command is written, so the waiter wakes
+// as soon as the chunk containing the delimiter is read. It returns everything printed
+// before the first occurrence of the delimiter.
+//
+// Requests on the same session are served one at a time in FIFO order; if too many are
+// already waiting, ErrSessionBusy is returned. If ctx is cancelled before the delimiter
+// arrives, the session is interrupted (see SetInterruptSignal) and the rest of the abandoned
+// response is drained in the background before the next request is let through.
+func (m *Manager) SendCommandAndWaitContext(ctx context.Context, sessionID string, command string, delimiter string) (string, error) {
+	session, ok := m.GetSession(sessionID)
 
 	if !ok {
 		return "", fmt.Errorf("shell session %s not found", sessionID)
 	}
 
+	release, err := session.queue.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+
 	// Clear the buffer before sending a new command to ensure we only capture the new output.
-	mu.Lock()
-	session.OutputBuf.Reset()
-	mu.Unlock()
+	session.ResetOutput()
+
+	sub := session.subscribe(StreamStdout)
 
 	// Append a newline to ensure the shell executes the command
 	commandWithNewline := command + "\n"
 
-	// Wait for a few milliseconds before writing the next command
-	time.Sleep(500 * time.Millisecond)
+	// Write the command bytes to the shell's input pipe
+	_, err = session.Stdin.Write([]byte(commandWithNewline))
+	if err != nil {
+		sub.Close()
+		release()
+		return "", err
+	}
 
+	return session.awaitResponse(ctx, sub, newDelimiterMatcher(delimiter), release)
+}
 
-	// Write the command bytes to the shell's input pipe
-	_, err := session.Stdin.Write([]byte(commandWithNewline))
+// SendCommandAndStreamContext is like SendCommandAndWaitContext but also hands the response
+// to onOutput while it is being printed. Output is held back only while it could still be
+// the start of the delimiter, so onOutput receives exactly the returned string, in order,
+// and never any part of the delimiter. onOutput runs on the caller's goroutine and is not
+// called again once SendCommandAndStreamContext has returned.
+func (m *Manager) SendCommandAndStreamContext(ctx context.Context, sessionID string, command string, delimiter string, onOutput func([]byte)) (string, error) {
+	session, ok := m.GetSession(sessionID)
+
+	if !ok {
+		return "", fmt.Errorf("shell session %s not found", sessionID)
+	}
+
+	release, err := session.queue.acquire(ctx)
 	if err != nil {
 		return "", err
 	}
 
-	// Wait for the response by polling the buffer until the delimiter is found.
-	// This is a simple polling mechanism. For high-performance scenarios,
-	// a condition variable or channel-based approach might be more efficient.
-	timeout := time.After(300 * time.Second) // 5-minute timeout for the command to respond
-	tick := time.NewTicker(100 * time.Millisecond)
-	defer tick.Stop()
+	session.ResetOutput()
+	sub := session.subscribe(StreamStdout)
 
-	for {
-		select {
-		case <-timeout:
-			return "", fmt.Errorf("timed out waiting for response delimiter: %s", delimiter)
-		case <-tick.C:
-			if output, ok := checkBufferForDelimiter(session, delimiter); ok {
-				return output, nil
-			}
-		}
+	if _, err := session.Stdin.Write([]byte(command + "\n")); err != nil {
+		sub.Close()
+		release()
+		return "", err
 	}
+
+	matcher := newDelimiterMatcher(delimiter)
+	matcher.onOutput = onOutput
+	return session.awaitResponse(ctx, sub, matcher, release)
 }
 
 // SendCommandFromReady sends a command after the initial "Ready" state and waits for a delimiter.
+// It is a convenience wrapper around SendCommandFromReadyContext with a 3-minute timeout.
+func (m *Manager) SendCommandFromReady(sessionID string, command string, delimiter string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 180*time.Second)
+	defer cancel()
+	return m.SendCommandFromReadyContext(ctx, sessionID, command, delimiter)
+}
+
+// SendCommandFromReadyContext sends a command after the initial "Ready" state and waits for a delimiter.
 // This is specifically for processes that print a ready prompt (like '>') and then wait for the first command.
 // It captures the output produced *after* the command is sent.
-func SendCommandFromReady(sessionID string, command string, delimiter string) (string, error) {
-	mu.Lock()
-	session, ok := sessions[sessionID]
-	mu.Unlock()
+func (m *Manager) SendCommandFromReadyContext(ctx context.Context, sessionID string, command string, delimiter string) (string, error) {
+	session, ok := m.GetSession(sessionID)
 
 	if !ok {
 		return "", fmt.Errorf("shell session %s not found", sessionID)
 	}
 
+	release, err := session.queue.acquire(ctx)
+	if err != nil {
+		return "", err
+	}
+
 	// Do not reset the buffer, as it contains the initial ready prompt.
-	// Instead, we will capture the output from this point forward.
+	// Instead, we subscribe and only match against the output from this point forward.
+	sub := session.subscribe(StreamStdout)
 
 	commandWithNewline := command + "\n"
-	_, err := session.Stdin.Write([]byte(commandWithNewline))
+	_, err = session.Stdin.Write([]byte(commandWithNewline))
 	if err != nil {
+		sub.Close()
+		release()
 		return "", err
 	}
 
-	// Wait for the response by polling the buffer until the delimiter is found.
-	timeout := time.After(180 * time.Second) // 3-minute timeout for the command to respond
-	tick := time.NewTicker(200 * time.Millisecond)
-	defer tick.Stop()
+	return session.awaitResponse(ctx, sub, newDelimiterMatcher(delimiter), release)
+}
 
+// awaitDelimiter consumes chunks from a subscription until the matcher finds its delimiter,
+// the stream ends, the process exits, or ctx is done.
+func awaitDelimiter(ctx context.Context, sub *Subscription, matcher *delimiterMatcher) (string, error) {
 	for {
 		select {
-		case <-timeout:
-			return "", fmt.Errorf("timed out waiting for response delimiter: %s", delimiter)
-		case <-tick.C:
-			if output, ok := checkBufferForDelimiter(session, delimiter); ok {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return "", fmt.Errorf("timed out waiting for response delimiter %s: %w", matcher.delimiter, ctx.Err())
+			}
+			return "", ctx.Err()
+		case <-sub.Ready():
+			chunk, open := sub.Next()
+			if output, ok := matcher.Feed(chunk); ok {
+				return output, nil
+			}
+			if !open {
+				return "", sub.session.closedError(matcher.delimiter)
+			}
+		case <-sub.session.done:
+			// The reaper lets the output drain before it closes done, so whatever is queued
+			// is all the process will ever print.
+			chunk, _ := sub.Next()
+			if output, ok := matcher.Feed(chunk); ok {
 				return output, nil
 			}
+			return "", sub.session.exit
 		}
 	}
 }
@@ -261,11 +454,9 @@ func InfoOutputHandler(output []byte, sessionID string, session *ShellSession) {
 
 // StartReading launches a goroutine to continuously read from the shell's Stdout pipe.
 // It calls the provided outputHandler for each chunk of data read until the pipe is closed.
-func StartReading(sessionID string, stdoutHandler func(output []byte, sessionID string, session *ShellSession), stderrHandler func(output []byte, sessionID string, session *ShellSession)) error {
+func (m *Manager) StartReading(sessionID string, stdoutHandler func(output []byte, sessionID string, session *ShellSession), stderrHandler func(output []byte, sessionID string, session *ShellSession)) error {
 	// Use a buffer for efficient reading
-	mu.Lock()
-	session, ok := sessions[sessionID]
-	mu.Unlock()
+	session, ok := m.GetSession(sessionID)
 
 	if !ok {
 		return fmt.Errorf("session %s not found for starting reader", sessionID)
@@ -274,16 +465,27 @@ func StartReading(sessionID string, stdoutHandler func(output []byte, sessionID
 	buf := make([]byte, 1024)
 	// Launch the dedicated reader goroutine
 	go func() {
+		var offset int64
 		defer session.Stdout.Close()
+		defer session.endStream(StreamStdout)
 		for {
 			// Read blocks until data is available or the pipe closes
 			n, err := session.Stdout.Read(buf)
 			if n > 0 {
+				session.transcribe(StreamStdout, buf[:n])
+				session.recording.event(CastOutput, buf[:n])
+				session.touch()
 				stdoutHandler(buf[:n], session.ID, session)
+				session.publish(StreamStdout, buf[:n], offset)
+				offset += int64(n)
 			}
 
 			if err != nil {
 				// io.EOF is expected when the shell exits normally
+				if session.pty != nil && (ptyClosed(err) || errors.Is(err, os.ErrClosed)) {
+					// A terminal ends with EIO rather than EOF, or is closed by CloseSession.
+					err = io.EOF
+				}
 				if err != io.EOF {
 					fmt.Printf("Error reading from session %s: %v\n", session.ID, err)
 				} else {
@@ -302,11 +504,18 @@ func StartReading(sessionID string, stdoutHandler func(output []byte, sessionID
 	if session.Stderr != nil {
 		go func() {
 			defer session.Stderr.Close()
+			defer session.endStream(StreamStderr)
 			errBuf := make([]byte, 1024)
+			var offset int64
 			for {
 				n, err := session.Stderr.Read(errBuf)
 				if n > 0 {
+					session.transcribe(StreamStderr, errBuf[:n])
+					session.recording.event(CastOutput, errBuf[:n])
+					session.touch()
 					stderrHandler(errBuf[:n], session.ID, session)
+					session.publish(StreamStderr, errBuf[:n], offset)
+					offset += int64(n)
 				}
 
 				if err != nil {
@@ -323,13 +532,15 @@ func StartReading(sessionID string, stdoutHandler func(output []byte, sessionID
 	return nil
 }
 
-// CloseSession sends an 'exit' command to the shell, closes its pipes,
-// and waits for the process to terminate, releasing all resources.
-func CloseSession(sessionID string) error {
-	mu.Lock()
-	session, ok := sessions[sessionID] // Use package-level sessions
-	delete(sessions, sessionID) // Remove from the map
-	mu.Unlock()
+// CloseSession closes the shell's stdin and waits for the process to terminate, releasing
+// all resources. A process that ignores EOF is sent SIGTERM and then SIGKILL, each after
+// the session's stop timeout (see Options.StopTimeout). It returns the session's
+// *ExitError if the process did not exit cleanly.
+func (m *Manager) CloseSession(sessionID string) error {
+	m.mu.Lock()
+	session, ok := m.sessions[sessionID]
+	delete(m.sessions, sessionID) // Remove from the map
+	m.mu.Unlock()
 
 	if !ok {
 		return fmt.Errorf("shell session %s not found", sessionID)
@@ -340,78 +551,165 @@ func CloseSession(sessionID string) error {
 	// This is the standard and most robust way to signal termination.
 	session.Stdin.Close()
 
-	// 2. Wait for the command to finish and release resources
-	// This blocks until the shell process terminates
-	return session.Cmd.Wait()
+	// 2. Wait for the reaper to see the process exit, escalating if it takes too long.
+	session.stop()
+	session.resources.release()
+	closeTranscripts(session.transcripts)
+	if session.exit.Success() {
+		return nil
+	}
+	return session.exit
+}
+
+// ResetOutput starts a new command's output: Output no longer returns anything printed
+// so far. The earlier output stays in the buffer for OutputSince.
+func (session *ShellSession) ResetOutput() {
+	session.mu.Lock()
+	session.outputMark = session.OutputBuf.Offset()
+	session.mu.Unlock()
+}
+
+// Output returns a copy of the session's stdout since the last ResetOutput.
+func (session *ShellSession) Output() []byte {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	output, _ := session.OutputBuf.Since(session.outputMark)
+	return output
+}
+
+// OutputSince returns what is still buffered of a stream from offset on, and the offset
+// of its first byte, which is past offset if the output in between has been dropped.
+// Pass the returned offset plus the length of the data to resume after it.
+func (session *ShellSession) OutputSince(stream Stream, offset int64) ([]byte, int64) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.outputSince(stream, offset)
+}
+
+func (session *ShellSession) outputSince(stream Stream, offset int64) ([]byte, int64) {
+	if stream == StreamStderr {
+		return session.StderrBuf.Since(offset)
+	}
+	return session.OutputBuf.Since(offset)
 }
 
 // GetSession safely retrieves a session by its ID.
-func GetSession(sessionID string) (*ShellSession, bool) {
-	mu.Lock()
-	session, ok := sessions[sessionID] // Use package-level sessions
-	mu.Unlock()
+func (m *Manager) GetSession(sessionID string) (*ShellSession, bool) {
+	m.mu.Lock()
+	session, ok := m.sessions[sessionID]
+	m.mu.Unlock()
 	return session, ok
 }
 
+// List returns the sessions whose labels include every key and value of selector, oldest
+// first. A nil selector lists every session.
+func (m *Manager) List(selector map[string]string) []*ShellSession {
+	var matched []*ShellSession
+	m.Range(func(session *ShellSession) bool {
+		if session.Matches(selector) {
+			matched = append(matched, session)
+		}
+		return true
+	})
+	return matched
+}
+
+// Range calls fn for every session, oldest first, until fn returns false. It works on a
+// snapshot, so fn may start or close sessions.
+func (m *Manager) Range(fn func(session *ShellSession) bool) {
+	m.mu.Lock()
+	snapshot := make([]*ShellSession, 0, len(m.sessions))
+	for _, session := range m.sessions {
+		snapshot = append(snapshot, session)
+	}
+	m.mu.Unlock()
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].CreatedAt.Before(snapshot[j].CreatedAt)
+	})
+	for _, session := range snapshot {
+		if !fn(session) {
+			return
+		}
+	}
+}
+
+// CloseAll closes every session concurrently and waits for them to exit. It returns the
+// errors of sessions that did not exit cleanly.
+func (m *Manager) CloseAll() error {
+	sessions := m.List(nil)
+	errs := make([]error, len(sessions))
+	var wg sync.WaitGroup
+	for i, session := range sessions {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = m.CloseSession(session.ID)
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
+
+// Matches reports whether the session's labels include every key and value of selector.
+func (session *ShellSession) Matches(selector map[string]string) bool {
+	for k, v := range selector {
+		if session.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
 // IsRunning checks if the underlying process for a session is still active.
-func IsRunning(sessionID string) bool {
-	session, ok := GetSession(sessionID)
+func (m *Manager) IsRunning(sessionID string) bool {
+	session, ok := m.GetSession(sessionID)
 	if !ok {
 		return false
 	}
+	return session.Exit() == nil
+}
 
-	// Check if the underlying process is still running.
-	// If Cmd.ProcessState is nil, it usually means the command is still running.
-	return session.Cmd.ProcessState == nil || !session.Cmd.ProcessState.Exited()
+// WaitForString waits until a specific string appears in the session's output or a timeout
+// is reached. This is useful for waiting for a "Ready" signal from a script.
+func (m *Manager) WaitForString(sessionID string, target string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return m.WaitForStringContext(ctx, sessionID, target)
 }
 
-// WaitForString polls the session's output buffer until a specific string is found
-// or a timeout is reached. This is useful for waiting for a "Ready" signal from a script.
-func WaitForString(sessionID string, target string, timeout time.Duration) error {
-	startTime := time.Now()
-	for {
-		if time.Since(startTime) > timeout {
-			mu.Lock()
-			session, ok := sessions[sessionID]
-			var output, stderrOutput string
-			if ok {
-				output = session.OutputBuf.String()
-				stderrOutput = session.StderrBuf.String()
-			}
-			mu.Unlock()
-			return fmt.Errorf("timed out waiting for string '%s'.\nLast stdout: %s\nLast stderr: %s", target, output, stderrOutput)
-		}
+// WaitForStringContext waits until a specific string appears in the session's output or ctx
+// is done. Output already buffered is checked first, then new chunks are matched as the
+// reader publishes them.
+func (m *Manager) WaitForStringContext(ctx context.Context, sessionID string, target string) error {
+	session, ok := m.GetSession(sessionID)
+	if !ok {
+		return fmt.Errorf("session %s not found while waiting for string", sessionID)
+	}
 
-		time.Sleep(200 * time.Millisecond) // Poll every 200ms
+	// Seed the matcher with the buffered output and subscribe from just past it, as
+	// Follow does. A chunk may be buffered before it is published; the subscription
+	// skips what the matcher has already seen, and receives what comes after it.
+	matcher := newDelimiterMatcher(target)
+	session.mu.Lock()
+	buffered, start := session.OutputBuf.Since(session.outputMark)
+	_, found := matcher.Feed(buffered)
+	sub := session.subscribeAt(StreamStdout, start+int64(len(buffered)), nil)
+	session.mu.Unlock()
+	defer sub.Close()
 
-		mu.Lock()
-		session, ok := sessions[sessionID]
-		if !ok {
-			mu.Unlock()
-			return fmt.Errorf("session %s not found while waiting for string", sessionID)
-		}
+	if found {
+		return nil
+	}
+	if _, err := awaitDelimiter(ctx, sub, matcher); err != nil {
+		session.mu.Lock()
 		output := session.OutputBuf.String()
-		mu.Unlock()
-
-		if strings.Contains(output, target) {
-			return nil
-		}
+		stderrOutput := session.StderrBuf.String()
+		session.mu.Unlock()
+		return fmt.Errorf("failed waiting for string '%s': %w\nLast stdout: %s\nLast stderr: %s", target, err, output, stderrOutput)
 	}
+	return nil
 }
 
-func checkBufferForDelimiter(session *ShellSession, delimiter string) (string, bool) {
-	session.mu.Lock()
-	defer session.mu.Unlock()
-	output := session.OutputBuf.String()
-	if strings.Contains(output, delimiter) {
-		parts := strings.Split(output, delimiter)
-		// If delimiter is found, there will be at least two parts.
-		// The content we want is everything *before* the last occurrence of the delimiter.
-		if len(parts) > 1 {
-			// Join all but the last part, in case the output itself contains the delimiter
-			content := strings.Join(parts[:len(parts)-1], delimiter)
-			return content, true
-		}
-	}
-	return "", false
+func errSessionNotFound(sessionID string) error {
+	return fmt.Errorf("shell session %s not found", sessionID)
 }
@@ -0,0 +1,129 @@
+package spawn
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// DefaultStopTimeout is how long CloseSession waits for a process to exit after closing
+// its stdin, and again after SIGTERM, before escalating.
+var DefaultStopTimeout = 10 * time.Second
+
+// Options controls how a session's process is started, confined and stopped. The zero
+// value starts the process like NewShellWithCommand always has.
+type Options struct {
+	Labels map[string]string // Attached to the session for Manager.List; see LabelKind
+	Dir    string            // Working directory; empty uses the server's
+	Env    []string          // "KEY=value" entries added to the server's environment
+
+	// NewProcessGroup starts the process in a process group of its own, so it does not
+	// receive the server's terminal signals and is stopped together with its children.
+	NewProcessGroup bool
+	// StopTimeout is how long CloseSession waits after closing stdin before sending
+	// SIGTERM, and after SIGTERM before sending SIGKILL. Zero uses DefaultStopTimeout and
+	// a negative value waits for the process forever.
+	StopTimeout time.Duration
+
+	Limits Limits        // Resource limits applied with setrlimit semantics
+	Cgroup CgroupOptions // Optional cgroup v2 memory and CPU caps
+
+	Nice int   // Scheduling priority, from -20 (highest) to 19 (lowest)
+	CPUs []int // CPUs the process may run on; empty allows all
+
+	// PTY runs the process on a pseudo-terminal, so shells show prompts and colours and
+	// full-screen programs work. Stdout and stderr then arrive together on StreamStdout.
+	// The process leads a session and process group of its own. Linux only.
+	PTY bool
+	// Sandbox confines the process to a restricted environment.
+	Sandbox Sandbox
+
+	BufferSize int               // Bytes of each output stream kept in memory; 0 uses DefaultBufferSize
+	Transcript TranscriptOptions // Optional on-disk copy of everything the process prints
+	Recording  RecordingOptions  // Optional timed recording of the session's input and output
+}
+
+// Limits are per-process resource limits. Zero fields are left unlimited.
+type Limits struct {
+	AddressSpace uint64        // Bytes of virtual memory (RLIMIT_AS)
+	CPUTime      time.Duration // CPU time before the process is killed (RLIMIT_CPU)
+	OpenFiles    uint64        // Open file descriptors (RLIMIT_NOFILE)
+}
+
+func (l Limits) isZero() bool {
+	return l == Limits{}
+}
+
+// DefaultCgroupParent is where per-session cgroups are created when CgroupOptions.Parent
+// is empty. The server must be allowed to create cgroups there, e.g. through systemd's
+// Delegate=yes.
+var DefaultCgroupParent = "/sys/fs/cgroup/llm-cortex"
+
+// CgroupOptions places the process in a cgroup v2 of its own under Parent.
+type CgroupOptions struct {
+	Parent    string  // Cgroup directory to create the session's cgroup in
+	MemoryMax uint64  // Bytes of memory before the kernel reclaims or OOM-kills (memory.max)
+	CPUs      float64 // CPU bandwidth in cores, e.g. 1.5 (cpu.max)
+}
+
+func (c CgroupOptions) enabled() bool {
+	return c.MemoryMax > 0 || c.CPUs > 0
+}
+
+// NewShellWithOptions is like NewShellWithCommand but starts the process with opts.
+func (m *Manager) NewShellWithOptions(opts Options, command ...string) (string, error) {
+	if len(command) == 0 {
+		return "", fmt.Errorf("NewShellWithOptions requires a command to execute")
+	}
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.Dir = opts.Dir
+	if len(opts.Env) > 0 {
+		cmd.Env = append(os.Environ(), opts.Env...)
+	}
+	return m.start(cmd, opts)
+}
+
+// stopTimeout resolves the session's StopTimeout; a negative result means wait forever.
+func (session *ShellSession) stopTimeout() time.Duration {
+	if session.opts.StopTimeout == 0 {
+		return DefaultStopTimeout
+	}
+	return session.opts.StopTimeout
+}
+
+// stop waits for the process to exit after its stdin was closed, escalating to SIGTERM
+// and then SIGKILL if it doesn't.
+func (session *ShellSession) stop() {
+	timeout := session.stopTimeout()
+	if timeout < 0 {
+		<-session.done
+		return
+	}
+	if session.waitDone(timeout) {
+		return
+	}
+	fmt.Printf("⚠️ Session %s did not exit after stdin closed; sending SIGTERM\n", session.ID)
+	if err := session.terminate(); err != nil {
+		fmt.Printf("⚠️ Failed to terminate session %s: %v\n", session.ID, err)
+	}
+	if session.waitDone(timeout) {
+		return
+	}
+	fmt.Printf("⚠️ Session %s ignored SIGTERM; killing it\n", session.ID)
+	if err := session.kill(); err != nil {
+		fmt.Printf("⚠️ Failed to kill session %s: %v\n", session.ID, err)
+	}
+	<-session.done
+}
+
+func (session *ShellSession) waitDone(timeout time.Duration) bool {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-session.done:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
@@ -0,0 +1,192 @@
+package spawn
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// processResources tracks what a session's process holds beyond the process itself.
+type processResources struct {
+	pgid      int      // Process group to clean up, if the process leads one
+	cgroupDir string   // The session's cgroup, if it has one
+	cgroupFD  *os.File // Open until the process has been started in the cgroup
+	newGroup  bool
+}
+
+// prepareProcess sets up a command's process group and cgroup before it is started.
+func prepareProcess(cmd *exec.Cmd, id string, opts Options) (processResources, error) {
+	res := processResources{newGroup: opts.NewProcessGroup || opts.PTY}
+	switch {
+	case opts.PTY:
+		// A new session with the terminal on stdin as its controlling terminal, so job
+		// control and ^C work. Its leader also leads a new process group.
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true}
+	case opts.NewProcessGroup:
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	}
+	if opts.Sandbox.enabled() {
+		if err := sandboxCommand(cmd, opts.Sandbox); err != nil {
+			return res, err
+		}
+	}
+	if opts.Cgroup.enabled() {
+		dir, fd, err := createCgroup(opts.Cgroup, "session-"+id)
+		if err != nil {
+			return res, err
+		}
+		res.cgroupDir, res.cgroupFD = dir, fd
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		// The child is cloned straight into the cgroup, so it is never outside its caps.
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(fd.Fd())
+	}
+	return res, nil
+}
+
+func (res *processResources) started(pid int) {
+	if res.newGroup {
+		res.pgid = pid
+	}
+	if res.cgroupFD != nil {
+		res.cgroupFD.Close()
+		res.cgroupFD = nil
+	}
+}
+
+// release kills whatever the process left running in its group and removes its cgroup.
+func (res *processResources) release() {
+	if res.cgroupFD != nil {
+		res.cgroupFD.Close()
+		res.cgroupFD = nil
+	}
+	if res.pgid > 0 {
+		syscall.Kill(-res.pgid, syscall.SIGKILL)
+	}
+	if res.cgroupDir != "" {
+		if err := os.Remove(res.cgroupDir); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Printf("⚠️ Failed to remove cgroup %s: %v\n", res.cgroupDir, err)
+		}
+	}
+}
+
+// createCgroup creates a cgroup v2 directory with the given caps and opens it for
+// SysProcAttr.CgroupFD.
+func createCgroup(opts CgroupOptions, name string) (string, *os.File, error) {
+	parent := opts.Parent
+	if parent == "" {
+		parent = DefaultCgroupParent
+	}
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return "", nil, fmt.Errorf("failed to create cgroup %s: %w", parent, err)
+	}
+	if _, err := os.Stat(filepath.Join(parent, "cgroup.controllers")); err != nil {
+		return "", nil, fmt.Errorf("%s is not a cgroup v2 directory: %w", parent, err)
+	}
+	// Let the session cgroups use the controllers. This fails harmlessly if they are
+	// already enabled or the parent isn't delegated to us; writing the caps below then
+	// reports the real problem.
+	os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("+memory +cpu"), 0)
+
+	dir := filepath.Join(parent, name)
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return "", nil, fmt.Errorf("failed to create cgroup %s: %w", dir, err)
+	}
+	settings := map[string]string{}
+	if opts.MemoryMax > 0 {
+		settings["memory.max"] = strconv.FormatUint(opts.MemoryMax, 10)
+	}
+	if opts.CPUs > 0 {
+		const period = 100000
+		settings["cpu.max"] = fmt.Sprintf("%d %d", int(opts.CPUs*period), period)
+	}
+	for file, value := range settings {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0); err != nil {
+			os.Remove(dir)
+			return "", nil, fmt.Errorf("failed to set %s in cgroup %s: %w", file, dir, err)
+		}
+	}
+	fd, err := os.Open(dir)
+	if err != nil {
+		os.Remove(dir)
+		return "", nil, err
+	}
+	return dir, fd, nil
+}
+
+// applyLimits sets the rlimits, priority and CPU affinity of a started process.
+func applyLimits(pid int, opts Options) error {
+	limits := []struct {
+		resource int
+		value    uint64
+	}{
+		{syscall.RLIMIT_AS, opts.Limits.AddressSpace},
+		{syscall.RLIMIT_CPU, uint64(opts.Limits.CPUTime.Seconds())},
+		{syscall.RLIMIT_NOFILE, opts.Limits.OpenFiles},
+	}
+	for _, l := range limits {
+		if l.value == 0 {
+			continue
+		}
+		if err := prlimit(pid, l.resource, &syscall.Rlimit{Cur: l.value, Max: l.value}); err != nil {
+			return fmt.Errorf("failed to set rlimit %d: %w", l.resource, err)
+		}
+	}
+	if opts.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, opts.Nice); err != nil {
+			return fmt.Errorf("failed to set priority: %w", err)
+		}
+	}
+	if len(opts.CPUs) > 0 {
+		if err := setAffinity(pid, opts.CPUs); err != nil {
+			return fmt.Errorf("failed to set CPU affinity: %w", err)
+		}
+	}
+	return nil
+}
+
+func prlimit(pid int, resource int, limit *syscall.Rlimit) error {
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(limit)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func setAffinity(pid int, cpus []int) error {
+	var mask [16]uint64 // Room for 1024 CPUs, the kernel's default cpu_set_t
+	for _, cpu := range cpus {
+		if cpu < 0 || cpu >= len(mask)*64 {
+			return fmt.Errorf("CPU %d is out of range", cpu)
+		}
+		mask[cpu/64] |= 1 << (cpu % 64)
+	}
+	_, _, errno := syscall.RawSyscall(syscall.SYS_SCHED_SETAFFINITY, uintptr(pid), unsafe.Sizeof(mask), uintptr(unsafe.Pointer(&mask)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// signal delivers sig to the process, or to its whole group if it leads one.
+func (session *ShellSession) signal(sig os.Signal) error {
+	if s, ok := sig.(syscall.Signal); ok && session.resources.newGroup {
+		return syscall.Kill(-session.Cmd.Process.Pid, s)
+	}
+	return session.Cmd.Process.Signal(sig)
+}
+
+func (session *ShellSession) terminate() error {
+	return session.signal(syscall.SIGTERM)
+}
+
+func (session *ShellSession) kill() error {
+	return session.signal(syscall.SIGKILL)
+}
@@ -0,0 +1,45 @@
+//go:build !linux
+
+package spawn
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// processResources tracks what a session's process holds beyond the process itself.
+// Process groups, limits and cgroups are only supported on Linux.
+type processResources struct{}
+
+func prepareProcess(cmd *exec.Cmd, id string, opts Options) (processResources, error) {
+	if opts.NewProcessGroup || opts.Sandbox.enabled() || !opts.Limits.isZero() || opts.Cgroup.enabled() || opts.Nice != 0 || len(opts.CPUs) > 0 {
+		return processResources{}, errors.New("process groups, sandboxes, limits, cgroups, priority and CPU affinity are only supported on Linux")
+	}
+	return processResources{}, nil
+}
+
+func (res *processResources) started(pid int) {}
+
+func (res *processResources) release() {}
+
+func applyLimits(pid int, opts Options) error {
+	return nil
+}
+
+func (session *ShellSession) signal(sig os.Signal) error {
+	return session.Cmd.Process.Signal(sig)
+}
+
+func (session *ShellSession) terminate() error {
+	if err := session.Cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		// Not every platform can deliver SIGTERM.
+		return session.Cmd.Process.Kill()
+	}
+	return nil
+}
+
+func (session *ShellSession) kill() error {
+	return session.Cmd.Process.Kill()
+}
@@ -0,0 +1,34 @@
+package spawn
+
+import "fmt"
+
+// Size of the terminal of a session started with Options.PTY until it is resized.
+const (
+	DefaultRows = 24
+	DefaultCols = 80
+)
+
+// HasPTY reports whether the session's process runs on a pseudo-terminal.
+func (session *ShellSession) HasPTY() bool {
+	return session.pty != nil
+}
+
+// Resize changes the terminal size of a session started with Options.PTY. Full-screen
+// programs such as top or vim redraw themselves for the new size.
+func (m *Manager) Resize(sessionID string, rows, cols uint16) error {
+	session, ok := m.GetSession(sessionID)
+	if !ok {
+		return errSessionNotFound(sessionID)
+	}
+	if session.pty == nil {
+		return fmt.Errorf("shell session %s has no terminal to resize", sessionID)
+	}
+	if rows == 0 || cols == 0 {
+		return fmt.Errorf("invalid terminal size %dx%d", cols, rows)
+	}
+	if err := setWindowSize(session.pty, rows, cols); err != nil {
+		return err
+	}
+	session.recording.event(CastResize, []byte(fmt.Sprintf("%dx%d", cols, rows)))
+	return nil
+}
@@ -0,0 +1,72 @@
+package spawn
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// openPTY allocates a pseudo-terminal and returns its master and the terminal the
+// process is started on.
+func openPTY() (*os.File, *os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open /dev/ptmx: %w", err)
+	}
+	var unlock int32
+	if err := ioctl(master, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to unlock pty: %w", err)
+	}
+	var n uint32
+	if err := ioctl(master, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to get pty number: %w", err)
+	}
+	tty, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("failed to open pty: %w", err)
+	}
+	if err := setWindowSize(master, DefaultRows, DefaultCols); err != nil {
+		master.Close()
+		tty.Close()
+		return nil, nil, err
+	}
+	return master, tty, nil
+}
+
+// setWindowSize sets the size of the terminal behind a pty master. The kernel sends
+// SIGWINCH to the terminal's foreground process group.
+func setWindowSize(master *os.File, rows, cols uint16) error {
+	size := struct{ rows, cols, xpixel, ypixel uint16 }{rows, cols, 0, 0}
+	if err := ioctl(master, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&size))); err != nil {
+		return fmt.Errorf("failed to set window size: %w", err)
+	}
+	return nil
+}
+
+func ioctl(f *os.File, request, arg uintptr) error {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg)
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// ptyClosed reports whether a read error from a pty master means the terminal is gone.
+// Linux reports EIO once the last process holding the terminal has exited.
+func ptyClosed(err error) bool {
+	return errors.Is(err, syscall.EIO)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package spawn
+
+import (
+	"errors"
+	"os"
+)
+
+var errPTYUnsupported = errors.New("pseudo-terminals are only supported on Linux")
+
+func openPTY() (*os.File, *os.File, error) {
+	return nil, nil, errPTYUnsupported
+}
+
+func setWindowSize(master *os.File, rows, cols uint16) error {
+	return errPTYUnsupported
+}
+
+func ptyClosed(err error) bool {
+	return false
+}
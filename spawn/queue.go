@@ -0,0 +1,142 @@
+package spawn
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrSessionBusy is returned when a session's request queue is already at its depth limit.
+var ErrSessionBusy = errors.New("session is busy: request queue is full")
+
+// DefaultMaxQueueDepth is the number of requests allowed to wait behind the in-flight
+// request of a newly created session. Use SetMaxQueueDepth to change it per session.
+var DefaultMaxQueueDepth = 16
+
+// requestQueue guarantees at most one in-flight request per session. Waiters are
+// served strictly in arrival order: a released slot is handed directly to the
+// oldest waiter rather than being raced for.
+type requestQueue struct {
+	mu       sync.Mutex
+	busy     bool
+	waiters  []chan struct{}
+	maxDepth int   // Maximum number of waiters; negative means unlimited
+	err      error // Set once the session is dead; every acquire fails with it
+}
+
+func newRequestQueue() *requestQueue {
+	return &requestQueue{maxDepth: DefaultMaxQueueDepth}
+}
+
+// acquire blocks until the caller owns the session or ctx is done. The returned
+// function releases the slot and must be called exactly once.
+func (q *requestQueue) acquire(ctx context.Context) (func(), error) {
+	q.mu.Lock()
+	if q.err != nil {
+		q.mu.Unlock()
+		return nil, q.err
+	}
+	if !q.busy {
+		q.busy = true
+		q.mu.Unlock()
+		return q.releaseFunc(), nil
+	}
+	if q.maxDepth >= 0 && len(q.waiters) >= q.maxDepth {
+		q.mu.Unlock()
+		return nil, ErrSessionBusy
+	}
+	ready := make(chan struct{})
+	q.waiters = append(q.waiters, ready)
+	q.mu.Unlock()
+
+	select {
+	case <-ready:
+		q.mu.Lock()
+		err := q.err
+		q.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return q.releaseFunc(), nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		for i, w := range q.waiters {
+			if w == ready {
+				q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+				q.mu.Unlock()
+				return nil, ctx.Err()
+			}
+		}
+		q.mu.Unlock()
+		// The slot was handed to us while we were giving up; pass it on.
+		q.release()
+		return nil, ctx.Err()
+	}
+}
+
+func (q *requestQueue) releaseFunc() func() {
+	var once sync.Once
+	return func() { once.Do(q.release) }
+}
+
+func (q *requestQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.waiters) == 0 {
+		q.busy = false
+		return
+	}
+	next := q.waiters[0]
+	q.waiters = q.waiters[1:]
+	close(next)
+}
+
+// fail rejects every waiting and future request with err.
+func (q *requestQueue) fail(err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.err = err
+	for _, w := range q.waiters {
+		close(w)
+	}
+	q.waiters = nil
+}
+
+func (q *requestQueue) depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiters)
+}
+
+// Acquire waits for exclusive use of a session, queueing behind any in-flight request.
+// It fails with ErrSessionBusy if the queue is full. The returned release function must
+// be called once the caller has finished with the session.
+func (m *Manager) Acquire(ctx context.Context, sessionID string) (func(), error) {
+	session, ok := m.GetSession(sessionID)
+	if !ok {
+		return nil, errSessionNotFound(sessionID)
+	}
+	return session.queue.acquire(ctx)
+}
+
+// SetMaxQueueDepth sets how many requests may wait behind the in-flight request of a
+// session before new ones are rejected with ErrSessionBusy. A negative depth disables the limit.
+func (m *Manager) SetMaxQueueDepth(sessionID string, depth int) error {
+	session, ok := m.GetSession(sessionID)
+	if !ok {
+		return errSessionNotFound(sessionID)
+	}
+	session.queue.mu.Lock()
+	session.queue.maxDepth = depth
+	session.queue.mu.Unlock()
+	return nil
+}
+
+// QueueDepth returns the number of requests currently waiting on a session.
+func (m *Manager) QueueDepth(sessionID string) int {
+	session, ok := m.GetSession(sessionID)
+	if !ok {
+		return 0
+	}
+	return session.queue.depth()
+}
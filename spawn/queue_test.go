@@ -0,0 +1,155 @@
+package spawn
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// waitForDepth waits until n requests are queued behind the one in flight.
+func waitForDepth(t *testing.T, q *requestQueue, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for q.depth() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue depth is %d, want %d", q.depth(), n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRequestQueueFIFO(t *testing.T) {
+	q := newRequestQueue()
+	release, err := q.acquire(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := make(chan int, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			release, err := q.acquire(context.Background())
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			order <- i
+			release()
+		}()
+		// Queue the waiters one at a time so their arrival order is known.
+		waitForDepth(t, q, i+1)
+	}
+	release()
+	for want := 0; want < 5; want++ {
+		if got := <-order; got != want {
+			t.Fatalf("waiter %d was served in position %d", got, want)
+		}
+	}
+	waitForDepth(t, q, 0)
+	if release, err := q.acquire(context.Background()); err != nil {
+		t.Errorf("queue is not free once everyone released: %v", err)
+	} else {
+		release()
+	}
+}
+
+func TestRequestQueueBusy(t *testing.T) {
+	q := newRequestQueue()
+	q.maxDepth = 2
+	release, _ := q.acquire(context.Background())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		go q.acquire(ctx)
+		waitForDepth(t, q, i+1)
+	}
+	if _, err := q.acquire(context.Background()); !errors.Is(err, ErrSessionBusy) {
+		t.Errorf("acquire on a full queue returned %v, want ErrSessionBusy", err)
+	}
+	cancel()
+	waitForDepth(t, q, 0)
+	release()
+}
+
+func TestRequestQueueCancelledWaiter(t *testing.T) {
+	q := newRequestQueue()
+	release, _ := q.acquire(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gaveUp := make(chan error)
+	go func() {
+		_, err := q.acquire(ctx)
+		gaveUp <- err
+	}()
+	waitForDepth(t, q, 1)
+	served := make(chan func())
+	go func() {
+		release, err := q.acquire(context.Background())
+		if err != nil {
+			t.Error(err)
+		}
+		served <- release
+	}()
+	waitForDepth(t, q, 2)
+
+	cancel()
+	if err := <-gaveUp; !errors.Is(err, context.Canceled) {
+		t.Errorf("cancelled waiter got %v, want context.Canceled", err)
+	}
+	waitForDepth(t, q, 1)
+	release()
+	select {
+	case release := <-served:
+		release()
+	case <-time.After(5 * time.Second):
+		t.Fatal("the slot was not handed past the cancelled waiter")
+	}
+}
+
+func TestRequestQueueFail(t *testing.T) {
+	q := newRequestQueue()
+	q.acquire(context.Background())
+	dead := errors.New("session closed")
+	waiter := make(chan error)
+	go func() {
+		_, err := q.acquire(context.Background())
+		waiter <- err
+	}()
+	waitForDepth(t, q, 1)
+	q.fail(dead)
+	if err := <-waiter; err != dead {
+		t.Errorf("waiter got %v, want %v", err, dead)
+	}
+	if _, err := q.acquire(context.Background()); err != dead {
+		t.Errorf("later acquire got %v, want %v", err, dead)
+	}
+}
+
+// TestAbandonedRequestIsDrained cancels a request while the process is still working on
+// it and checks that the next request neither starts early nor sees the old response.
+func TestAbandonedRequestIsDrained(t *testing.T) {
+	m := NewManager()
+	id, err := m.NewShellWithCommand("sh", "-c", `while read line; do sleep 0.3; echo "reply to $line"; echo END; done`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.CloseSession(id)
+	m.StartReading(id, OutputHandler, ErrorOutputHandler)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := m.SendCommandAndWaitContext(ctx, id, "first", "END"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("cancelled request returned %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	output, err := m.SendCommandAndWaitContext(ctx, id, "second", "END")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if output != "reply to second\n" {
+		t.Errorf("second request got %q, want only its own reply", output)
+	}
+}
@@ -0,0 +1,118 @@
+package spawn
+
+// DefaultBufferSize is how many bytes of each output stream a session keeps in memory
+// unless Options.BufferSize says otherwise.
+var DefaultBufferSize = 1 << 20
+
+// RingBuffer keeps the most recent bytes written to it, up to a fixed size. Every byte is
+// numbered by its offset in the stream, counting from the first byte ever written, so a
+// reader can resume from where it left off and tell when output it hasn't seen yet has
+// already been dropped. Memory is allocated as output arrives, never beyond the size.
+//
+// A RingBuffer is not safe for concurrent use; sessions guard theirs with their lock.
+type RingBuffer struct {
+	data  []byte
+	size  int
+	start int   // Index of the oldest retained byte in data
+	n     int   // Number of retained bytes
+	end   int64 // Offset just past the newest byte
+}
+
+// NewRingBuffer creates a buffer that keeps the last size bytes written to it.
+func NewRingBuffer(size int) *RingBuffer {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	return &RingBuffer{size: size}
+}
+
+// Write appends p, dropping the oldest bytes once the buffer is full. It never fails.
+func (r *RingBuffer) Write(p []byte) (int, error) {
+	written := len(p)
+	r.end += int64(written)
+	if len(p) >= r.size {
+		r.resize(r.size)
+		r.start, r.n = 0, copy(r.data, p[len(p)-r.size:])
+		return written, nil
+	}
+	if r.n+len(p) > len(r.data) && len(r.data) < r.size {
+		r.resize(min(r.size, max(2*len(r.data), r.n+len(p))))
+	}
+	c := len(r.data)
+	for len(p) > 0 {
+		k := copy(r.data[(r.start+r.n)%c:], p)
+		if over := r.n + k - c; over > 0 {
+			// The write ran over the oldest bytes.
+			r.start = (r.start + over) % c
+			r.n = c
+		} else {
+			r.n += k
+		}
+		p = p[k:]
+	}
+	return written, nil
+}
+
+// resize reallocates the buffer with the retained bytes moved to the front.
+func (r *RingBuffer) resize(size int) {
+	if len(r.data) == size && r.start == 0 {
+		return
+	}
+	data := make([]byte, size)
+	r.n = r.copyTo(data)
+	r.data, r.start = data, 0
+}
+
+// copyTo copies the retained bytes, oldest first, into dst and returns how many fit.
+func (r *RingBuffer) copyTo(dst []byte) int {
+	if r.n == 0 {
+		return 0
+	}
+	first := r.data[r.start:min(len(r.data), r.start+r.n)]
+	k := copy(dst, first)
+	if k < len(first) {
+		return k
+	}
+	return k + copy(dst[k:], r.data[:r.n-len(first)])
+}
+
+// Bytes returns a copy of the retained bytes.
+func (r *RingBuffer) Bytes() []byte {
+	data := make([]byte, r.n)
+	r.copyTo(data)
+	return data
+}
+
+// String returns the retained bytes as a string.
+func (r *RingBuffer) String() string {
+	return string(r.Bytes())
+}
+
+// Len returns the number of retained bytes.
+func (r *RingBuffer) Len() int {
+	return r.n
+}
+
+// Reset drops the retained bytes. Offsets keep counting from where they were.
+func (r *RingBuffer) Reset() {
+	r.data, r.start, r.n = nil, 0, 0
+}
+
+// Offset returns the offset just past the newest byte, i.e. the total written so far.
+func (r *RingBuffer) Offset() int64 {
+	return r.end
+}
+
+// Since returns the retained bytes from offset on, along with the offset of the first
+// byte returned. That is later than offset if the bytes in between have been dropped.
+func (r *RingBuffer) Since(offset int64) ([]byte, int64) {
+	first := r.end - int64(r.n)
+	if offset < first {
+		offset = first
+	}
+	if offset >= r.end {
+		return nil, r.end
+	}
+	all := r.Bytes()
+	return all[offset-first:], offset
+}
@@ -0,0 +1,111 @@
+package spawn
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestRingBufferWrites(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		writes []string
+		want   string
+	}{
+		{"under the size", 8, []string{"abc", "de"}, "abcde"},
+		{"exactly full", 4, []string{"ab", "cd"}, "abcd"},
+		{"write larger than size", 4, []string{"abcdefgh"}, "efgh"},
+		{"large write after small ones", 4, []string{"ab", "cdefghij"}, "ghij"},
+		{"wrap after growth", 6, []string{"a", "bc", "def", "gh"}, "cdefgh"},
+		{"wrap more than once", 3, []string{"ab", "cd", "ef", "g"}, "efg"},
+		{"write ending at the seam", 4, []string{"abc", "de", "fgh"}, "efgh"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRingBuffer(tt.size)
+			for _, w := range tt.writes {
+				if n, err := r.Write([]byte(w)); n != len(w) || err != nil {
+					t.Fatalf("Write(%q) = %d, %v", w, n, err)
+				}
+			}
+			if got := r.String(); got != tt.want {
+				t.Errorf("buffer holds %q, want %q", got, tt.want)
+			}
+			if total := int64(len(strings.Join(tt.writes, ""))); r.Offset() != total {
+				t.Errorf("Offset = %d, want %d", r.Offset(), total)
+			}
+			if cap(r.data) > tt.size {
+				t.Errorf("buffer allocated %d bytes for a size of %d", cap(r.data), tt.size)
+			}
+		})
+	}
+}
+
+func TestRingBufferSince(t *testing.T) {
+	r := NewRingBuffer(4)
+	r.Write([]byte("abcdef")) // Offsets 0-5; "cdef" is retained from offset 2
+
+	tests := []struct {
+		offset    int64
+		want      string
+		wantStart int64
+	}{
+		{0, "cdef", 2}, // Dropped: resumes at the oldest retained byte
+		{2, "cdef", 2},
+		{4, "ef", 4},
+		{6, "", 6},
+		{9, "", 6}, // Past the end
+	}
+	for _, tt := range tests {
+		got, start := r.Since(tt.offset)
+		if string(got) != tt.want || start != tt.wantStart {
+			t.Errorf("Since(%d) = %q, %d; want %q, %d", tt.offset, got, start, tt.want, tt.wantStart)
+		}
+	}
+}
+
+func TestRingBufferResetKeepsOffsets(t *testing.T) {
+	r := NewRingBuffer(8)
+	r.Write([]byte("hello"))
+	r.Reset()
+	if r.Len() != 0 || r.Offset() != 5 {
+		t.Fatalf("after Reset Len = %d and Offset = %d, want 0 and 5", r.Len(), r.Offset())
+	}
+	if got, start := r.Since(0); len(got) != 0 || start != 5 {
+		t.Errorf("Since(0) after Reset = %q, %d; want nothing from 5", got, start)
+	}
+	r.Write([]byte("world"))
+	if got, start := r.Since(0); string(got) != "world" || start != 5 {
+		t.Errorf("Since(0) = %q, %d; want \"world\" from 5", got, start)
+	}
+	if got, _ := r.Since(7); string(got) != "rld" {
+		t.Errorf("Since(7) = %q, want \"rld\"", got)
+	}
+}
+
+// TestRingBufferMatchesTail compares random writes against keeping the whole stream.
+func TestRingBufferMatchesTail(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	for _, size := range []int{1, 7, 64} {
+		r := NewRingBuffer(size)
+		var stream []byte
+		for i := 0; i < 500; i++ {
+			chunk := make([]byte, rng.Intn(2*size+2))
+			rng.Read(chunk)
+			r.Write(chunk)
+			stream = append(stream, chunk...)
+
+			tail := stream[max(0, len(stream)-size):]
+			if !bytes.Equal(r.Bytes(), tail) {
+				t.Fatalf("size %d, write %d: buffer does not hold the last %d bytes", size, i, size)
+			}
+			offset := rng.Int63n(int64(len(stream)) + 1)
+			got, start := r.Since(offset)
+			if want := max(offset, int64(len(stream)-len(tail))); start != want || !bytes.Equal(got, stream[want:]) {
+				t.Fatalf("size %d, write %d: Since(%d) started at %d, want %d", size, i, offset, start, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package spawn
+
+// Sandbox confines a session's process to a restricted environment. It is only supported
+// on Linux, and the server must run as root to use it.
+type Sandbox struct {
+	User       string   // Run as this user, by name or numeric ID
+	Namespaces bool     // Private mount, PID, IPC and UTS namespaces, with a /proc of its own
+	NoNetwork  bool     // A private network namespace with only a loopback interface
+	Chroot     string   // Directory the process sees as /
+	ReadOnly   []string // Paths, as seen by the server, the process may only read; needs Namespaces
+}
+
+func (s Sandbox) enabled() bool {
+	return s.User != "" || s.Namespaces || s.NoNetwork || s.Chroot != "" || len(s.ReadOnly) > 0
+}
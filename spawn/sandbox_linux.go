@@ -0,0 +1,194 @@
+package spawn
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+)
+
+// A sandboxed process is started through the server's own binary: the namespaces are
+// created when it is cloned, and the helper in init then sets up the mounts that can
+// only be made from inside them, drops privileges and executes the real command.
+const (
+	sandboxHelperName = "llm-cortex-sandbox"
+	sandboxSpecEnv    = "LLM_CORTEX_SANDBOX_SPEC"
+)
+
+// sandboxSpec is what the helper needs to know, passed to it in its environment.
+type sandboxSpec struct {
+	Path     string   `json:"path"`
+	Args     []string `json:"args"`
+	Dir      string   `json:"dir,omitempty"`
+	Chroot   string   `json:"chroot,omitempty"`
+	ReadOnly []string `json:"read_only,omitempty"`
+	Proc     bool     `json:"proc,omitempty"` // Mount a /proc for the new PID namespace
+	Cred     *cred    `json:"cred,omitempty"`
+}
+
+type cred struct {
+	UID    uint32   `json:"uid"`
+	GID    uint32   `json:"gid"`
+	Groups []uint32 `json:"groups,omitempty"`
+}
+
+func init() {
+	if len(os.Args) > 0 && os.Args[0] == sandboxHelperName && os.Getenv(sandboxSpecEnv) != "" {
+		runSandboxHelper()
+	}
+}
+
+// sandboxCommand rewrites cmd so its process starts inside sb.
+func sandboxCommand(cmd *exec.Cmd, sb Sandbox) error {
+	if len(sb.ReadOnly) > 0 && !sb.Namespaces {
+		return errors.New("read-only paths need a sandbox with namespaces")
+	}
+	var c *cred
+	if sb.User != "" {
+		var err error
+		if c, err = lookupCred(sb.User); err != nil {
+			return err
+		}
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if sb.NoNetwork {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	if !sb.Namespaces {
+		// Nothing needs to happen inside the process, so the kernel can do it all at exec.
+		cmd.SysProcAttr.Chroot = sb.Chroot
+		if c != nil {
+			cmd.SysProcAttr.Credential = &syscall.Credential{Uid: c.UID, Gid: c.GID, Groups: c.Groups}
+		}
+		return nil
+	}
+
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+	spec, err := json.Marshal(sandboxSpec{
+		Path:     cmd.Path,
+		Args:     cmd.Args,
+		Dir:      cmd.Dir,
+		Chroot:   sb.Chroot,
+		ReadOnly: sb.ReadOnly,
+		Proc:     true,
+		Cred:     c,
+	})
+	if err != nil {
+		return err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the server binary for the sandbox helper: %w", err)
+	}
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	cmd.Env = append(cmd.Env, sandboxSpecEnv+"="+string(spec))
+	cmd.Path, cmd.Args = self, []string{sandboxHelperName}
+	// The directory is relative to the new root, so the helper changes into it.
+	cmd.Dir = ""
+	return nil
+}
+
+func lookupCred(name string) (*cred, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return nil, fmt.Errorf("unknown sandbox user '%s'", name)
+		}
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	c := &cred{UID: uint32(uid), GID: uint32(gid)}
+	groups, _ := u.GroupIds()
+	for _, g := range groups {
+		if id, err := strconv.ParseUint(g, 10, 32); err == nil {
+			c.Groups = append(c.Groups, uint32(id))
+		}
+	}
+	return c, nil
+}
+
+// runSandboxHelper sets up the sandbox from inside its namespaces and executes the
+// sandboxed command in its place. It never returns.
+func runSandboxHelper() {
+	fail := func(format string, args ...interface{}) {
+		fmt.Fprintf(os.Stderr, "sandbox: "+format+"\n", args...)
+		os.Exit(126)
+	}
+	var spec sandboxSpec
+	if err := json.Unmarshal([]byte(os.Getenv(sandboxSpecEnv)), &spec); err != nil {
+		fail("bad spec: %v", err)
+	}
+	os.Unsetenv(sandboxSpecEnv)
+
+	// Keep the mounts below from propagating back to the server's namespace.
+	if err := syscall.Mount("", "/", "", syscall.MS_REC|syscall.MS_PRIVATE, ""); err != nil {
+		fail("failed to make mounts private: %v", err)
+	}
+	for _, path := range spec.ReadOnly {
+		if err := syscall.Mount(path, path, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			fail("failed to bind %s: %v", path, err)
+		}
+		if err := syscall.Mount("", path, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY, ""); err != nil {
+			fail("failed to make %s read-only: %v", path, err)
+		}
+	}
+	root := "/"
+	if spec.Chroot != "" {
+		root = spec.Chroot
+	}
+	if spec.Proc {
+		// The inherited /proc shows the server's PID namespace. A root without a /proc
+		// directory simply goes without.
+		proc := filepath.Join(root, "proc")
+		if _, err := os.Stat(proc); err == nil {
+			if err := syscall.Mount("proc", proc, "proc", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, ""); err != nil {
+				fail("failed to mount %s: %v", proc, err)
+			}
+		}
+	}
+	if spec.Chroot != "" {
+		if err := syscall.Chroot(spec.Chroot); err != nil {
+			fail("failed to chroot to %s: %v", spec.Chroot, err)
+		}
+		if err := os.Chdir("/"); err != nil {
+			fail("%v", err)
+		}
+	}
+	if spec.Dir != "" {
+		if err := os.Chdir(spec.Dir); err != nil {
+			fail("%v", err)
+		}
+	}
+	if c := spec.Cred; c != nil {
+		groups := make([]int, len(c.Groups))
+		for i, g := range c.Groups {
+			groups[i] = int(g)
+		}
+		if err := syscall.Setgroups(groups); err != nil {
+			fail("failed to set groups: %v", err)
+		}
+		if err := syscall.Setgid(int(c.GID)); err != nil {
+			fail("failed to set group: %v", err)
+		}
+		if err := syscall.Setuid(int(c.UID)); err != nil {
+			fail("failed to set user: %v", err)
+		}
+	}
+	err := syscall.Exec(spec.Path, spec.Args, os.Environ())
+	fail("failed to execute %s: %v", spec.Path, err)
+}
@@ -0,0 +1,223 @@
+package spawn
+
+import (
+	"bytes"
+	"sync"
+)
+
+// Stream identifies one of a session's output pipes.
+type Stream int
+
+const (
+	// StreamStdout is the session's standard output.
+	StreamStdout Stream = iota
+	// StreamStderr is the session's standard error.
+	StreamStderr
+)
+
+// Subscription receives every chunk a session's reader goroutine reads from a stream
+// after the subscription was created. Chunks are queued internally, so a slow
+// subscriber never blocks the reader and never loses data.
+type Subscription struct {
+	session *ShellSession
+	stream  Stream
+	from    int64 // Output before this offset was delivered some other way and is skipped
+
+	mu      sync.Mutex
+	pending []byte
+	ended   bool
+	notify  chan struct{}
+}
+
+// Subscribe registers a new subscriber on the given stream of a session.
+// The caller must call Close when it no longer needs the subscription.
+func (m *Manager) Subscribe(sessionID string, stream Stream) (*Subscription, error) {
+	session, ok := m.GetSession(sessionID)
+	if !ok {
+		return nil, errSessionNotFound(sessionID)
+	}
+	return session.subscribe(stream), nil
+}
+
+// Follow subscribes to a stream from an offset as counted by ShellSession.OutputSince.
+// What is still buffered from offset on is delivered first, then new output as it
+// arrives, with nothing lost or repeated in between. The returned offset is that of the
+// first byte delivered, which is past offset if older output has already been dropped.
+func (m *Manager) Follow(sessionID string, stream Stream, offset int64) (*Subscription, int64, error) {
+	session, ok := m.GetSession(sessionID)
+	if !ok {
+		return nil, 0, errSessionNotFound(sessionID)
+	}
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	backlog, start := session.outputSince(stream, offset)
+	return session.subscribeAt(stream, start+int64(len(backlog)), backlog), start, nil
+}
+
+// Ready returns a channel that is signalled whenever new data arrives or the stream ends.
+func (s *Subscription) Ready() <-chan struct{} {
+	return s.notify
+}
+
+// Next returns all data queued since the previous call. The boolean is false once
+// the stream has ended and every queued chunk has been consumed.
+func (s *Subscription) Next() ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data := s.pending
+	s.pending = nil
+	if len(data) > 0 && s.ended {
+		// The wake-up for the end may have been used up by this data; leave one for
+		// the call that reports it.
+		s.signal()
+	}
+	return data, len(data) > 0 || !s.ended
+}
+
+// Close unregisters the subscription from its session. A consumer blocked on Ready is
+// woken and sees the subscription as ended.
+func (s *Subscription) Close() {
+	s.session.subMu.Lock()
+	delete(s.session.subscribers[s.stream], s)
+	s.session.subMu.Unlock()
+	s.end()
+}
+
+func (s *Subscription) push(chunk []byte, offset int64) {
+	if skip := s.from - offset; skip > 0 {
+		if skip >= int64(len(chunk)) {
+			return
+		}
+		chunk = chunk[skip:]
+	}
+	s.mu.Lock()
+	s.pending = append(s.pending, chunk...)
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *Subscription) end() {
+	s.mu.Lock()
+	s.ended = true
+	s.mu.Unlock()
+	s.signal()
+}
+
+func (s *Subscription) signal() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+		// A wake-up is already pending; the subscriber will pick up the new data with it.
+	}
+}
+
+// subscribe registers a subscriber on a stream. If the stream has already ended the
+// subscription is returned in the ended state so waiters fail fast.
+func (session *ShellSession) subscribe(stream Stream) *Subscription {
+	return session.subscribeAt(stream, 0, nil)
+}
+
+// subscribeAt registers a subscriber that starts out with backlog queued and then only
+// receives output from offset from on.
+func (session *ShellSession) subscribeAt(stream Stream, from int64, backlog []byte) *Subscription {
+	sub := &Subscription{
+		session: session,
+		stream:  stream,
+		from:    from,
+		pending: backlog,
+		notify:  make(chan struct{}, 1),
+	}
+	if len(backlog) > 0 {
+		sub.signal()
+	}
+
+	session.subMu.Lock()
+	defer session.subMu.Unlock()
+	if session.subscribers == nil {
+		session.subscribers = make(map[Stream]map[*Subscription]struct{})
+	}
+	if session.streamEnded[stream] {
+		sub.end()
+		return sub
+	}
+	if session.subscribers[stream] == nil {
+		session.subscribers[stream] = make(map[*Subscription]struct{})
+	}
+	session.subscribers[stream][sub] = struct{}{}
+	return sub
+}
+
+// publish delivers a chunk read from a stream at offset to all of its subscribers.
+func (session *ShellSession) publish(stream Stream, chunk []byte, offset int64) {
+	session.subMu.Lock()
+	defer session.subMu.Unlock()
+	for sub := range session.subscribers[stream] {
+		sub.push(chunk, offset)
+	}
+}
+
+// endStream marks a stream as closed, wakes all of its subscribers and closes its transcript.
+func (session *ShellSession) endStream(stream Stream) {
+	if f := session.transcripts[stream]; f != nil {
+		f.Close()
+	}
+	session.subMu.Lock()
+	defer session.subMu.Unlock()
+	if session.streamEnded == nil {
+		session.streamEnded = make(map[Stream]bool)
+	}
+	session.streamEnded[stream] = true
+	for sub := range session.subscribers[stream] {
+		sub.end()
+	}
+	delete(session.subscribers, stream)
+}
+
+// delimiterMatcher searches for a delimiter incrementally as chunks arrive. Only the
+// bytes that could still complete a match are rescanned, so matching stays linear in
+// the size of the output regardless of how it is split into chunks.
+type delimiterMatcher struct {
+	delimiter []byte
+	buf       bytes.Buffer
+	scanFrom  int
+
+	// onOutput, if set, receives output as soon as it is known not to be part of the
+	// delimiter. emitted counts the bytes handed over so far.
+	onOutput func([]byte)
+	emitted  int
+}
+
+func newDelimiterMatcher(delimiter string) *delimiterMatcher {
+	return &delimiterMatcher{delimiter: []byte(delimiter)}
+}
+
+// Feed appends a chunk and reports whether the delimiter has been seen. When it has,
+// the returned string holds everything before its first occurrence.
+func (m *delimiterMatcher) Feed(chunk []byte) (string, bool) {
+	m.buf.Write(chunk)
+	data := m.buf.Bytes()
+	if i := bytes.Index(data[m.scanFrom:], m.delimiter); i >= 0 {
+		m.emit(data[:m.scanFrom+i])
+		return string(data[:m.scanFrom+i]), true
+	}
+	// The delimiter may straddle this chunk and the next one, so keep its possible prefix in range.
+	if next := len(data) - len(m.delimiter) + 1; next > m.scanFrom {
+		m.scanFrom = next
+	}
+	m.emit(data[:m.scanFrom])
+	return "", false
+}
+
+// emit hands the part of settled that hasn't been emitted yet to onOutput.
+func (m *delimiterMatcher) emit(settled []byte) {
+	if m.onOutput == nil || len(settled) <= m.emitted {
+		return
+	}
+	m.onOutput(settled[m.emitted:])
+	m.emitted = len(settled)
+}
+
+// Output returns everything fed to the matcher so far.
+func (m *delimiterMatcher) Output() string {
+	return m.buf.String()
+}
@@ -0,0 +1,53 @@
+package spawn
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDelimiterMatcher(t *testing.T) {
+	tests := []struct {
+		name      string
+		delimiter string
+		chunks    []string
+		want      string // Output before the delimiter
+		found     bool
+	}{
+		{"in one chunk", "\n> ", []string{"hello\n> "}, "hello", true},
+		{"split across two reads", "\n> ", []string{"hello\n", "> "}, "hello", true},
+		{"split byte by byte", "\n> ", []string{"h", "i", "\n", ">", " "}, "hi", true},
+		{"only the delimiter", "\n> ", []string{"\n> "}, "", true},
+		{"prefix in the output", "\n> ", []string{"a\n>b\n", "c\n> "}, "a\n>b\nc", true},
+		{"overlapping prefix", "\n> ", []string{"x\n", "\n", "> "}, "x\n", true},
+		{"first of several", "\n> ", []string{"one\n> two\n> "}, "one", true},
+		{"inside a later read", "END", []string{"line 1\n", "line 2 END line 3", "END"}, "line 1\nline 2 ", true},
+		{"not yet seen", "\n> ", []string{"partial\n", ">"}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newDelimiterMatcher(tt.delimiter)
+			var streamed strings.Builder
+			m.onOutput = func(b []byte) { streamed.Write(b) }
+
+			var got string
+			found := false
+			for _, chunk := range tt.chunks {
+				if got, found = m.Feed([]byte(chunk)); found {
+					break
+				}
+			}
+			if got != tt.want || found != tt.found {
+				t.Fatalf("Feed = %q, %v; want %q, %v", got, found, tt.want, tt.found)
+			}
+			if found && streamed.String() != got {
+				t.Errorf("onOutput received %q, want exactly the output %q", streamed.String(), got)
+			}
+			if !found && strings.Contains(streamed.String(), ">") {
+				t.Errorf("onOutput received %q, which could still be part of the delimiter", streamed.String())
+			}
+			if all := strings.Join(tt.chunks, ""); !strings.HasPrefix(all, m.Output()) {
+				t.Errorf("Output = %q, not a prefix of what was fed", m.Output())
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package spawn
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrSessionExited is returned for requests on a session whose process has exited.
+// The concrete error is an *ExitError describing how it ended.
+var ErrSessionExited = errors.New("session process exited")
+
+// stderrTailLines and stderrTailBytes bound how much of a dead process's stderr is kept
+// in its ExitError.
+const (
+	stderrTailLines = 20
+	stderrTailBytes = 4096
+)
+
+// streamDrainTimeout bounds how long the reaper waits for a dead process's output to be
+// read before it declares the session dead. Output normally ends together with the
+// process, unless a child it left behind still holds the pipes open.
+var streamDrainTimeout = time.Second
+
+// ExitError describes how a session's process ended.
+type ExitError struct {
+	SessionID string
+	Code      int    // Exit status, or -1 if the process was killed by a signal
+	Signal    string // The signal that killed the process, e.g. "killed"
+	Stderr    string // The last lines the process wrote to stderr
+	ExitedAt  time.Time
+}
+
+func (e *ExitError) Error() string {
+	var msg string
+	if e.Signal != "" {
+		msg = fmt.Sprintf("session %s was killed by signal: %s", e.SessionID, e.Signal)
+	} else {
+		msg = fmt.Sprintf("session %s exited with status %d", e.SessionID, e.Code)
+	}
+	if e.Stderr != "" {
+		msg += "; last stderr: " + e.Stderr
+	}
+	return msg
+}
+
+func (e *ExitError) Unwrap() error {
+	return ErrSessionExited
+}
+
+// Success reports whether the process exited with status 0.
+func (e *ExitError) Success() bool {
+	return e.Code == 0 && e.Signal == ""
+}
+
+// Done returns a channel that is closed once the session's process has exited and its
+// output has been read.
+func (session *ShellSession) Done() <-chan struct{} {
+	return session.done
+}
+
+// Exit returns how the session's process ended, or nil while it is still running.
+func (session *ShellSession) Exit() *ExitError {
+	select {
+	case <-session.done:
+		return session.exit
+	default:
+		return nil
+	}
+}
+
+// Exited returns a channel that receives the session's *ExitError once its process has
+// exited. It returns nil if the session doesn't exist.
+func (m *Manager) Exited(sessionID string) <-chan error {
+	session, ok := m.GetSession(sessionID)
+	if !ok {
+		return nil
+	}
+	exited := make(chan error, 1)
+	go func() {
+		<-session.done
+		exited <- session.exit
+	}()
+	return exited
+}
+
+// WaitExit waits up to timeout for a session's process to exit and returns how it ended,
+// or nil if it is still running or the session doesn't exist.
+func (m *Manager) WaitExit(sessionID string, timeout time.Duration) *ExitError {
+	session, ok := m.GetSession(sessionID)
+	if !ok {
+		return nil
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-session.done:
+		return session.exit
+	case <-timer.C:
+		return nil
+	}
+}
+
+// reap waits for the session's process to exit, then records how it ended and fails
+// every request waiting on it. It runs for the lifetime of every session, so a process
+// that dies on its own is noticed at once rather than when the next request times out.
+func (session *ShellSession) reap() {
+	// Process.Wait rather than Cmd.Wait: the latter closes the output pipes, which would
+	// cut off the readers before they have seen the process's last words.
+	state, err := session.Cmd.Process.Wait()
+
+	session.awaitStreams()
+	session.recording.Close()
+	exit := &ExitError{SessionID: session.ID, Code: -1, ExitedAt: time.Now()}
+	switch {
+	case err != nil:
+		fmt.Printf("⚠️ Failed to wait for session %s: %v\n", session.ID, err)
+	case state.ExitCode() >= 0:
+		exit.Code = state.ExitCode()
+	default:
+		exit.Signal = strings.TrimPrefix(state.String(), "signal: ")
+	}
+	session.mu.Lock()
+	exit.Stderr = tail(session.StderrBuf.String(), stderrTailLines, stderrTailBytes)
+	session.mu.Unlock()
+
+	session.exit = exit
+	session.queue.fail(exit)
+	close(session.done)
+	if !exit.Success() {
+		fmt.Printf("💀 %v\n", exit)
+	}
+}
+
+// awaitStreams waits, up to streamDrainTimeout, for the session's readers to reach the
+// end of its output.
+func (session *ShellSession) awaitStreams() {
+	subs := []*Subscription{session.subscribe(StreamStdout)}
+	if session.Stderr != nil {
+		subs = append(subs, session.subscribe(StreamStderr))
+	}
+	timer := time.NewTimer(streamDrainTimeout)
+	defer timer.Stop()
+	for _, sub := range subs {
+		defer sub.Close()
+		for open := true; open; {
+			select {
+			case <-sub.Ready():
+				_, open = sub.Next()
+			case <-timer.C:
+				return
+			}
+		}
+	}
+}
+
+// closedError explains why a session's output ended before a delimiter was seen: how the
+// process exited, if it does so shortly, or a generic error if it keeps running.
+func (session *ShellSession) closedError(delimiter []byte) error {
+	timer := time.NewTimer(2 * streamDrainTimeout)
+	defer timer.Stop()
+	select {
+	case <-session.done:
+		return session.exit
+	case <-timer.C:
+		return fmt.Errorf("session %s closed its output before delimiter %q was seen", session.ID, delimiter)
+	}
+}
+
+// tail returns the last lines of s, at most maxBytes long, without surrounding whitespace.
+func tail(s string, lines, maxBytes int) string {
+	s = strings.TrimSpace(s)
+	if len(s) > maxBytes {
+		s = s[len(s)-maxBytes:]
+		if i := strings.IndexByte(s, '\n'); i >= 0 {
+			s = s[i+1:]
+		}
+	}
+	for i, n := len(s), 0; i > 0; i-- {
+		if s[i-1] == '\n' {
+			if n++; n == lines {
+				return s[i:]
+			}
+		}
+	}
+	return s
+}
@@ -0,0 +1,142 @@
+package spawn
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Transcript defaults for sessions that enable transcripts without sizing them.
+var (
+	DefaultTranscriptMaxBytes int64 = 10 << 20
+	DefaultTranscriptFiles          = 3
+)
+
+// TranscriptOptions makes a session copy everything its process prints to files, so
+// output that has long left the in-memory buffers can still be inspected.
+type TranscriptOptions struct {
+	Dir      string // Directory for <session>.stdout.log and <session>.stderr.log; empty disables transcripts
+	MaxBytes int64  // Size at which a file is rotated; 0 uses DefaultTranscriptMaxBytes
+	MaxFiles int    // Rotated files kept next to the current one, e.g. .1 and .2; 0 uses DefaultTranscriptFiles
+}
+
+// rotatingFile is an append-only log that is renamed to path.1, path.2, ... once it
+// reaches maxBytes, keeping at most maxFiles old files.
+type rotatingFile struct {
+	path     string
+	maxBytes int64
+	maxFiles int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func openRotatingFile(path string, maxBytes int64, maxFiles int) (*rotatingFile, error) {
+	if maxBytes <= 0 {
+		maxBytes = DefaultTranscriptMaxBytes
+	}
+	if maxFiles <= 0 {
+		maxFiles = DefaultTranscriptFiles
+	}
+	f := &rotatingFile{path: path, maxBytes: maxBytes, maxFiles: maxFiles}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file, f.size = file, info.Size()
+	return nil
+}
+
+// Write appends p, rotating first if it would take the file past maxBytes.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return 0, os.ErrClosed
+	}
+	if f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+func (f *rotatingFile) rotate() error {
+	f.file.Close()
+	f.file = nil
+	os.Remove(fmt.Sprintf("%s.%d", f.path, f.maxFiles))
+	for i := f.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+	}
+	if err := os.Rename(f.path, f.path+".1"); err != nil {
+		return err
+	}
+	return f.open()
+}
+
+// Close closes the current file. Later writes fail.
+func (f *rotatingFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
+
+// openTranscripts opens a session's transcript files, if its options ask for them.
+func openTranscripts(id string, opts TranscriptOptions) (map[Stream]*rotatingFile, error) {
+	if opts.Dir == "" {
+		return nil, nil
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create transcript directory: %w", err)
+	}
+	transcripts := make(map[Stream]*rotatingFile)
+	for stream, name := range map[Stream]string{StreamStdout: "stdout", StreamStderr: "stderr"} {
+		f, err := openRotatingFile(filepath.Join(opts.Dir, fmt.Sprintf("%s.%s.log", id, name)), opts.MaxBytes, opts.MaxFiles)
+		if err != nil {
+			closeTranscripts(transcripts)
+			return nil, fmt.Errorf("failed to open transcript: %w", err)
+		}
+		transcripts[stream] = f
+	}
+	return transcripts, nil
+}
+
+func closeTranscripts(transcripts map[Stream]*rotatingFile) {
+	for _, f := range transcripts {
+		f.Close()
+	}
+}
+
+// transcribe appends a chunk read from a stream to the session's transcript, if any.
+func (session *ShellSession) transcribe(stream Stream, chunk []byte) {
+	f := session.transcripts[stream]
+	if f == nil {
+		return
+	}
+	if _, err := f.Write(chunk); err != nil && !errors.Is(err, os.ErrClosed) {
+		fmt.Printf("⚠️ Failed to write transcript for session %s: %v\n", session.ID, err)
+	}
+}
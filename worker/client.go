@@ -0,0 +1,218 @@
+package worker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	uuid "github.com/google/uuid"
+	"github.com/owen-6936/llm-cortex/spawn"
+)
+
+// ErrWorkerExited is returned for calls that were pending when the worker's stdout closed.
+var ErrWorkerExited = errors.New("worker process exited")
+
+// Client speaks the framed JSON-lines protocol with a worker running in a spawn session.
+// Responses are matched to requests by ID, so stray output and late replies to abandoned
+// requests can never be mistaken for the answer to the current one.
+type Client struct {
+	sessionID string
+	sub       *spawn.Subscription
+
+	mu      sync.Mutex
+	pending map[string]chan *Response
+	ready   chan struct{}
+	isReady bool
+
+	done chan struct{} // Closed when the worker's stdout ends
+}
+
+// NewClient attaches a protocol client to a session. It must be called before
+// spawn.StartReading so the worker's ready frame cannot be missed.
+func NewClient(sessionID string) (*Client, error) {
+	sub, err := spawn.Subscribe(sessionID, spawn.StreamStdout)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{
+		sessionID: sessionID,
+		sub:       sub,
+		pending:   make(map[string]chan *Response),
+		ready:     make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go c.dispatch()
+	return c, nil
+}
+
+// SessionID returns the ID of the spawn session the client talks to.
+func (c *Client) SessionID() string {
+	return c.sessionID
+}
+
+// Done returns a channel that is closed once the worker's stdout has ended.
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// WaitReady blocks until the worker announces it is ready, the worker exits, or ctx is done.
+func (c *Client) WaitReady(ctx context.Context) error {
+	select {
+	case <-c.ready:
+		return nil
+	case <-c.done:
+		return c.exitErr()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Call sends a request and decodes the result into result, which may be nil.
+// Calls on the same worker are serialized through the session's request queue.
+// If ctx is done before the response arrives, Call returns immediately while the
+// session stays reserved until the worker has answered, so its reply is dropped
+// instead of being read by the next caller.
+func (c *Client) Call(ctx context.Context, method string, params interface{}, result interface{}) (*Response, error) {
+	session, ok := spawn.GetSession(c.sessionID)
+	if !ok {
+		return nil, fmt.Errorf("shell session %s not found", c.sessionID)
+	}
+
+	release, err := spawn.Acquire(ctx, c.sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	id := uuid.New().String()
+	line, err := encodeRequest(Request{Version: ProtocolVersion, ID: id, Method: method, Params: params})
+	if err != nil {
+		release()
+		return nil, fmt.Errorf("failed to encode %s request: %w", method, err)
+	}
+
+	replies := make(chan *Response, 1)
+	c.mu.Lock()
+	c.pending[id] = replies
+	c.mu.Unlock()
+
+	if _, err := session.Stdin.Write(line); err != nil {
+		c.forget(id)
+		release()
+		return nil, err
+	}
+
+	select {
+	case resp := <-replies:
+		release()
+		return resp, decodeResponse(resp, result)
+	case <-c.done:
+		c.forget(id)
+		release()
+		return nil, c.exitErr()
+	case <-ctx.Done():
+		go c.abandon(id, replies, release)
+		return nil, ctx.Err()
+	}
+}
+
+// Close detaches the client from the session. Pending calls fail with ErrWorkerExited.
+func (c *Client) Close() {
+	c.sub.Close()
+}
+
+// abandon keeps the session reserved until the worker answers a cancelled request.
+func (c *Client) abandon(id string, replies chan *Response, release func()) {
+	defer release()
+	defer c.forget(id)
+	timer := time.NewTimer(spawn.RecoveryTimeout)
+	defer timer.Stop()
+	select {
+	case <-replies:
+	case <-c.done:
+	case <-timer.C:
+		fmt.Printf("⚠️ Worker %s did not answer cancelled request %s\n", c.sessionID, id)
+	}
+}
+
+// exitErr returns ErrWorkerExited, wrapping how the process ended if it exits shortly
+// after its stdout closed.
+func (c *Client) exitErr() error {
+	if exit := spawn.WaitExit(c.sessionID, 2*time.Second); exit != nil {
+		return fmt.Errorf("%w: %w", ErrWorkerExited, exit)
+	}
+	return ErrWorkerExited
+}
+
+func (c *Client) forget(id string) {
+	c.mu.Lock()
+	delete(c.pending, id)
+	c.mu.Unlock()
+}
+
+// dispatch splits the worker's stdout into lines and routes frames to their callers.
+func (c *Client) dispatch() {
+	var partial bytes.Buffer
+	defer close(c.done)
+	for range c.sub.Ready() {
+		chunk, open := c.sub.Next()
+		partial.Write(chunk)
+		for {
+			data := partial.Bytes()
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				break
+			}
+			c.handleLine(data[:i])
+			partial.Next(i + 1)
+		}
+		if !open {
+			return
+		}
+	}
+}
+
+func (c *Client) handleLine(line []byte) {
+	resp, isFrame, err := parseFrame(line)
+	if !isFrame {
+		return
+	}
+	if err != nil {
+		fmt.Printf("⚠️ Worker %s sent a bad frame: %v\n", c.sessionID, err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if resp.Event == EventReady {
+		if !c.isReady {
+			c.isReady = true
+			close(c.ready)
+		}
+		return
+	}
+	if replies, ok := c.pending[resp.ID]; ok {
+		delete(c.pending, resp.ID)
+		replies <- resp
+	}
+}
+
+// decodeResponse turns a response envelope into either a decoded result or a *RemoteError.
+func decodeResponse(resp *Response, result interface{}) error {
+	if !resp.OK {
+		if resp.Error != nil {
+			return resp.Error
+		}
+		return &RemoteError{Message: "worker reported failure without an error"}
+	}
+	if result == nil || len(resp.Result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(resp.Result, result); err != nil {
+		return fmt.Errorf("failed to parse worker result: %w", err)
+	}
+	return nil
+}
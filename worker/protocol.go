@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// ProtocolVersion is the version of the wire protocol spoken with worker processes.
+const ProtocolVersion = 1
+
+// FramePrefix marks a stdout line as a protocol frame. Every other line a worker prints
+// (log messages, library warnings) is treated as noise and never parsed as a response.
+const FramePrefix = "@@cortex:v1 "
+
+// EventReady is the event a worker emits once its model is loaded and it accepts requests.
+const EventReady = "ready"
+
+// Request is a single call sent to a worker as one line of JSON on its stdin.
+type Request struct {
+	Version int         `json:"v"`
+	ID      string      `json:"id"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params,omitempty"`
+}
+
+// Response is the envelope a worker writes back for each request. Unsolicited
+// notifications such as the ready signal carry an Event instead of an ID.
+type Response struct {
+	Version int                `json:"v"`
+	ID      string             `json:"id,omitempty"`
+	Event   string             `json:"event,omitempty"`
+	OK      bool               `json:"ok"`
+	Result  json.RawMessage    `json:"result,omitempty"`
+	Error   *RemoteError       `json:"error,omitempty"`
+	Timings map[string]float64 `json:"timings,omitempty"` // Milliseconds spent per phase, as reported by the worker
+}
+
+// RemoteError is an exception raised inside the worker while handling a request.
+type RemoteError struct {
+	Type    string `json:"type"`    // Python exception class, e.g. "FileNotFoundError"
+	Message string `json:"message"` // str() of the exception
+}
+
+func (e *RemoteError) Error() string {
+	if e.Type == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+}
+
+// encodeRequest renders a request as a single newline-terminated line.
+func encodeRequest(req Request) ([]byte, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// parseFrame decodes a stdout line. The boolean is false for lines that are not frames.
+func parseFrame(line []byte) (*Response, bool, error) {
+	line = bytes.TrimRight(line, "\r")
+	if !bytes.HasPrefix(line, []byte(FramePrefix)) {
+		return nil, false, nil
+	}
+	var resp Response
+	if err := json.Unmarshal(line[len(FramePrefix):], &resp); err != nil {
+		return nil, true, fmt.Errorf("malformed frame: %w", err)
+	}
+	if resp.Version != ProtocolVersion {
+		return nil, true, fmt.Errorf("unsupported protocol version %d (want %d)", resp.Version, ProtocolVersion)
+	}
+	return &resp, true, nil
+}